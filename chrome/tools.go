@@ -12,10 +12,12 @@ package chrome
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/css"
+	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 	"huan/helper"
@@ -180,6 +182,36 @@ func ClickOnElement(
 	}
 }
 
+// ClickIfPresent
+/*
+clicks the first element matching a css selector when it exists and is enabled, unlike ClickOnElement it never
+waits for the element to appear
+
+selector: the css selector of the element
+
+clicked: a pointer that will hold whether the element was clicked
+*/
+func ClickIfPresent(
+	selector string,
+	clicked *bool) chromedp.ActionFunc {
+	return func(c context.Context) error {
+		quoted, err := json.Marshal(selector)
+		if err != nil {
+			return err
+		}
+
+		jsCode := fmt.Sprintf(`(() => {
+			const e = document.querySelector(%s);
+			if (!e || e.disabled || e.getAttribute('aria-disabled') === 'true') return false;
+			e.scrollIntoView();
+			e.click();
+			return true;
+		})()`, quoted)
+
+		return chromedp.Evaluate(jsCode, clicked).Do(c)
+	}
+}
+
 // SleepForMs
 /*
 forces the browser to sleep for several ms amount of time
@@ -279,14 +311,14 @@ func populatedNode(
 	}
 }
 
-// scrollToPixel
+// ScrollToPixel
 /*
 xPos the x coordinate location to scroll too
 yPos the y coordinate location to scroll too
 
 scrolls to certain location on the screen
 */
-func scrollToPixel(xPos, yPos uint32) chromedp.ActionFunc {
+func ScrollToPixel(xPos, yPos uint32) chromedp.ActionFunc {
 	return func(ctx context.Context) error {
 		jsCode := fmt.Sprintf(`window.scrollTo(%d, %d);`, xPos, yPos)
 		_, exp, err := runtime.Evaluate(jsCode).Do(ctx)
@@ -302,13 +334,13 @@ func scrollToPixel(xPos, yPos uint32) chromedp.ActionFunc {
 	}
 }
 
-// scrollByPercentage
+// ScrollByPercentage
 /*
 percent the percent to scroll by
 
 scrolls to certain location on the screen
 */
-func scrollByPercentage(percent float32) (error, chromedp.ActionFunc) {
+func ScrollByPercentage(percent float32) (error, chromedp.ActionFunc) {
 
 	if percent > 1.0 || percent <= 0 {
 		return errors.New("percent must be greater than 0 and less than 1"), func(ctx context.Context) error {
@@ -331,6 +363,85 @@ func scrollByPercentage(percent float32) (error, chromedp.ActionFunc) {
 	}
 }
 
+// Link
+/*
+an anchor found on a webpage
+*/
+type Link struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// CollectLinks
+/*
+collects every anchor with an href on the webpage, hrefs are resolved against the page so they are always absolute
+
+links: a pointer to a slice that will store the anchors
+*/
+func CollectLinks(links *[]Link) chromedp.ActionFunc {
+	return func(c context.Context) error {
+		jsCode := `Array.from(document.querySelectorAll('a[href]'), a => ({href: a.href, text: a.innerText.trim().slice(0, 100)}))`
+		return chromedp.Evaluate(jsCode, links).Do(c)
+	}
+}
+
+// PageMetrics
+/*
+measures the page in css pixels
+
+viewport: a pointer that will hold the height of the visible area of the page
+
+scrollHeight: a pointer that will hold the height of the entire page
+*/
+func PageMetrics(
+	viewport *int64,
+	scrollHeight *int64) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		var metrics []int64
+		jsCode := `[window.innerHeight, Math.max(document.body.scrollHeight, document.documentElement.scrollHeight)]`
+
+		err := chromedp.Evaluate(jsCode, &metrics).Do(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(metrics) != 2 {
+			return errors.New("failed to measure the page")
+		}
+
+		*viewport, *scrollHeight = metrics[0], metrics[1]
+		return nil
+	}
+}
+
+// TakeViewportScreenshot
+/*
+takes a screenshot of the visible area of the webpage
+
+quality: how high resolution the image should be, 100 produces a png anything lower a jpeg
+
+buffer: a pointer to a slice that will store the data
+*/
+func TakeViewportScreenshot(
+	quality uint8,
+	buffer *[]byte) chromedp.ActionFunc {
+	return func(c context.Context) error {
+		capture := page.CaptureScreenshot()
+
+		if quality < 100 {
+			capture = capture.WithFormat(page.CaptureScreenshotFormatJpeg).WithQuality(int64(quality))
+		}
+
+		data, err := capture.Do(c)
+		if err != nil {
+			return err
+		}
+
+		*buffer = data
+		return nil
+	}
+}
+
 //func (b *Executor) AcquireLocation(snapshot string) {
 //	var loc string
 //
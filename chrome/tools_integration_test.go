@@ -182,7 +182,7 @@ func TestHtml(t *testing.T) {
 func TestScrollToPixel(t *testing.T) {
 	nav := getNavigateCommand()
 	sle := SleepForMs(2000)
-	scroll := scrollToPixel(0, 500)
+	scroll := ScrollToPixel(0, 500)
 	err := actionRunner(7, nav, sle, scroll)
 
 	if err != nil {
@@ -193,7 +193,7 @@ func TestScrollToPixel(t *testing.T) {
 func TestScrollByPercentage(t *testing.T) {
 	nav := getNavigateCommand()
 	sle := SleepForMs(2000)
-	err, scroll := scrollByPercentage(1)
+	err, scroll := ScrollByPercentage(1)
 	sle2 := SleepForMs(2000)
 
 	if err != nil {
@@ -0,0 +1,149 @@
+package jsonparser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+/*
+Span
+
+the byte range a value occupies in the string it was parsed from, End is exclusive
+*/
+type Span struct {
+	Start int
+	End   int
+}
+
+/*
+Overlaps
+
+checks if two spans share at least one byte
+*/
+func (s Span) Overlaps(other Span) bool {
+	return s.Start < other.End && other.Start < s.End
+}
+
+/*
+Located
+
+a json object found in a string, along with where it and each of its top level values are positioned
+*/
+type Located struct {
+	Sample map[string]interface{}
+	Span   Span
+	Fields map[string]Span
+}
+
+/*
+locateFields
+
+walks the top level members of a json object and records the span of each value, string values exclude their
+quotes so an empty string has an empty span. offset is the position of the object in the original string
+*/
+func locateFields(object string, offset int) (error, map[string]Span) {
+	fields := map[string]Span{}
+	decoder := json.NewDecoder(strings.NewReader(object))
+
+	if _, err := decoder.Token(); err != nil {
+		return err, nil
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+
+		if err != nil {
+			return err, nil
+		}
+
+		key, _ := token.(string)
+
+		var raw json.RawMessage
+		if err = decoder.Decode(&raw); err != nil {
+			return err, nil
+		}
+
+		end := int(decoder.InputOffset())
+		start := end - len(raw)
+
+		// an empty string gets an empty span positioned at its closing quote
+		if len(raw) >= 2 && raw[0] == '"' {
+			start++
+			end--
+		}
+
+		fields[key] = Span{Start: start + offset, End: end + offset}
+	}
+
+	return nil, fields
+}
+
+/*
+Locate
+
+finds every top level json object in a string and the position of its values, objects that fail to parse are
+skipped. unlike ToJson braces inside of json strings are ignored
+*/
+func Locate(data string) []Located {
+	var located []Located
+
+	var depth int
+	var start int
+	var inString bool
+	var escaped bool
+
+	for index := 0; index < len(data); index++ {
+		char := data[index]
+
+		if inString {
+			if escaped {
+				escaped = false
+			} else if char == '\\' {
+				escaped = true
+			} else if char == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch char {
+		case '"':
+			if depth > 0 {
+				inString = true
+			}
+		case '{':
+			if depth == 0 {
+				start = index
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+
+			depth--
+
+			if depth == 0 {
+				object := data[start : index+1]
+
+				var sample map[string]interface{}
+				if err := json.Unmarshal([]byte(object), &sample); err != nil {
+					continue
+				}
+
+				err, fields := locateFields(object, start)
+				if err != nil {
+					continue
+				}
+
+				located = append(located, Located{
+					Sample: sample,
+					Span:   Span{Start: start, End: index + 1},
+					Fields: fields,
+				})
+			}
+		}
+	}
+
+	return located
+}
@@ -77,3 +77,53 @@ func TestToJson(t *testing.T) {
 		}
 	})
 }
+
+func TestLocate(t *testing.T) {
+
+	t.Run("test values are located", func(t *testing.T) {
+		data := "```json\n[{\"name\": \"huan\", \"age\": 3, \"tags\": [\"a\"]}, {\"name\": \"be}ren\"}]```"
+
+		located := Locate(data)
+
+		if len(located) != 2 {
+			t.Fatalf("expected 2 objects got %d", len(located))
+		}
+
+		name := located[0].Fields["name"]
+		if data[name.Start:name.End] != "huan" {
+			t.Errorf("name span points to %s", data[name.Start:name.End])
+		}
+
+		age := located[0].Fields["age"]
+		if data[age.Start:age.End] != "3" {
+			t.Errorf("age span points to %s", data[age.Start:age.End])
+		}
+
+		tags := located[0].Fields["tags"]
+		if data[tags.Start:tags.End] != `["a"]` {
+			t.Errorf("tags span points to %s", data[tags.Start:tags.End])
+		}
+
+		other := located[1].Fields["name"]
+		if data[other.Start:other.End] != "be}ren" {
+			t.Errorf("braces in strings were not ignored, span points to %s", data[other.Start:other.End])
+		}
+	})
+
+	t.Run("test empty strings have empty spans", func(t *testing.T) {
+		data := `[{"name": "", "age": 3}]`
+		name := Locate(data)[0].Fields["name"]
+
+		if name.Start != name.End || data[name.Start] != '"' {
+			t.Errorf("expected an empty span at the closing quote got %v", name)
+		}
+	})
+
+	t.Run("test broken objects are skipped", func(t *testing.T) {
+		located := Locate(`{"one":} 1} {"two": 2}`)
+
+		if len(located) != 1 {
+			t.Errorf("expected 1 object got %d", len(located))
+		}
+	})
+}
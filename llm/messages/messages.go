@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"huan/helper"
+	"slices"
 	"strings"
 )
 
@@ -132,6 +133,19 @@ func (c *ConversationBuilder) AddStandardMessage(mess *StandardMessage) *Convers
 	return c
 }
 
+/*
+InsertStandardMessage
+
+places a standard message at index, the messages from index onwards are moved back. the conversation is copied so
+conversations that were already built are not altered
+*/
+func (c *ConversationBuilder) InsertStandardMessage(index int, mess *StandardMessage) *ConversationBuilder {
+	c.messageTypes = slices.Insert(slices.Clip(c.messageTypes), index, "standard")
+	c.roles = slices.Insert(slices.Clip(c.roles), index, mess.Role)
+	c.conversation = slices.Insert(slices.Clip(c.conversation), index, message(mess))
+	return c
+}
+
 /*
 imageContent
 
@@ -277,6 +291,19 @@ func (c *ConversationBuilder) AddMultimodalMessage(mess *MultiModalMessage) *Con
 	return c
 }
 
+/*
+InsertMultimodalMessage
+
+places a multimodal message at index, the messages from index onwards are moved back. the conversation is copied
+so conversations that were already built are not altered
+*/
+func (c *ConversationBuilder) InsertMultimodalMessage(index int, mess *MultiModalMessage) *ConversationBuilder {
+	c.messageTypes = slices.Insert(slices.Clip(c.messageTypes), index, "multimodal")
+	c.roles = slices.Insert(slices.Clip(c.roles), index, mess.Role)
+	c.conversation = slices.Insert(slices.Clip(c.conversation), index, message(mess))
+	return c
+}
+
 /*
 ToolCall
 
@@ -346,10 +373,58 @@ func (c *ConversationBuilder) AddAssistantMessage(mess *AssistantMessage) *Conve
 	return c
 }
 
+/*
+ToolMessage
+
+the result of a tool call, sent back to the llm in response to an assistant message that requested it
+*/
+type ToolMessage struct {
+	Role       string `json:"role"`
+	Content    string `json:"content"`
+	ToolCallId string `json:"tool_call_id"`
+}
+
+/*
+validate
+
+assures a ToolMessage is compliant
+*/
+func (t *ToolMessage) validate() error {
+	roles := [1]string{
+		"tool",
+	}
+
+	if !containsRole(roles[:], t.Role) {
+		return invalidRoleError(roles[:], t.Role)
+	}
+
+	if t.ToolCallId == "" {
+		return errors.New("ToolMessage ToolCallId cannot be empty")
+	}
+
+	return nil
+}
+
+/*
+AddToolMessage
+
+adds the result of a tool call to the conversation
+*/
+func (c *ConversationBuilder) AddToolMessage(mess *ToolMessage) *ConversationBuilder {
+	c.messageTypes = append(c.messageTypes, "tool")
+	c.roles = append(c.roles, mess.Role)
+	c.conversation = append(c.conversation, mess)
+	return c
+}
+
 func (c *ConversationBuilder) GetMessageType(index int) string {
 	return c.messageTypes[index]
 }
 
+func (c *ConversationBuilder) GetRole(index int) string {
+	return c.roles[index]
+}
+
 func (c *ConversationBuilder) ConvertToAssistant(index int) *AssistantMessage {
 	if c.GetMessageType(index) != "assistant" {
 		panic(fmt.Sprintf("cannot convert message of type %s to assistant", c.GetMessageType(index)))
@@ -386,6 +461,32 @@ func (c *ConversationBuilder) ConvertToStandard(index int) *StandardMessage {
 	}
 }
 
+func (c *ConversationBuilder) ConvertToTool(index int) *ToolMessage {
+	if c.GetMessageType(index) != "tool" {
+		panic(fmt.Sprintf("cannot convert message of type %s to tool", c.GetMessageType(index)))
+	}
+
+	if val, ok := c.conversation[index].(*ToolMessage); ok {
+		return val
+	} else {
+		panic("could not convert to tool message")
+	}
+}
+
+/*
+Clone
+
+copies the conversation so messages can be added to or removed from the copy without changing the original. the
+messages are shared, they are replaced rather than altered once added
+*/
+func (c *ConversationBuilder) Clone() *ConversationBuilder {
+	return &ConversationBuilder{
+		conversation: slices.Clone(c.conversation),
+		roles:        slices.Clone(c.roles),
+		messageTypes: slices.Clone(c.messageTypes),
+	}
+}
+
 func (c *ConversationBuilder) Pop(index int) *ConversationBuilder {
 	delMes := helper.DeleteByIndex[message]
 	delStr := helper.DeleteByIndex[string]
@@ -408,8 +509,15 @@ func (c *ConversationBuilder) Build() (error, Conversation) {
 		}
 	}
 
-	if c.roles[len(c.roles)-1] != "user" || c.messageTypes[len(c.messageTypes)-1] == "assistant" {
-		return errors.New("last message in any conversation must be from the user"), nil
+	if len(c.roles) == 0 {
+		return errors.New("conversation is empty"), nil
+	}
+
+	last := len(c.roles) - 1
+
+	// a tool result is answered by the assistant the same way a user message is
+	if (c.roles[last] != "user" && c.roles[last] != "tool") || c.messageTypes[last] == "assistant" {
+		return errors.New("last message in any conversation must be from the user or a tool"), nil
 	}
 
 	return nil, c.conversation
@@ -454,7 +562,7 @@ the log probabilities generated by the chatbot
 */
 type LogprobContent struct {
 	Token   string   `json:"token"`
-	Logprob int32    `json:"logprob"`
+	Logprob float64  `json:"logprob"`
 	Bytes   *[]int32 `json:"bytes"`
 }
 
@@ -468,16 +576,25 @@ type FullLogprobContent struct {
 	TopLogprobs []LogprobContent `json:"top_logprobs"`
 }
 
+/*
+Logprobs
+
+the log probabilities of every token in a choice, in the order they were generated
+*/
+type Logprobs struct {
+	Content []FullLogprobContent `json:"content"`
+}
+
 /*
 Choice
 
 the response choices provided by the chatbot
 */
 type Choice struct {
-	FinishReason string              `json:"finish_reason"`
-	Index        int32               `json:"index"`
-	Message      Message             `json:"message"`
-	Logprobs     *FullLogprobContent `json:"logprobs,omitempty"`
+	FinishReason string    `json:"finish_reason"`
+	Index        int32     `json:"index"`
+	Message      Message   `json:"message"`
+	Logprobs     *Logprobs `json:"logprobs,omitempty"`
 }
 
 /*
@@ -584,3 +584,24 @@ func TestConversationBuilder_Build(t *testing.T) {
 
 	})
 }
+
+func TestConversationBuilder_Clone(t *testing.T) {
+	original := &ConversationBuilder{}
+	original.AddStandardMessage(&StandardMessage{Role: "system", Content: "collect dogs"})
+
+	first := original.Clone().AddStandardMessage(&StandardMessage{Role: "user", Content: "huan"})
+	second := original.Clone().AddStandardMessage(&StandardMessage{Role: "user", Content: "beren"})
+	first.Pop(0)
+
+	if original.Size() != 1 || original.GetRole(0) != "system" {
+		t.Fatalf("the original conversation was changed, it has %d messages", original.Size())
+	}
+
+	if first.Size() != 1 || first.ConvertToStandard(0).Content != "huan" {
+		t.Error("the first copy was changed by the second")
+	}
+
+	if second.Size() != 2 || second.ConvertToStandard(1).Content != "beren" {
+		t.Error("the second copy was changed by the first")
+	}
+}
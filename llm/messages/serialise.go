@@ -0,0 +1,295 @@
+package messages
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+decodeMessage
+
+converts a message in the chat completion request format back into its typed message. the type is inferred from the
+role and content, assistant and tool messages have their own roles while multimodal content is an array
+*/
+func decodeMessage(data json.RawMessage) (error, string, message) {
+	header := struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}{}
+
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err, "", nil
+	}
+
+	var mType string
+	var mess message
+
+	switch {
+	case header.Role == "assistant":
+		mType, mess = "assistant", &AssistantMessage{}
+	case header.Role == "tool":
+		mType, mess = "tool", &ToolMessage{}
+	case len(header.Content) > 0 && header.Content[0] == '[':
+		mType, mess = "multimodal", &MultiModalMessage{}
+	case header.Role != "":
+		mType, mess = "standard", &StandardMessage{}
+	default:
+		return errors.New("message is missing its role"), "", nil
+	}
+
+	if err := json.Unmarshal(data, mess); err != nil {
+		return err, "", nil
+	}
+
+	return nil, mType, mess
+}
+
+/*
+UnmarshalJSON
+
+decodes a conversation in the chat completion request format into typed messages
+*/
+func (c *Conversation) UnmarshalJSON(data []byte) error {
+	builder := &ConversationBuilder{}
+
+	if err := builder.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	*c = builder.conversation
+	return nil
+}
+
+/*
+MarshalJSON
+
+encodes the conversation in the chat completion request format
+*/
+func (c *ConversationBuilder) MarshalJSON() ([]byte, error) {
+	if c.conversation == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(c.conversation)
+}
+
+/*
+UnmarshalJSON
+
+replaces the conversation with one in the chat completion request format, the type of every message is restored
+*/
+func (c *ConversationBuilder) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	builder := ConversationBuilder{}
+
+	for index, rawMessage := range raw {
+		err, mType, mess := decodeMessage(rawMessage)
+
+		if err != nil {
+			return fmt.Errorf("could not decode message %d: %v", index, err)
+		}
+
+		builder.conversation = append(builder.conversation, mess)
+		builder.messageTypes = append(builder.messageTypes, mType)
+		builder.roles = append(builder.roles, roleOf(mess))
+	}
+
+	*c = builder
+	return nil
+}
+
+func roleOf(mess message) string {
+	switch m := mess.(type) {
+	case *StandardMessage:
+		return m.Role
+	case *MultiModalMessage:
+		return m.Role
+	case *AssistantMessage:
+		return m.Role
+	case *ToolMessage:
+		return m.Role
+	}
+
+	return ""
+}
+
+/*
+NewConversationBuilder
+
+creates a builder holding an existing conversation so it can be continued
+*/
+func NewConversationBuilder(convo Conversation) (error, *ConversationBuilder) {
+	data, err := json.Marshal(convo)
+
+	if err != nil {
+		return err, nil
+	}
+
+	builder := &ConversationBuilder{}
+	return builder.UnmarshalJSON(data), builder
+}
+
+/*
+Save
+
+writes the conversation to a json file
+*/
+func (c *ConversationBuilder) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+/*
+LoadConversation
+
+reads a conversation saved as json
+*/
+func LoadConversation(path string) (error, *ConversationBuilder) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err, nil
+	}
+
+	builder := &ConversationBuilder{}
+
+	if err = json.Unmarshal(data, builder); err != nil {
+		return err, nil
+	}
+
+	return nil, builder
+}
+
+/*
+TranscriptEntry
+
+a single conversation in a transcript, it usually ends with the assistant's response
+*/
+type TranscriptEntry struct {
+	Time         time.Time            `json:"time"`
+	Label        string               `json:"label,omitempty"` // what the conversation was about, such as the url and chunk
+	Conversation *ConversationBuilder `json:"conversation"`
+}
+
+// returned when a conversation is appended to a transcript that was closed
+var ErrTranscriptClosed = errors.New("the transcript is closed")
+
+/*
+Transcript
+
+appends conversations to a jsonl file, one per line
+*/
+type Transcript struct {
+	lock   sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+/*
+NewTranscript
+
+opens a transcript, conversations are appended to the file when it already exists
+*/
+func NewTranscript(path string) (error, *Transcript) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, &Transcript{file: file}
+}
+
+/*
+Append
+
+writes a conversation as a line of the transcript
+*/
+func (t *Transcript) Append(label string, convo Conversation) error {
+	data, err := json.Marshal(TranscriptEntry{
+		Time:         time.Now(),
+		Label:        label,
+		Conversation: &ConversationBuilder{conversation: convo},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return ErrTranscriptClosed
+	}
+
+	_, err = t.file.Write(append(data, '\n'))
+	return err
+}
+
+/*
+Close
+
+closes the transcript file once the conversation being appended is written, later appends return
+ErrTranscriptClosed
+*/
+func (t *Transcript) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+	return t.file.Close()
+}
+
+/*
+ReadTranscript
+
+reads every conversation in a jsonl transcript
+*/
+func ReadTranscript(path string) (error, []TranscriptEntry) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err, nil
+	}
+
+	defer file.Close()
+
+	var entries []TranscriptEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // screenshots make for very long lines
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry TranscriptEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("could not read line %d of the transcript: %v", line, err), nil
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return scanner.Err(), entries
+}
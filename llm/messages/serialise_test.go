@@ -0,0 +1,180 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func getMixedBuilder() *ConversationBuilder {
+	answer := `[{"name": "huan"}]`
+	name := "collector"
+
+	call := ToolCall{Id: "call-1", Type: "function"}
+	call.Function.Name = "lookup"
+	call.Function.Arguments = `{"name": "huan"}`
+
+	mm := MultiModalMessage{Role: "user"}
+	mm.AppendText("the page")
+	mm.AppendImageBytes([]byte("image"), nil, "png")
+
+	builder := &ConversationBuilder{}
+	builder.AddStandardMessage(&StandardMessage{Role: "system", Content: "collect data", Name: &name}).
+		AddMultimodalMessage(&mm).
+		AddAssistantMessage(&AssistantMessage{Role: "assistant", ToolCalls: &[]ToolCall{call}}).
+		AddToolMessage(&ToolMessage{Role: "tool", Content: "found", ToolCallId: "call-1"}).
+		AddAssistantMessage(&AssistantMessage{Role: "assistant", Content: &answer}).
+		AddStandardMessage(&StandardMessage{Role: "user", Content: "collect more"})
+
+	return builder
+}
+
+func TestConversationBuilder_SaveLoad(t *testing.T) {
+	builder := getMixedBuilder()
+	path := filepath.Join(t.TempDir(), "conversation.json")
+
+	if err := builder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	err, loaded := LoadConversation(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(builder, loaded) {
+		t.Errorf("loaded conversation differs from the saved one\nsaved  %+v\nloaded %+v", builder, loaded)
+	}
+
+	if loaded.ConvertToTool(3).ToolCallId != "call-1" {
+		t.Error("tool message was not restored")
+	}
+
+	if err, _ = loaded.Build(); err != nil {
+		t.Errorf("loaded conversation is invalid: %v", err)
+	}
+}
+
+func TestConversation_UnmarshalJSON(t *testing.T) {
+	err, convo := getMixedBuilder().Build()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(convo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Conversation
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(convo, decoded) {
+		t.Error("conversation did not survive a round trip")
+	}
+
+	if err = json.Unmarshal([]byte(`[{"content": "no role"}]`), &decoded); err == nil {
+		t.Error("a message without a role was accepted")
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	err, transcript := NewTranscript(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, convo := getMixedBuilder().Build()
+
+	for _, label := range []string{"chunk 1", "chunk 2"} {
+		if err = transcript.Append(label, convo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = transcript.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = transcript.Append("chunk 3", convo); !errors.Is(err, ErrTranscriptClosed) {
+		t.Errorf("expected appending to a closed transcript to fail got %v", err)
+	}
+
+	err, entries := ReadTranscript(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[1].Label != "chunk 2" {
+		t.Fatalf("unexpected transcript entries %+v", entries)
+	}
+
+	if !reflect.DeepEqual(entries[0].Conversation, getMixedBuilder()) {
+		t.Error("transcript conversation differs from the one written")
+	}
+}
+
+func TestTranscript_Close_concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	_, transcript := NewTranscript(path)
+	_, convo := getMixedBuilder().Build()
+	wg := sync.WaitGroup{}
+
+	// chunk workers may still be writing when the session closes the transcript
+	for range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 20 {
+				if err := transcript.Append("chunk", convo); err != nil && !errors.Is(err, ErrTranscriptClosed) {
+					t.Errorf("unexpected append error %v", err)
+				}
+			}
+		}()
+	}
+
+	if err := transcript.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	// every line written before the transcript was closed is complete
+	if err, _ := ReadTranscript(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConversationBuilder_Build_tool(t *testing.T) {
+	builder := &ConversationBuilder{}
+
+	if err, _ := builder.Build(); err == nil {
+		t.Error("an empty conversation was built")
+	}
+
+	builder.AddStandardMessage(&StandardMessage{Role: "user", Content: "collect"}).
+		AddToolMessage(&ToolMessage{Role: "tool", Content: "found"})
+
+	if err, _ := builder.Build(); err == nil {
+		t.Error("a tool message without a call id was accepted")
+	}
+
+	builder.ConvertToTool(1).ToolCallId = "call-1"
+
+	if err, _ := builder.Build(); err != nil {
+		t.Errorf("a conversation ending with a tool result was rejected: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huan/llm/messages"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+/*
+Batcher
+
+a llm that can complete many chat requests asynchronously through a batch job
+*/
+type Batcher interface {
+	BatchLine(customId string, convo messages.Conversation, opts *Options) (error, []byte)
+	UploadBatchFile(ctx context.Context, data []byte) (error, string)
+	CreateBatch(ctx context.Context, fileId string) (error, *Batch)
+	GetBatch(ctx context.Context, batchId string) (error, *Batch)
+	DownloadFile(ctx context.Context, fileId string) (error, []byte)
+}
+
+/*
+Batch
+
+the state of a batch job
+*/
+type Batch struct {
+	Id            string `json:"id"`
+	Status        string `json:"status"`
+	InputFileId   string `json:"input_file_id"`
+	OutputFileId  string `json:"output_file_id"`
+	ErrorFileId   string `json:"error_file_id"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+}
+
+/*
+IsDone
+
+checks if the batch has stopped processing, this does not mean it succeeded
+*/
+func (b *Batch) IsDone() bool {
+	switch b.Status {
+	case "completed", "failed", "expired", "cancelled":
+		return true
+	}
+
+	return false
+}
+
+/*
+BatchResult
+
+the outcome of a single request in a batch, either Completion or Error is set
+*/
+type BatchResult struct {
+	CustomId   string
+	Completion *messages.ChatCompletion
+	Error      error
+}
+
+/*
+batchLine
+
+a single request in a batch input file
+*/
+type batchLine struct {
+	CustomId string      `json:"custom_id"`
+	Method   string      `json:"method"`
+	Url      string      `json:"url"`
+	Body     chatRequest `json:"body"`
+}
+
+/*
+BatchLine
+
+converts a conversation into a line of a batch input file
+*/
+func (c *ChatGpt) BatchLine(customId string, convo messages.Conversation, opts *Options) (error, []byte) {
+	line := batchLine{
+		CustomId: customId,
+		Method:   "POST",
+		Url:      "/v1/chat/completions",
+		Body:     c.buildRequest(convo, opts),
+	}
+
+	data, err := json.Marshal(line)
+	return err, data
+}
+
+/*
+request
+
+makes an authorized request to the api and returns the response body, non 2xx responses are returned as errors
+*/
+func (c *ChatGpt) request(
+	ctx context.Context,
+	method,
+	path,
+	contentType string,
+	body io.Reader) (error, []byte) {
+
+	pRequest, err := http.NewRequestWithContext(ctx, method, c.endpoint(path), body)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if contentType != "" {
+		pRequest.Header.Set("Content-Type", contentType)
+	}
+
+	pRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Key))
+
+	pResponse, err := c.httpClient().Do(pRequest)
+
+	if err != nil {
+		return err, nil
+	}
+
+	responseBytes, err := io.ReadAll(pResponse.Body)
+	closeErr := pResponse.Body.Close()
+
+	if err != nil {
+		return err, nil
+	}
+
+	if closeErr != nil {
+		return closeErr, nil
+	}
+
+	if pResponse.StatusCode < 200 || pResponse.StatusCode > 299 {
+		var resp gptRequestError
+		if err = json.Unmarshal(responseBytes, &resp); err != nil || resp.Error.Message == "" {
+			return fmt.Errorf("request to %s failed with status %d", path, pResponse.StatusCode), nil
+		}
+
+		return errors.New(resp.Error.Message), nil
+	}
+
+	return nil, responseBytes
+}
+
+/*
+UploadBatchFile
+
+uploads a jsonl batch input file and returns its file id
+*/
+func (c *ChatGpt) UploadBatchFile(ctx context.Context, data []byte) (error, string) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+
+	if err := writer.WriteField("purpose", "batch"); err != nil {
+		return err, ""
+	}
+
+	part, err := writer.CreateFormFile("file", "batch.jsonl")
+
+	if err != nil {
+		return err, ""
+	}
+
+	if _, err = part.Write(data); err != nil {
+		return err, ""
+	}
+
+	if err = writer.Close(); err != nil {
+		return err, ""
+	}
+
+	err, responseBytes := c.request(ctx, "POST", "/files", writer.FormDataContentType(), &buffer)
+
+	if err != nil {
+		return err, ""
+	}
+
+	file := struct {
+		Id string `json:"id"`
+	}{}
+
+	if err = json.Unmarshal(responseBytes, &file); err != nil {
+		return err, ""
+	}
+
+	return nil, file.Id
+}
+
+/*
+CreateBatch
+
+starts a batch job over an uploaded input file
+*/
+func (c *ChatGpt) CreateBatch(ctx context.Context, fileId string) (error, *Batch) {
+	body, err := json.Marshal(map[string]string{
+		"input_file_id":     fileId,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	})
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, responseBytes := c.request(ctx, "POST", "/batches", "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err, nil
+	}
+
+	var batch Batch
+	err = json.Unmarshal(responseBytes, &batch)
+	return err, &batch
+}
+
+/*
+GetBatch
+
+retrieves the current state of a batch job
+*/
+func (c *ChatGpt) GetBatch(ctx context.Context, batchId string) (error, *Batch) {
+	err, responseBytes := c.request(ctx, "GET", "/batches/"+batchId, "", nil)
+
+	if err != nil {
+		return err, nil
+	}
+
+	var batch Batch
+	err = json.Unmarshal(responseBytes, &batch)
+	return err, &batch
+}
+
+/*
+DownloadFile
+
+retrieves the content of a file such as the output of a batch job
+*/
+func (c *ChatGpt) DownloadFile(ctx context.Context, fileId string) (error, []byte) {
+	return c.request(ctx, "GET", "/files/"+fileId+"/content", "", nil)
+}
+
+/*
+ParseBatchOutput
+
+converts a batch output file into the result of each request
+*/
+func ParseBatchOutput(data []byte) (error, []BatchResult) {
+	type outputLine struct {
+		CustomId string `json:"custom_id"`
+		Response *struct {
+			StatusCode int                     `json:"status_code"`
+			Body       messages.ChatCompletion `json:"body"`
+		} `json:"response"`
+		Error *gptError `json:"error"`
+	}
+
+	var results []BatchResult
+
+	for index, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var out outputLine
+		if err := json.Unmarshal([]byte(line), &out); err != nil {
+			return fmt.Errorf("could not parse line %d of the batch output: %v", index, err), nil
+		}
+
+		result := BatchResult{CustomId: out.CustomId}
+
+		switch {
+		case out.Error != nil:
+			result.Error = errors.New(out.Error.Message)
+		case out.Response == nil:
+			result.Error = errors.New("batch request returned no response")
+		case out.Response.StatusCode != 200:
+			result.Error = fmt.Errorf("batch request failed with status %d", out.Response.StatusCode)
+		default:
+			result.Completion = &out.Response.Body
+		}
+
+		results = append(results, result)
+	}
+
+	return nil, results
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"context"
+	"huan/llm/messages"
+	"huan/llm/model/standin"
+	"strings"
+	"testing"
+)
+
+func TestChatGpt_Batch(t *testing.T) {
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		return `[{"content": "123"}]`
+	})
+	server.PollsUntilDone = 1
+	defer server.Close()
+
+	gpt := ChatGpt{
+		Model:   "gpt-4o",
+		Key:     "test",
+		BaseUrl: server.URL,
+	}
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect"})
+	_, convo := builder.Build()
+
+	var lines []string
+	for _, id := range []string{"a", "b"} {
+		err, line := gpt.BatchLine(id, convo, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines = append(lines, string(line))
+	}
+
+	if !strings.Contains(lines[0], `"url":"/v1/chat/completions"`) {
+		t.Errorf("batch line is missing its endpoint %s", lines[0])
+	}
+
+	ctx := context.Background()
+	err, fileId := gpt.UploadBatchFile(ctx, []byte(strings.Join(lines, "\n")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, batch := gpt.CreateBatch(ctx, fileId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err, batch = gpt.GetBatch(ctx, batch.Id); err != nil || batch.IsDone() {
+		t.Fatalf("batch should still be running, err: %v", err)
+	}
+
+	if err, batch = gpt.GetBatch(ctx, batch.Id); err != nil || batch.Status != "completed" {
+		t.Fatalf("batch should have completed, err: %v", err)
+	}
+
+	err, output := gpt.DownloadFile(ctx, batch.OutputFileId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, results := ParseBatchOutput(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].CustomId != "a" || results[1].CustomId != "b" {
+		t.Fatalf("batch results were not mapped to their requests %v", results)
+	}
+
+	if *results[0].Completion.Choices[0].Message.Content != `[{"content": "123"}]` {
+		t.Error("batch result has the wrong content")
+	}
+
+	t.Run("unknown batch", func(t *testing.T) {
+		if err, _ := gpt.GetBatch(ctx, "missing"); err == nil {
+			t.Error("expected an error for a missing batch")
+		}
+	})
+}
+
+func TestParseBatchOutput(t *testing.T) {
+	output := `{"custom_id": "a", "response": {"status_code": 500, "body": {}}}
+{"custom_id": "b", "error": {"message": "expired"}}
+`
+	err, results := ParseBatchOutput([]byte(output))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.Error == nil {
+			t.Errorf("expected result %s to have failed", result.CustomId)
+		}
+	}
+
+	if err, _ = ParseBatchOutput([]byte("not json")); err == nil {
+		t.Error("expected invalid output to error")
+	}
+}
@@ -0,0 +1,344 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huan/llm/messages"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+/*
+CassetteEntry
+
+a recorded chat request and the completion it received
+*/
+type CassetteEntry struct {
+	Conversation json.RawMessage         `json:"conversation"`
+	Options      *Options                `json:"options,omitempty"`
+	Completion   messages.ChatCompletion `json:"completion"`
+}
+
+/*
+Recorder
+
+appends every chat request and response to a jsonl cassette file
+*/
+type Recorder struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+/*
+NewRecorder
+
+opens a cassette for recording, entries are appended to any that already exist
+*/
+func NewRecorder(path string) (error, *Recorder) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, &Recorder{file: file}
+}
+
+/*
+Record
+
+writes a request and response pair to the cassette
+*/
+func (r *Recorder) Record(convo messages.Conversation, opts *Options, completion *messages.ChatCompletion) error {
+	convoBytes, err := json.Marshal(convo)
+
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(CassetteEntry{
+		Conversation: convoBytes,
+		Options:      opts,
+		Completion:   *completion,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+/*
+Close
+
+closes the cassette file
+*/
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+/*
+LoadCassette
+
+reads every entry of a cassette file
+*/
+func LoadCassette(path string) (error, []CassetteEntry) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err, nil
+	}
+
+	defer file.Close()
+
+	var entries []CassetteEntry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*KILOBYTE), 64*MEGABYTE) // entries can hold whole pages and images
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry CassetteEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("could not parse line %d of cassette %s: %v", line, path, err), nil
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return scanner.Err(), entries
+}
+
+// how many words a fuzzy match must share with the request when no minimum is set
+const DefaultMinSimilarity = 0.5
+
+/*
+Replay
+
+a llm that serves responses from a cassette instead of making requests. strict matching requires the exact same
+conversation and options, fuzzy matching serves the entry with the same options whose conversation shares the most
+words, as long as it shares at least MinSimilarity of them
+*/
+type Replay struct {
+	Entries       []CassetteEntry
+	Fuzzy         bool
+	MinSimilarity float64
+
+	lock     sync.Mutex
+	used     []bool
+	logprobs bool
+}
+
+/*
+NewReplay
+
+creates a replay llm from a cassette file
+*/
+func NewReplay(path string, fuzzy bool) (error, *Replay) {
+	err, entries := LoadCassette(path)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("cassette %s has no entries", path), nil
+	}
+
+	return nil, &Replay{
+		Entries:       entries,
+		Fuzzy:         fuzzy,
+		MinSimilarity: DefaultMinSimilarity,
+	}
+}
+
+/*
+EnableLogprobs
+
+only entries recorded with log probabilities are served from now on, the confidence of a record depends on them
+*/
+func (r *Replay) EnableLogprobs() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.logprobs = true
+}
+
+/*
+hasLogprobs
+
+checks if an entry was recorded with log probabilities
+*/
+func hasLogprobs(entry CassetteEntry) bool {
+	for _, choice := range entry.Completion.Choices {
+		if choice.Logprobs != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+wordSet
+
+the distinct words of a conversation, punctuation is ignored
+*/
+func wordSet(data []byte) map[string]struct{} {
+	words := map[string]struct{}{}
+
+	isSeparator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(string(data)), isSeparator) {
+		words[word] = struct{}{}
+	}
+
+	return words
+}
+
+/*
+jaccard
+
+the similarity of two word sets, 1 means they are identical
+*/
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	var shared int
+	for word := range a {
+		if _, ok := b[word]; ok {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+/*
+match
+
+finds the entry for a request, entries that have not been served yet are preferred so repeated requests replay in
+the order they were recorded. the options and log probabilities must match in both modes. the lock must be held by
+the caller
+*/
+func (r *Replay) match(convo []byte, opts []byte) (int, bool) {
+	if r.used == nil {
+		r.used = make([]bool, len(r.Entries))
+	}
+
+	best := -1
+	var bestScore float64
+
+	var words map[string]struct{}
+	if r.Fuzzy {
+		words = wordSet(convo)
+	}
+
+	for index, entry := range r.Entries {
+		var score float64
+
+		if entryOpts, _ := json.Marshal(entry.Options); !bytes.Equal(entryOpts, opts) {
+			continue
+		}
+
+		if hasLogprobs(entry) != r.logprobs {
+			continue
+		}
+
+		if r.Fuzzy {
+			if score = jaccard(words, wordSet(entry.Conversation)); score < r.MinSimilarity {
+				continue
+			}
+		} else {
+			var compact bytes.Buffer
+
+			if json.Compact(&compact, entry.Conversation) != nil || !bytes.Equal(compact.Bytes(), convo) {
+				continue
+			}
+
+			score = 1
+		}
+
+		// an unused entry wins a tie against one that has already been served
+		tieBreak := best >= 0 && score == bestScore && r.used[best] && !r.used[index]
+
+		if best < 0 || score > bestScore || tieBreak {
+			best = index
+			bestScore = score
+		}
+	}
+
+	return best, best >= 0
+}
+
+/*
+Chat
+
+serves the recorded completion that matches the request
+*/
+func (r *Replay) Chat(
+	convo messages.Conversation,
+	ctx context.Context,
+	opts *Options) (error, *bool, *messages.ChatCompletion) {
+
+	var isRateLimit bool
+
+	convoBytes, err := json.Marshal(convo)
+
+	if err != nil {
+		return err, &isRateLimit, nil
+	}
+
+	optBytes, err := json.Marshal(opts)
+
+	if err != nil {
+		return err, &isRateLimit, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	index, ok := r.match(convoBytes, optBytes)
+
+	if !ok && r.Fuzzy {
+		return fmt.Errorf(
+			"no cassette entry with the same options shares at least %.2f of the words of the request",
+			r.MinSimilarity), &isRateLimit, nil
+	}
+
+	if !ok {
+		return errors.New("no cassette entry matches the request"), &isRateLimit, nil
+	}
+
+	r.used[index] = true
+	completion := r.Entries[index].Completion
+	return nil, nil, &completion
+}
+
+/*
+Validate
+
+replayed conversations were validated when they were recorded
+*/
+func (r *Replay) Validate(convo *messages.ConversationBuilder) error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"huan/llm/messages"
+	"path/filepath"
+	"testing"
+)
+
+func cassetteConversation(content string) messages.Conversation {
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "system", Content: "you are a webscraper"})
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: content})
+	_, convo := builder.Build()
+	return convo
+}
+
+func cassetteCompletion(content string) *messages.ChatCompletion {
+	return &messages.ChatCompletion{
+		Choices: []messages.Choice{
+			{Message: messages.Message{Role: "assistant", Content: &content}},
+		},
+	}
+}
+
+func replyOf(t *testing.T, replay *Replay, convo messages.Conversation, opts *Options) string {
+	err, _, completion := replay.Chat(convo, context.Background(), opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *completion.Choices[0].Message.Content
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.cassette.jsonl")
+
+	err, recorder := NewRecorder(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	temperature := float32(0.5)
+	entries := []struct {
+		content string
+		opts    *Options
+		reply   string
+	}{
+		{content: "collect the books on this page", reply: "books"},
+		{content: "collect the books on this page", reply: "books again"},
+		{content: "collect the books on this page", opts: &Options{Temperature: &temperature}, reply: "warm books"},
+		{content: "collect the wolves of angband", reply: "wolves"},
+	}
+
+	for _, entry := range entries {
+		if err = recorder.Record(cassetteConversation(entry.content), entry.opts, cassetteCompletion(entry.reply)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		err, replay := NewReplay(path, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		books := cassetteConversation("collect the books on this page")
+
+		if reply := replyOf(t, replay, books, nil); reply != "books" {
+			t.Errorf("expected books got %s", reply)
+		}
+
+		if reply := replyOf(t, replay, books, nil); reply != "books again" {
+			t.Errorf("repeated requests were not replayed in order, got %s", reply)
+		}
+
+		if reply := replyOf(t, replay, books, &Options{Temperature: &temperature}); reply != "warm books" {
+			t.Errorf("options were not matched, got %s", reply)
+		}
+
+		err, _, _ = replay.Chat(cassetteConversation("collect the books on this site"), context.Background(), nil)
+
+		if err == nil {
+			t.Error("strict replay served a request that was never recorded")
+		}
+	})
+
+	t.Run("fuzzy", func(t *testing.T) {
+		err, replay := NewReplay(path, true)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reply := replyOf(t, replay, cassetteConversation("collect all the wolves of angband"), nil); reply != "wolves" {
+			t.Errorf("expected wolves got %s", reply)
+		}
+
+		if reply := replyOf(t, replay, cassetteConversation("collect the books"), &Options{Temperature: &temperature}); reply != "warm books" {
+			t.Errorf("options were not matched, got %s", reply)
+		}
+
+		cold := float32(0)
+		err, _, _ = replay.Chat(cassetteConversation("collect the books on this page"), context.Background(), &Options{Temperature: &cold})
+
+		if err == nil {
+			t.Error("fuzzy replay served an entry recorded with other options")
+		}
+
+		err, _, _ = replay.Chat(cassetteConversation("summarise every chapter of the silmarillion that tolkien wrote about beleriand"), context.Background(), nil)
+
+		if err == nil {
+			t.Error("fuzzy replay served an entry that shares too few words with the request")
+		}
+	})
+
+	t.Run("logprobs", func(t *testing.T) {
+		err, replay := NewReplay(path, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replay.EnableLogprobs()
+		err, _, _ = replay.Chat(cassetteConversation("collect the books on this page"), context.Background(), nil)
+
+		if err == nil {
+			t.Error("replay served an entry recorded without log probabilities")
+		}
+	})
+}
@@ -3,7 +3,9 @@ package model
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +21,8 @@ const (
 	MEGABYTE = KILOBYTE * KILOBYTE
 )
 
+const defaultBaseUrl = "https://api.openai.com/v1"
+
 /*
 Engine
 
@@ -107,6 +111,41 @@ type ChatGpt struct {
 	Tools            *[]messages.Tool
 	ToolChoice       interface{}
 	Key              string
+	BaseUrl          string       // the root of the api, defaults to the openai api
+	Client           *http.Client // the client every request is made with, defaults to a shared client
+	EmbeddingModel   string       // the model texts are embedded with, embeddings are unavailable when empty
+}
+
+/*
+SetHttpClient
+
+makes every future request with the provided client
+*/
+func (c *ChatGpt) SetHttpClient(client *http.Client) {
+	c.Client = client
+}
+
+func (c *ChatGpt) httpClient() *http.Client {
+	if c.Client == nil {
+		return defaultClient
+	}
+
+	return c.Client
+}
+
+/*
+endpoint
+
+joins a path onto the base url of the api
+*/
+func (c *ChatGpt) endpoint(path string) string {
+	base := c.BaseUrl
+
+	if base == "" {
+		base = defaultBaseUrl
+	}
+
+	return strings.TrimSuffix(base, "/") + path
 }
 
 /*
@@ -362,6 +401,10 @@ func (c *ChatGpt) Validate(convo *messages.ConversationBuilder) error {
 		return errors.New("max tokens must be greater than 1")
 	}
 
+	if c.N != nil && *c.N < 1 {
+		return errors.New("n must be greater than 0")
+	}
+
 	if err := adjustConversation(convo); err != nil {
 		return err
 	}
@@ -378,6 +421,10 @@ func (c *ChatGpt) Validate(convo *messages.ConversationBuilder) error {
 		return fmt.Errorf("top log probs must be between 0 and 20 got %d", *c.TopLogprobs)
 	}
 
+	if c.TopLogprobs != nil && (c.LogProbs == nil || !*c.LogProbs) {
+		return errors.New("top log probs can only be requested when log probs are enabled")
+	}
+
 	if c.PresencePenalty != nil && !floatHelper(-2.0, 2.0, *c.PresencePenalty, true, true) {
 		return fmt.Errorf("presence penalty must be between -2.0 and 2.0 got %f", *c.PresencePenalty)
 	}
@@ -426,53 +473,92 @@ func (c *ChatGpt) Validate(convo *messages.ConversationBuilder) error {
 		}
 	}
 
+	tokens := EstimateTokens(convo)
+
+	if c.MaxTokens != nil {
+		tokens += *c.MaxTokens
+	}
+
+	if tokens > int(engine.ContextWindow) {
+		return fmt.Errorf(
+			"the conversation and its response need an estimated %d tokens but %s has a context window of %d",
+			tokens,
+			engine.Name,
+			engine.ContextWindow)
+	}
+
 	return nil
 }
 
-// TODO
-// Add method to estimate context window For multimodal and regular requests
+/*
+ContextWindow
+
+the max tokens the engine can process in a single request, including the response
+*/
+func (c *ChatGpt) ContextWindow() int {
+	return int(GetEngineMap()[c.Model].ContextWindow)
+}
 
 /*
-Chat
+EnableLogprobs
 
-makes a chat completion request to chatgpt, the settings and messages supplied will be used as request parameters
+requests the log probability of every generated token on all future chat completions
+*/
+func (c *ChatGpt) EnableLogprobs() {
+	state := true
+	c.LogProbs = &state
+}
 
-returns:
-- an error representing if the request failed at any point
-- a boolean pointer, it is nil if the request succeeded. it points to true if the request failed due to rate limiting
-- a chat completion pointer: contains the request response
+/*
+chatRequest
+
+the body of a chat completion request
 */
+type chatRequest struct {
+	Model            string                `json:"model"`
+	FrequencyPenalty *float32              `json:"frequency_penalty,omitempty"`
+	Messages         messages.Conversation `json:"messages"`
+	LogitBias        *map[string]int       `json:"logit_bias,omitempty"`
+	LogProbs         *bool                 `json:"logprobs,omitempty"`
+	TopLogprobs      *uint8                `json:"top_logprobs,omitempty"`
+	MaxTokens        *int                  `json:"max_tokens,omitempty"`
+	N                *int                  `json:"n,omitempty"`
+	PresencePenalty  *float32              `json:"presence_penalty,omitempty"`
+	ResponseFormat   *map[string]string    `json:"response_format,omitempty"`
+	Seed             *int                  `json:"seed,omitempty"`
+	Stop             *interface{}          `json:"stop,omitempty"`
+	Stream           *bool                 `json:"stream,omitempty"`
+	Temperature      *float32              `json:"temperature,omitempty"`
+	TopP             *float32              `json:"top_p,omitempty"`
+	Tools            *[]messages.Tool      `json:"tools,omitempty"`
+	ToolChoice       interface{}           `json:"tool_choice"`
+}
 
-func (c *ChatGpt) Chat(
-	convo messages.Conversation,
-	ctx context.Context) (error, *bool, *messages.ChatCompletion) {
+/*
+buildRequest
 
-	var isRateLimit bool
+combines the gpt settings, the per request options and the conversation into a request body
+*/
+func (c *ChatGpt) buildRequest(convo messages.Conversation, opts *Options) chatRequest {
+	n := 1
 
-	type chatGpt struct {
-		Model            string                `json:"model"`
-		FrequencyPenalty *float32              `json:"frequency_penalty,omitempty"`
-		Messages         messages.Conversation `json:"messages"`
-		LogitBias        *map[string]int       `json:"logit_bias,omitempty"`
-		LogProbs         *bool                 `json:"log_probs,omitempty"`
-		TopLogprobs      *uint8                `json:"top_logprobs,omitempty"`
-		MaxTokens        *int                  `json:"max_tokens,omitempty"`
-		N                *int                  `json:"n,omitempty"`
-		PresencePenalty  *float32              `json:"presence_penalty,omitempty"`
-		ResponseFormat   *map[string]string    `json:"response_format,omitempty"`
-		Seed             *int                  `json:"seed,omitempty"`
-		Stop             *interface{}          `json:"stop,omitempty"`
-		Stream           *bool                 `json:"stream,omitempty"`
-		Temperature      *float32              `json:"temperature,omitempty"`
-		TopP             *float32              `json:"top_p,omitempty"`
-		Tools            *[]messages.Tool      `json:"tools,omitempty"`
-		ToolChoice       interface{}           `json:"tool_choice"`
-		key              string
+	if c.N != nil {
+		n = *c.N
 	}
 
-	n := 1
+	temperature := c.Temperature
+
+	if opts != nil {
+		if opts.N != nil {
+			n = *opts.N
+		}
+
+		if opts.Temperature != nil {
+			temperature = opts.Temperature
+		}
+	}
 
-	chatSettings := chatGpt{
+	return chatRequest{
 		Model:            c.Model,
 		FrequencyPenalty: c.FrequencyPenalty,
 		Messages:         convo,
@@ -486,20 +572,59 @@ func (c *ChatGpt) Chat(
 		Seed:             c.Seed,
 		Stop:             c.Stop,
 		Stream:           c.Stream,
-		Temperature:      c.Temperature,
+		Temperature:      temperature,
 		TopP:             c.TopP,
 		Tools:            c.Tools,
 		ToolChoice:       c.ToolChoice,
 	}
+}
+
+/*
+CacheKey
 
-	url := "https://api.openai.com/v1/chat/completions"
+a hash of everything that determines a response, the model, sampling settings and conversation. the api key is
+excluded so responses can be shared between keys
+*/
+func (c *ChatGpt) CacheKey(convo messages.Conversation, opts *Options) (error, string) {
+	data, err := json.Marshal(c.buildRequest(convo, opts))
+
+	if err != nil {
+		return err, ""
+	}
+
+	sum := sha256.Sum256(data)
+	return nil, hex.EncodeToString(sum[:])
+}
+
+/*
+Chat
+
+makes a chat completion request to chatgpt, the settings and messages supplied will be used as request parameters
+
+returns:
+- an error representing if the request failed at any point
+- a boolean pointer, it is nil if the request succeeded. it points to true if the request failed due to rate limiting
+- a chat completion pointer: contains the request response
+
+opts can be nil, otherwise its values override the gpt settings for this request only
+*/
+
+func (c *ChatGpt) Chat(
+	convo messages.Conversation,
+	ctx context.Context,
+	opts *Options) (error, *bool, *messages.ChatCompletion) {
+
+	var isRateLimit bool
+
+	chatSettings := c.buildRequest(convo, opts)
+
+	url := c.endpoint("/chat/completions")
 	jsonBytes, err := json.Marshal(chatSettings)
 
 	if err != nil {
 		return err, &isRateLimit, nil
 	}
 
-	var client http.Client
 	reader := bytes.NewReader(jsonBytes)
 	pRequest, err := http.NewRequestWithContext(ctx, "POST", url, reader)
 
@@ -510,25 +635,23 @@ func (c *ChatGpt) Chat(
 	pRequest.Header.Set("Content-Type", "application/json")
 	pRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Key))
 
-	pResponse, err := client.Do(pRequest)
+	pResponse, err := c.httpClient().Do(pRequest)
 
 	if err != nil {
 		return err, &isRateLimit, nil
 	}
 
-	defer func() {
-		closeErr := pResponse.Body.Close()
-		if closeErr != nil {
-			panic(closeErr)
-		}
-	}()
-
 	responseBytes, err := io.ReadAll(pResponse.Body)
+	closeErr := pResponse.Body.Close()
 
 	if err != nil {
 		return err, &isRateLimit, nil
 	}
 
+	if closeErr != nil {
+		return closeErr, &isRateLimit, nil
+	}
+
 	if pResponse.StatusCode == 200 {
 		var gptResp messages.ChatCompletion
 		if err = json.Unmarshal(responseBytes, &gptResp); err != nil {
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/*
+EmbeddingEngine
+
+a struct representing the capabilities of an embedding model
+*/
+type EmbeddingEngine struct {
+	Name             string
+	Dimensions       uint32 // the length of every vector the model returns
+	MaxInputs        uint32 // how many texts can be embedded in a single request
+	MaxTokens        uint32 // the max tokens of a single text
+	MaxRequestTokens uint32 // the max tokens of every text in a single request together
+}
+
+/*
+GetEmbeddingEngineMap
+
+returns a map of all available openai embedding engines
+*/
+func GetEmbeddingEngineMap() map[string]EmbeddingEngine {
+	small := EmbeddingEngine{
+		Name:             "text-embedding-3-small",
+		Dimensions:       1536,
+		MaxInputs:        2048,
+		MaxTokens:        8191,
+		MaxRequestTokens: 300000,
+	}
+
+	large := EmbeddingEngine{
+		Name:             "text-embedding-3-large",
+		Dimensions:       3072,
+		MaxInputs:        2048,
+		MaxTokens:        8191,
+		MaxRequestTokens: 300000,
+	}
+
+	ada := EmbeddingEngine{
+		Name:             "text-embedding-ada-002",
+		Dimensions:       1536,
+		MaxInputs:        2048,
+		MaxTokens:        8191,
+		MaxRequestTokens: 300000,
+	}
+
+	return map[string]EmbeddingEngine{
+		small.Name: small,
+		large.Name: large,
+		ada.Name:   ada,
+	}
+}
+
+/*
+Embedder
+
+a llm that can convert texts into vectors, texts with similar meanings have similar vectors
+*/
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) (error, [][]float32)
+	EmbeddingEngine() (error, EmbeddingEngine)
+}
+
+/*
+EmbeddingEngine
+
+the embedding engine that was configured, errors if there is none or it is unknown
+*/
+func (c *ChatGpt) EmbeddingEngine() (error, EmbeddingEngine) {
+	if c.EmbeddingModel == "" {
+		return errors.New("no embedding model was configured"), EmbeddingEngine{}
+	}
+
+	engine, ok := GetEmbeddingEngineMap()[c.EmbeddingModel]
+
+	if !ok {
+		return fmt.Errorf("%s is not a valid embedding model", c.EmbeddingModel), EmbeddingEngine{}
+	}
+
+	return nil, engine
+}
+
+/*
+splitEmbeddingBatches
+
+splits texts into as few requests as the engine allows, a request is limited both by how many texts it holds and by
+their estimated tokens. errors if a single text is over the token limit of the engine
+*/
+func splitEmbeddingBatches(engine EmbeddingEngine, texts []string) (error, [][]string) {
+	var batches [][]string
+	var start, tokens int
+
+	for index, text := range texts {
+		textTokens := EstimateTextTokens(text)
+
+		if textTokens > int(engine.MaxTokens) {
+			return fmt.Errorf(
+				"text %d needs an estimated %d tokens but %s accepts at most %d",
+				index,
+				textTokens,
+				engine.Name,
+				engine.MaxTokens), nil
+		}
+
+		if index > start && (index-start >= int(engine.MaxInputs) || tokens+textTokens > int(engine.MaxRequestTokens)) {
+			batches = append(batches, texts[start:index])
+			start, tokens = index, 0
+		}
+
+		tokens += textTokens
+	}
+
+	if start < len(texts) {
+		batches = append(batches, texts[start:])
+	}
+
+	return nil, batches
+}
+
+/*
+Embed
+
+converts every text into a vector, the texts are split into as few requests as the engine allows. the vectors
+are returned in the same order as the texts
+*/
+func (c *ChatGpt) Embed(ctx context.Context, texts []string) (error, [][]float32) {
+	err, engine := c.EmbeddingEngine()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, batches := splitEmbeddingBatches(engine, texts)
+
+	if err != nil {
+		return err, nil
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+
+	for _, group := range batches {
+		err, batch := c.embedBatch(ctx, engine, group)
+
+		if err != nil {
+			return err, nil
+		}
+
+		vectors = append(vectors, batch...)
+	}
+
+	return nil, vectors
+}
+
+/*
+embedBatch
+
+embeds texts that fit in a single request
+*/
+func (c *ChatGpt) embedBatch(ctx context.Context, engine EmbeddingEngine, texts []string) (error, [][]float32) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": engine.Name,
+		"input": texts,
+	})
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, responseBytes := c.request(ctx, "POST", "/embeddings", "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err, nil
+	}
+
+	response := struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}{}
+
+	if err = json.Unmarshal(responseBytes, &response); err != nil {
+		return err, nil
+	}
+
+	if len(response.Data) != len(texts) {
+		return fmt.Errorf("requested %d embeddings but received %d", len(texts), len(response.Data)), nil
+	}
+
+	vectors := make([][]float32, len(texts))
+
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return fmt.Errorf("received embedding for unknown input %d", data.Index), nil
+		}
+
+		if len(data.Embedding) != int(engine.Dimensions) {
+			return fmt.Errorf(
+				"%s should return %d dimensions but returned %d",
+				engine.Name,
+				engine.Dimensions,
+				len(data.Embedding)), nil
+		}
+
+		vectors[data.Index] = data.Embedding
+	}
+
+	return nil, vectors
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"huan/llm/model/standin"
+	"strings"
+	"testing"
+)
+
+func TestChatGpt_Embed(t *testing.T) {
+	server := standin.NewServer(nil)
+	defer server.Close()
+
+	gpt := ChatGpt{Key: "test", BaseUrl: server.URL, EmbeddingModel: "text-embedding-3-small"}
+
+	texts := make([]string, 2050)
+	for index := range texts {
+		texts[index] = fmt.Sprintf("text %d", index)
+	}
+
+	err, vectors := gpt.Embed(context.Background(), texts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vectors) != len(texts) {
+		t.Fatalf("expected %d vectors got %d", len(texts), len(vectors))
+	}
+
+	if server.EmbeddingRequests() != 2 {
+		t.Errorf("texts should be split into 2 requests, made %d", server.EmbeddingRequests())
+	}
+
+	_, single := gpt.Embed(context.Background(), texts[2049:])
+
+	for index := range single[0] {
+		if single[0][index] != vectors[2049][index] {
+			t.Fatal("vectors were not returned in the order of the texts")
+		}
+	}
+}
+
+func Test_splitEmbeddingBatches(t *testing.T) {
+	engine := GetEmbeddingEngineMap()["text-embedding-3-small"]
+
+	// every text is an estimated 8000 tokens so only 37 fit in a request
+	long := strings.Repeat("huan", 8000)
+	texts := make([]string, 40)
+	for index := range texts {
+		texts[index] = long
+	}
+
+	err, batches := splitEmbeddingBatches(engine, texts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != 37 || len(batches[1]) != 3 {
+		t.Errorf("expected the texts to be split by their tokens got %d requests", len(batches))
+	}
+
+	err, _ = splitEmbeddingBatches(engine, []string{"huan", strings.Repeat("huan", 8192)})
+
+	if err == nil || !strings.Contains(err.Error(), "text 1") {
+		t.Errorf("expected an error for the text over the token limit got %v", err)
+	}
+}
+
+func TestChatGpt_Embed_invalid(t *testing.T) {
+	server := standin.NewServer(nil)
+	server.EmbeddingDimensions = 8
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		model string
+		want  string
+	}{
+		{"no model", "", "no embedding model"},
+		{"unknown model", "text-embedding-4", "not a valid embedding model"},
+		{"wrong dimensions", "text-embedding-3-large", "should return 3072 dimensions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpt := ChatGpt{Key: "test", BaseUrl: server.URL, EmbeddingModel: tt.model}
+			err, _ := gpt.Embed(context.Background(), []string{"text"})
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package model
+
+/*
+Options
+
+overrides a llm's sampling settings for a single request, nil values fall back to the configured settings
+*/
+type Options struct {
+	N           *int     `json:"n,omitempty"`           // how many choices to generate
+	Temperature *float32 `json:"temperature,omitempty"` // the sampling temperature
+}
@@ -0,0 +1,353 @@
+/*
+Package standin
+
+a local stand-in for the openai api, it lets the llm layer be tested without network access or api keys
+*/
+package standin
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Responder
+
+generates the assistant content for a chat completion request body
+*/
+type Responder func(body map[string]interface{}) string
+
+/*
+Server
+
+an in memory openai api, only the endpoints huan relies on are implemented
+*/
+type Server struct {
+	*httptest.Server
+	Respond             Responder
+	PollsUntilDone      int // how many times a batch must be polled before it completes
+	EmbeddingDimensions int // the length of every embedding, defaults to 1536
+
+	lock       sync.Mutex
+	files      map[string][]byte
+	batches    map[string]*batch
+	requests   atomic.Int64
+	embeddings atomic.Int64
+}
+
+type batch struct {
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	InputFileId  string `json:"input_file_id"`
+	OutputFileId string `json:"output_file_id,omitempty"`
+	polls        int
+}
+
+/*
+NewServer
+
+starts a stand-in server, call Close once it is no longer needed
+*/
+func NewServer(respond Responder) *Server {
+	s := &Server{
+		Respond: respond,
+		files:   map[string][]byte{},
+		batches: map[string]*batch{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", s.chat)
+	mux.HandleFunc("POST /embeddings", s.embed)
+	mux.HandleFunc("POST /files", s.upload)
+	mux.HandleFunc("GET /files/{id}/content", s.download)
+	mux.HandleFunc("POST /batches", s.createBatch)
+	mux.HandleFunc("GET /batches/{id}", s.getBatch)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+/*
+Requests
+
+how many chat completions the server has generated
+*/
+func (s *Server) Requests() int {
+	return int(s.requests.Load())
+}
+
+/*
+EmbeddingRequests
+
+how many embedding requests the server has received
+*/
+func (s *Server) EmbeddingRequests() int {
+	return int(s.embeddings.Load())
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]interface{}{
+		"error": map[string]string{"message": message},
+	})
+}
+
+/*
+complete
+
+builds a chat completion for a request body, when max_tokens is set content longer than it, at 4 characters per
+token, is cut off and finishes with length
+*/
+func (s *Server) complete(body map[string]interface{}) map[string]interface{} {
+	id := s.requests.Add(1)
+
+	n := 1
+	if val, ok := body["n"].(float64); ok {
+		n = int(val)
+	}
+
+	limit := -1
+	if val, ok := body["max_tokens"].(float64); ok {
+		limit = int(val) * 4
+	}
+
+	choices := make([]map[string]interface{}, n)
+	for index := range choices {
+		content, finish := s.Respond(body), "stop"
+
+		if limit >= 0 && len(content) > limit {
+			content, finish = content[:limit], "length"
+		}
+
+		choices[index] = map[string]interface{}{
+			"index":         index,
+			"finish_reason": finish,
+			"message": map[string]interface{}{
+				"role":    "assistant",
+				"content": content,
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-%d", id),
+		"object":  "chat.completion",
+		"model":   body["model"],
+		"choices": choices,
+	}
+}
+
+func (s *Server) chat(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJson(w, http.StatusOK, s.complete(body))
+}
+
+/*
+embedding
+
+a deterministic vector built by hashing the words of a text, texts sharing words have similar vectors
+*/
+func embedding(text string, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(word))
+		vector[hash.Sum32()%uint32(dimensions)]++
+	}
+
+	var norm float64
+	for _, val := range vector {
+		norm += float64(val * val)
+	}
+
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for index := range vector {
+			vector[index] = float32(float64(vector[index]) / norm)
+		}
+	}
+
+	return vector
+}
+
+func (s *Server) embed(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.embeddings.Add(1)
+
+	dimensions := s.EmbeddingDimensions
+	if dimensions == 0 {
+		dimensions = 1536
+	}
+
+	data := make([]map[string]interface{}, len(body.Input))
+	for index, text := range body.Input {
+		data[index] = map[string]interface{}{
+			"object":    "embedding",
+			"index":     index,
+			"embedding": embedding(text, dimensions),
+		}
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"model":  body.Model,
+		"data":   data,
+	})
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := io.ReadAll(file)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	id := fmt.Sprintf("file-%d", len(s.files))
+	s.files[id] = data
+	s.lock.Unlock()
+
+	writeJson(w, http.StatusOK, map[string]string{"id": id, "purpose": r.FormValue("purpose")})
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	data, ok := s.files[r.PathValue("id")]
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such file")
+		return
+	}
+
+	_, _ = w.Write(data)
+}
+
+func (s *Server) createBatch(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	_, ok := s.files[body["input_file_id"]]
+	b := &batch{
+		Id:          fmt.Sprintf("batch-%d", len(s.batches)),
+		Status:      "in_progress",
+		InputFileId: body["input_file_id"],
+	}
+
+	if ok {
+		s.batches[b.Id] = b
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "no such input file")
+		return
+	}
+
+	writeJson(w, http.StatusOK, b)
+}
+
+/*
+runBatch
+
+completes every request in the input file of a batch and stores the results as its output file, the lock must be
+held by the caller
+*/
+func (s *Server) runBatch(b *batch) {
+	var output strings.Builder
+
+	for _, line := range strings.Split(string(s.files[b.InputFileId]), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		request := struct {
+			CustomId string                 `json:"custom_id"`
+			Body     map[string]interface{} `json:"body"`
+		}{}
+
+		if err := json.Unmarshal([]byte(line), &request); err != nil {
+			b.Status = "failed"
+			return
+		}
+
+		completion := s.complete(request.Body)
+
+		data, _ := json.Marshal(map[string]interface{}{
+			"custom_id": request.CustomId,
+			"response": map[string]interface{}{
+				"status_code": 200,
+				"body":        completion,
+			},
+		})
+
+		output.Write(data)
+		output.WriteString("\n")
+	}
+
+	b.OutputFileId = fmt.Sprintf("file-%d", len(s.files))
+	s.files[b.OutputFileId] = []byte(output.String())
+	b.Status = "completed"
+}
+
+func (s *Server) getBatch(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, ok := s.batches[r.PathValue("id")]
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such batch")
+		return
+	}
+
+	b.polls++
+
+	if b.Status == "in_progress" && b.polls > s.PollsUntilDone {
+		s.runBatch(b)
+	}
+
+	writeJson(w, http.StatusOK, b)
+}
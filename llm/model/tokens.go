@@ -0,0 +1,140 @@
+package model
+
+import (
+	"encoding/base64"
+	"huan/llm/messages"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"strings"
+)
+
+const (
+	charsPerToken     = 4   // a rough average for english text and html
+	messageOverhead   = 4   // the tokens used to frame every message
+	imageBaseTokens   = 85  // the cost of any image, the full cost of a low detail image
+	imageTileTokens   = 170 // the cost of every 512px tile of a high detail image
+	unknownImageCost  = 765 // the cost of a 1024px square image, used when the size cannot be read
+	imageMaxDimension = 2048
+	imageMinDimension = 768
+)
+
+/*
+EstimateTextTokens
+
+a rough count of the tokens in a text, it errs on the side of overestimating
+*/
+func EstimateTextTokens(text string) int {
+	return int(math.Ceil(float64(len(text)) / charsPerToken))
+}
+
+/*
+imageSize
+
+reads the dimensions of a base64 encoded image, ok is false for urls and images that cannot be decoded
+*/
+func imageSize(url string) (width, height int, ok bool) {
+	if strings.HasPrefix(url, "data:image/") {
+		_, data, found := strings.Cut(url, ",")
+
+		if !found {
+			return 0, 0, false
+		}
+
+		url = data
+	} else if strings.HasPrefix(url, "http") {
+		return 0, 0, false
+	}
+
+	config, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(url)))
+
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return config.Width, config.Height, true
+}
+
+/*
+EstimateImageTokens
+
+the tokens an image costs, high detail images are scaled to fit in 2048px then their short side to 768px before
+being split into 512px tiles
+*/
+func EstimateImageTokens(url string, detail *string) int {
+	if detail != nil && *detail == "low" {
+		return imageBaseTokens
+	}
+
+	width, height, ok := imageSize(url)
+
+	if !ok {
+		return unknownImageCost
+	}
+
+	return EstimateSizedImageTokens(width, height, detail)
+}
+
+/*
+EstimateSizedImageTokens
+
+the tokens an image of a known size costs, it lets the cost of an image be planned before it exists
+*/
+func EstimateSizedImageTokens(width, height int, detail *string) int {
+	if detail != nil && *detail == "low" {
+		return imageBaseTokens
+	}
+
+	if width <= 0 || height <= 0 {
+		return unknownImageCost
+	}
+
+	w, h := float64(width), float64(height)
+
+	if scale := imageMaxDimension / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+
+	if scale := imageMinDimension / math.Min(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+
+	tiles := math.Ceil(w/512) * math.Ceil(h/512)
+	return imageBaseTokens + imageTileTokens*int(tiles)
+}
+
+/*
+EstimateTokens
+
+a rough count of the prompt tokens of a conversation
+*/
+func EstimateTokens(convo *messages.ConversationBuilder) int {
+	var tokens int
+
+	for index := range convo.Size() {
+		tokens += messageOverhead
+
+		switch convo.GetMessageType(index) {
+		case "standard":
+			tokens += EstimateTextTokens(convo.ConvertToStandard(index).Content)
+		case "assistant":
+			if content := convo.ConvertToAssistant(index).Content; content != nil {
+				tokens += EstimateTextTokens(*content)
+			}
+		case "multimodal":
+			for _, content := range convo.ConvertToMultiModal(index).Content {
+				if content.Text != nil {
+					tokens += EstimateTextTokens(*content.Text)
+				}
+
+				if content.ImageUrl != nil {
+					tokens += EstimateImageTokens(content.ImageUrl.Url, content.ImageUrl.Detail)
+				}
+			}
+		}
+	}
+
+	return tokens
+}
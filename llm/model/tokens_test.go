@@ -0,0 +1,65 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"huan/llm/messages"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encodedPng(t *testing.T, width, height int) string {
+	var buffer bytes.Buffer
+
+	if err := png.Encode(&buffer, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+func TestEstimateImageTokens(t *testing.T) {
+	low := "low"
+
+	tests := []struct {
+		name   string
+		url    string
+		detail *string
+		want   int
+	}{
+		{"low detail", encodedPng(t, 4000, 4000), &low, 85},
+		{"single tile", encodedPng(t, 512, 512), nil, 255},
+		{"square", encodedPng(t, 1024, 1024), nil, 765},
+		{"tall page", encodedPng(t, 2048, 4096), nil, 1105},
+		{"data url", "data:image/png;base64," + encodedPng(t, 512, 512), nil, 255},
+		{"remote url", "https://huan.dev/page.png", nil, 765},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateImageTokens(tt.url, tt.detail); got != tt.want {
+				t.Errorf("expected %d tokens got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestChatGpt_Validate_contextWindow(t *testing.T) {
+	maxTokens := 500
+	gpt := ChatGpt{Model: "gpt-3.5-turbo", Key: "test", MaxTokens: &maxTokens}
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: strings.Repeat("a", 4000)})
+
+	if err := gpt.Validate(builder); err != nil {
+		t.Fatal(err)
+	}
+
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: strings.Repeat("a", 4*16385)})
+
+	if err := gpt.Validate(builder); err == nil || !strings.Contains(err.Error(), "context window") {
+		t.Errorf("expected the context window to be exceeded got %v", err)
+	}
+}
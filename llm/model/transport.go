@@ -0,0 +1,158 @@
+package model
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+/*
+defaultClient
+
+used by providers that were not given a client, sharing it lets requests reuse connections
+*/
+var defaultClient = &http.Client{}
+
+/*
+Transport
+
+the http settings shared by every request a provider makes
+*/
+type Transport struct {
+	ProxyUrl        string            // routes requests through a proxy, credentials can be set in the url
+	CaBundle        string            // a pem file of certificate authorities to trust on top of the system pool
+	ClientCert      string            // a pem certificate presented to servers requiring mutual tls
+	ClientKey       string            // the pem key of the client certificate
+	Timeout         time.Duration     // the max duration of a request, 0 means no limit
+	IdleConnTimeout time.Duration     // how long an unused connection is kept open, 0 means forever
+	MaxIdleConns    int               // how many unused connections are kept open per host
+	Headers         map[string]string // sent with every request
+}
+
+/*
+headerTransport
+
+adds headers to every request before sending it
+*/
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (h *headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+
+	for k, v := range h.headers {
+		r.Header.Set(k, v)
+	}
+
+	return h.base.RoundTrip(r)
+}
+
+/*
+tlsConfig
+
+builds the tls settings for custom certificate authorities and client certificates, nil is returned when neither
+was configured
+*/
+func (t *Transport) tlsConfig() (error, *tls.Config) {
+	if t.CaBundle == "" && t.ClientCert == "" && t.ClientKey == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if t.CaBundle != "" {
+		pem, err := os.ReadFile(t.CaBundle)
+
+		if err != nil {
+			return err, nil
+		}
+
+		pool, err := x509.SystemCertPool()
+
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates could be parsed from %s", t.CaBundle), nil
+		}
+
+		config.RootCAs = pool
+	}
+
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return errors.New("a client certificate and key must be provided together"), nil
+	}
+
+	if t.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+
+		if err != nil {
+			return err, nil
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return nil, config
+}
+
+/*
+NewHttpClient
+
+creates a client from the transport settings, it should be shared by every request so connections are reused
+*/
+func NewHttpClient(t *Transport) (error, *http.Client) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	if t.ProxyUrl != "" {
+		proxy, err := url.Parse(t.ProxyUrl)
+
+		if err != nil {
+			return err, nil
+		}
+
+		if proxy.Scheme == "" || proxy.Host == "" {
+			return fmt.Errorf("proxy url %s must include a scheme and host", t.ProxyUrl), nil
+		}
+
+		base.Proxy = http.ProxyURL(proxy)
+	}
+
+	err, config := t.tlsConfig()
+
+	if err != nil {
+		return err, nil
+	}
+
+	if config != nil {
+		base.TLSClientConfig = config
+	}
+
+	if t.MaxIdleConns > 0 {
+		base.MaxIdleConns = t.MaxIdleConns
+		base.MaxIdleConnsPerHost = t.MaxIdleConns
+	}
+
+	if t.IdleConnTimeout > 0 {
+		base.IdleConnTimeout = t.IdleConnTimeout
+	}
+
+	var roundTripper http.RoundTripper = base
+
+	if len(t.Headers) > 0 {
+		roundTripper = &headerTransport{base: base, headers: t.Headers}
+	}
+
+	return nil, &http.Client{
+		Transport: roundTripper,
+		Timeout:   t.Timeout,
+	}
+}
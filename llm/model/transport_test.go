@@ -0,0 +1,82 @@
+package model
+
+import (
+	"context"
+	"huan/llm/messages"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewHttpClient(t *testing.T) {
+	var gotHeader, gotHost string
+
+	// a proxy receives the absolute url of every plain http request sent through it
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Team")
+		gotHost = r.URL.Host
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "[]"}}]}`))
+	}))
+	defer proxy.Close()
+
+	err, client := NewHttpClient(&Transport{
+		ProxyUrl: proxy.URL,
+		Headers:  map[string]string{"X-Team": "scrapers"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpt := ChatGpt{
+		Model:   "gpt-4o",
+		Key:     "test",
+		BaseUrl: "http://llm.internal/v1",
+		Client:  client,
+	}
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect"})
+	_, convo := builder.Build()
+
+	err, _, completion := gpt.Chat(convo, context.Background(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(completion.Choices) != 1 {
+		t.Errorf("expected 1 choice got %d", len(completion.Choices))
+	}
+
+	if gotHost != "llm.internal" {
+		t.Errorf("request was not sent through the proxy, host: %s", gotHost)
+	}
+
+	if gotHeader != "scrapers" {
+		t.Errorf("extra header was not sent, got %q", gotHeader)
+	}
+}
+
+func TestNewHttpClient_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport Transport
+		want      string
+	}{
+		{"proxy without scheme", Transport{ProxyUrl: "localhost"}, "scheme"},
+		{"cert without key", Transport{ClientCert: "cert.pem"}, "together"},
+		{"missing ca bundle", Transport{CaBundle: "does-not-exist.pem"}, "does-not-exist.pem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, _ := NewHttpClient(&tt.transport)
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q got %v", tt.want, err)
+			}
+		})
+	}
+}
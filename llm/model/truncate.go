@@ -0,0 +1,274 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"huan/llm/messages"
+	"strings"
+)
+
+/*
+Truncator
+
+shrinks a conversation until its estimated tokens fit in a budget, the system messages and the last user turn are
+always kept
+*/
+type Truncator interface {
+	Truncate(ctx context.Context, convo *messages.ConversationBuilder, budget int) error
+}
+
+/*
+lastUserTurn
+
+the index of the last message sent by the user, -1 when there is none
+*/
+func lastUserTurn(convo *messages.ConversationBuilder) int {
+	for index := convo.Size() - 1; index >= 0; index-- {
+		if convo.GetRole(index) == "user" {
+			return index
+		}
+	}
+
+	return -1
+}
+
+/*
+removableTurns
+
+the indexes of every message that may be removed, oldest first
+*/
+func removableTurns(convo *messages.ConversationBuilder) []int {
+	var indexes []int
+
+	for index := range lastUserTurn(convo) {
+		if convo.GetRole(index) != "system" {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+/*
+removeTurn
+
+removes a message, the results of the tool calls an assistant message made are removed with it
+*/
+func removeTurn(convo *messages.ConversationBuilder, index int) {
+	isAssistant := convo.GetMessageType(index) == "assistant"
+	convo.Pop(index)
+
+	for isAssistant && index < convo.Size() && convo.GetMessageType(index) == "tool" {
+		convo.Pop(index)
+	}
+}
+
+func budgetError(convo *messages.ConversationBuilder, budget int) error {
+	return fmt.Errorf(
+		"the conversation needs an estimated %d tokens after truncation but only %d are available",
+		EstimateTokens(convo),
+		budget)
+}
+
+/*
+DropOldest
+
+removes the oldest turns until the conversation fits
+*/
+type DropOldest struct{}
+
+func (d DropOldest) Truncate(ctx context.Context, convo *messages.ConversationBuilder, budget int) error {
+	for EstimateTokens(convo) > budget {
+		removable := removableTurns(convo)
+
+		if len(removable) == 0 {
+			return budgetError(convo, budget)
+		}
+
+		removeTurn(convo, removable[0])
+	}
+
+	return nil
+}
+
+/*
+DropImages
+
+removes images from the oldest messages first, then falls back to removing the oldest turns
+*/
+type DropImages struct{}
+
+func (d DropImages) Truncate(ctx context.Context, convo *messages.ConversationBuilder, budget int) error {
+	for index := 0; index < lastUserTurn(convo) && EstimateTokens(convo) > budget; index++ {
+		if convo.GetMessageType(index) != "multimodal" {
+			continue
+		}
+
+		// the message is replaced rather than altered, conversations that were already built may share it
+		original := convo.ConvertToMultiModal(index)
+		stripped := messages.MultiModalMessage{Role: original.Role, Name: original.Name}
+
+		for _, content := range original.Content {
+			if content.Type != "image_url" {
+				stripped.Content = append(stripped.Content, content)
+			}
+		}
+
+		convo.Pop(index)
+
+		if len(stripped.Content) == 0 {
+			index--
+			continue
+		}
+
+		convo.InsertMultimodalMessage(index, &stripped)
+	}
+
+	return DropOldest{}.Truncate(ctx, convo, budget)
+}
+
+/*
+Summariser
+
+completes a conversation and returns the text of the response
+*/
+type Summariser func(ctx context.Context, convo messages.Conversation) (error, string)
+
+/*
+Summarise
+
+replaces every removable turn with a summary written by the llm, the oldest turns are removed if the summary still
+does not fit
+*/
+type Summarise struct {
+	Summarise Summariser
+}
+
+/*
+describeTurn
+
+the text of a message as it is shown to the summariser, images are replaced by a placeholder
+*/
+func describeTurn(convo *messages.ConversationBuilder, index int) string {
+	var text string
+
+	switch convo.GetMessageType(index) {
+	case "standard":
+		text = convo.ConvertToStandard(index).Content
+	case "assistant":
+		if content := convo.ConvertToAssistant(index).Content; content != nil {
+			text = *content
+		} else {
+			text = "[tool call]"
+		}
+	case "tool":
+		text = convo.ConvertToTool(index).Content
+	case "multimodal":
+		var parts []string
+
+		for _, content := range convo.ConvertToMultiModal(index).Content {
+			if content.Text != nil {
+				parts = append(parts, *content.Text)
+			} else {
+				parts = append(parts, "[image]")
+			}
+		}
+
+		text = strings.Join(parts, "\n")
+	}
+
+	return fmt.Sprintf("%s: %s", convo.GetRole(index), text)
+}
+
+func (s Summarise) Truncate(ctx context.Context, convo *messages.ConversationBuilder, budget int) error {
+	if EstimateTokens(convo) <= budget {
+		return nil
+	}
+
+	if s.Summarise == nil {
+		return errors.New("summarise truncation has no summariser")
+	}
+
+	removable := removableTurns(convo)
+
+	if len(removable) == 0 {
+		return budgetError(convo, budget)
+	}
+
+	turns := make([]string, len(removable))
+	for index, turn := range removable {
+		turns[index] = describeTurn(convo, turn)
+	}
+
+	// the summary request must fit in the budget too, the most recent turns are kept
+	transcript := strings.Join(turns, "\n\n")
+	if limit := budget * charsPerToken / 2; len(transcript) > limit {
+		transcript = transcript[len(transcript)-limit:]
+	}
+
+	summaryBuilder := &messages.ConversationBuilder{}
+	summaryBuilder.AddStandardMessage(&messages.StandardMessage{
+		Role:    "system",
+		Content: "summarise the following conversation concisely, keep every fact needed to continue it",
+	}).AddStandardMessage(&messages.StandardMessage{
+		Role:    "user",
+		Content: transcript,
+	})
+
+	err, summaryConvo := summaryBuilder.Build()
+
+	if err != nil {
+		return err
+	}
+
+	err, summary := s.Summarise(ctx, summaryConvo)
+
+	if err != nil {
+		return fmt.Errorf("could not summarise the conversation: %v", err)
+	}
+
+	for index := len(removable) - 1; index >= 0; index-- {
+		convo.Pop(removable[index])
+	}
+
+	convo.InsertStandardMessage(removable[0], &messages.StandardMessage{
+		Role:    "system",
+		Content: "a summary of the earlier conversation: " + summary,
+	})
+
+	return DropOldest{}.Truncate(ctx, convo, budget)
+}
+
+/*
+GetTruncator
+
+the truncation strategy with a name, summarise requires a summariser
+*/
+func GetTruncator(name string, summariser Summariser) (error, Truncator) {
+	switch name {
+	case "dropOldest":
+		return nil, DropOldest{}
+	case "dropImages":
+		return nil, DropImages{}
+	case "summarise":
+		return nil, Summarise{Summarise: summariser}
+	}
+
+	return fmt.Errorf("truncation strategy must be dropOldest, dropImages or summarise got %s", name), nil
+}
+
+/*
+TokenBudget
+
+the tokens a conversation can use, the context window minus the tokens reserved for the response
+*/
+func (c *ChatGpt) TokenBudget() int {
+	budget := c.ContextWindow()
+
+	if c.MaxTokens != nil {
+		budget -= *c.MaxTokens
+	}
+
+	return budget
+}
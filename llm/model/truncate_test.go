@@ -0,0 +1,105 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"huan/llm/messages"
+	"strings"
+	"testing"
+)
+
+func getLongBuilder(t *testing.T) *messages.ConversationBuilder {
+	answer := "[]"
+	turn := strings.Repeat("a", 400) // 100 tokens
+
+	mm := messages.MultiModalMessage{Role: "user"}
+	mm.AppendImageBytes([]byte("image"), nil, "png") // 765 tokens
+	mm.AppendText("the page")
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "system", Content: "collect"}).
+		AddMultimodalMessage(&mm).
+		AddStandardMessage(&messages.StandardMessage{Role: "user", Content: turn}).
+		AddAssistantMessage(&messages.AssistantMessage{Role: "assistant", Content: &answer}).
+		AddStandardMessage(&messages.StandardMessage{Role: "user", Content: turn})
+
+	return builder
+}
+
+func TestDropOldest_Truncate(t *testing.T) {
+	builder := getLongBuilder(t)
+
+	if err := (DropOldest{}).Truncate(context.Background(), builder, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	if builder.Size() != 3 || builder.GetRole(0) != "system" || builder.GetRole(2) != "user" {
+		t.Errorf("expected the system message and the last turns to be kept, got %d messages", builder.Size())
+	}
+
+	if err := (DropOldest{}).Truncate(context.Background(), builder, 50); err == nil {
+		t.Error("a conversation that could not fit was accepted")
+	}
+}
+
+func TestDropImages_Truncate(t *testing.T) {
+	builder := getLongBuilder(t)
+	_, built := builder.Build()
+	original := EstimateTokens(builder)
+
+	if err := (DropImages{}).Truncate(context.Background(), builder, original-700); err != nil {
+		t.Fatal(err)
+	}
+
+	if builder.Size() != 5 {
+		t.Fatalf("only the image should have been dropped, %d messages remain", builder.Size())
+	}
+
+	if content := builder.ConvertToMultiModal(1).Content; len(content) != 1 || content[0].Type != "text" {
+		t.Error("the image was not removed from the message")
+	}
+
+	if len(built[1].(*messages.MultiModalMessage).Content) != 2 {
+		t.Error("a conversation that was already built was altered")
+	}
+}
+
+func TestSummarise_Truncate(t *testing.T) {
+	builder := getLongBuilder(t)
+	var asked string
+
+	summarise := Summarise{Summarise: func(ctx context.Context, convo messages.Conversation) (error, string) {
+		asked = convo[1].(*messages.StandardMessage).Content
+		return nil, "the user asked for data"
+	}}
+
+	if err := summarise.Truncate(context.Background(), builder, 300); err != nil {
+		t.Fatal(err)
+	}
+
+	if builder.Size() != 3 {
+		t.Fatalf("expected the system, summary and last user turn got %d messages", builder.Size())
+	}
+
+	if !strings.Contains(builder.ConvertToStandard(1).Content, "the user asked for data") {
+		t.Error("the summary was not inserted")
+	}
+
+	if !strings.Contains(asked, "[image]") || !strings.Contains(asked, "assistant: []") {
+		t.Errorf("the summariser was not shown the removed turns: %s", asked)
+	}
+
+	failing := Summarise{Summarise: func(ctx context.Context, convo messages.Conversation) (error, string) {
+		return errors.New("rate limited"), ""
+	}}
+
+	if err := failing.Truncate(context.Background(), getLongBuilder(t), 300); err == nil {
+		t.Error("a failed summary was ignored")
+	}
+}
+
+func TestGetTruncator(t *testing.T) {
+	if err, _ := GetTruncator("dropNewest", nil); err == nil {
+		t.Error("an unknown strategy was accepted")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"huan/llm/messages"
@@ -8,6 +10,8 @@ import (
 	"huan/scraper/fetch"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 /*
@@ -20,7 +24,55 @@ func buildFromYaml(bytes []byte) (error, *scraper.Session) {
 	return err, config
 }
 
-func Start(s *scraper.Session) {
+/*
+runOptions
+
+options provided on the command line
+*/
+type runOptions struct {
+	noCache bool   // skip the llm response cache entirely
+	refresh bool   // ignore cached llm responses but cache new ones
+	resume  string // the name of an interrupted session to continue
+}
+
+/*
+notifyShutdown
+
+ties the session to SIGINT and SIGTERM. the first signal closes stop so the session finishes the work in progress and
+writes its data, a second signal cancels the returned context so the session ends at once
+*/
+func notifyShutdown(lg func(message string)) (context.Context, <-chan struct{}, func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+
+		lg("received a shutdown signal, finishing the work in progress, signal again to stop at once")
+		close(stop)
+
+		select {
+		case <-signals:
+			lg("received a second shutdown signal, stopping at once")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, stop, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+func Start(s *scraper.Session, opts runOptions) {
 
 	err, model := scraper.InitLanguageModel(
 		s.LlmConfig.Type,
@@ -29,7 +81,9 @@ func Start(s *scraper.Session) {
 		s.LlmConfig.MaxTokens,
 		s.LlmConfig.Duration,
 		s.Settings.Verbose,
-		s.LlmConfig.Workers)
+		s.LlmConfig.Workers,
+		s.LlmConfig.Mode,
+		s.LlmConfig.Poll)
 
 	lg := func(message string) {
 		if s.Settings.Verbose {
@@ -42,6 +96,60 @@ func Start(s *scraper.Session) {
 		return
 	}
 
+	err, cache := s.BuildCache(opts.noCache, opts.refresh)
+
+	if err != nil {
+		lg(fmt.Sprintf("could not initialize the response cache due to error: %v", err))
+		return
+	}
+
+	model.SetCache(cache)
+
+	err, client := s.BuildTransport()
+
+	if err != nil {
+		lg(fmt.Sprintf("could not initialize the http transport due to error: %v", err))
+		return
+	}
+
+	if client != nil {
+		if err = model.SetHttpClient(client); err != nil {
+			lg(fmt.Sprintf("could not apply the http transport due to error: %v", err))
+			return
+		}
+	}
+
+	if s.LlmConfig.Record != nil {
+		if err = model.Record(*s.LlmConfig.Record); err != nil {
+			lg(fmt.Sprintf("could not open the cassette due to error: %v", err))
+			return
+		}
+	}
+
+	if s.LlmConfig.Truncation != nil {
+		if err = model.SetTruncation(*s.LlmConfig.Truncation); err != nil {
+			lg(fmt.Sprintf("could not set the truncation strategy due to error: %v", err))
+			return
+		}
+	}
+
+	if s.LlmConfig.Transcript != nil {
+		if err = model.Transcript(*s.LlmConfig.Transcript); err != nil {
+			lg(fmt.Sprintf("could not open the transcript due to error: %v", err))
+			return
+		}
+	}
+
+	defer func() {
+		if err := model.Close(); err != nil {
+			lg(fmt.Sprintf("could not close the language model due to error: %v", err))
+		}
+	}()
+
+	if opts.resume != "" {
+		s.Settings.SessionName = &opts.resume
+	}
+
 	err, sett := s.BuildSettings()
 
 	if err != nil {
@@ -49,6 +157,8 @@ func Start(s *scraper.Session) {
 		return
 	}
 
+	sett.Resume = opts.resume != ""
+
 	if s.Fetch != nil {
 		err, fet := s.BuildFetchSettings()
 
@@ -57,9 +167,19 @@ func Start(s *scraper.Session) {
 			return
 		}
 
+		if fet.Confidence != nil {
+			if err = model.EnableLogprobs(); err != nil {
+				lg(fmt.Sprintf("could not enable confidence scoring due to error: %v", err))
+				return
+			}
+		}
+
 		builder := &messages.ConversationBuilder{}
 
-		err = fetch.Collect(model, fet, sett, builder, lg)
+		ctx, stop, release := notifyShutdown(lg)
+		defer release()
+
+		err = fetch.Collect(ctx, stop, model, fet, sett, builder, lg)
 
 		if err != nil {
 			lg(fmt.Sprintf("experienced error when writing data collection: %v", err))
@@ -71,6 +191,23 @@ func Start(s *scraper.Session) {
 }
 
 func main() {
+	var opts runOptions
+
+	flag.BoolVar(&opts.noCache, "no-cache", false, "do not read or write the llm response cache")
+	flag.BoolVar(&opts.refresh, "refresh", false, "ignore cached llm responses and replace them with new ones")
+	flag.StringVar(&opts.resume, "resume", "", "continue an interrupted fetch session from its saved state")
+
+	// fetch is the only command so naming it is optional eg: huan fetch --resume <session>
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "fetch" {
+		args = args[1:]
+	}
+
+	if err := flag.CommandLine.Parse(args); err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	fPath := "./config.yaml"
 
 	bytes, err := os.ReadFile(fPath)
@@ -87,5 +224,5 @@ func main() {
 		return
 	}
 
-	Start(config)
+	Start(config, opts)
 }
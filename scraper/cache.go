@@ -0,0 +1,404 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"huan/jsonparser"
+	"huan/llm/messages"
+	"huan/llm/model"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+keyer
+
+a llm that can hash a request into a key, requests with the same key always produce interchangeable responses
+*/
+type keyer interface {
+	CacheKey(convo messages.Conversation, opts *model.Options) (error, string)
+}
+
+/*
+cacheEntry
+
+a cached chat completion or embedding as it is stored on disk
+*/
+type cacheEntry struct {
+	Created    time.Time                `json:"created"`
+	Completion *messages.ChatCompletion `json:"completion,omitempty"`
+	Embedding  []float32                `json:"embedding,omitempty"`
+}
+
+/*
+ResponseCache
+
+a content addressed on disk cache of chat completions
+*/
+type ResponseCache struct {
+	dir     string
+	ttl     time.Duration // how long an entry is valid for, 0 means forever
+	maxSize int64         // the max size of the cache in bytes, 0 means unlimited
+	refresh bool          // ignore existing entries, new responses are still written
+	size    int64         // the total size of the entries, it is kept up to date so writes do not scan the cache
+	lock    sync.Mutex
+}
+
+/*
+cacheFile
+
+an entry of the cache as it is found on disk
+*/
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+/*
+NewResponseCache
+
+creates a cache stored in dir, the directory is created if it does not exist. only the entries of the cache are ever
+removed so dir may hold other files
+*/
+func NewResponseCache(dir string, ttl time.Duration, maxSize int64, refresh bool) (error, *ResponseCache) {
+	if dir == "" {
+		return errors.New("cache directory cannot be empty"), nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err, nil
+	}
+
+	cache := &ResponseCache{
+		dir:     dir,
+		ttl:     ttl,
+		maxSize: maxSize,
+		refresh: refresh,
+	}
+
+	err, files := cache.entries()
+
+	if err != nil {
+		return err, nil
+	}
+
+	for _, f := range files {
+		cache.size += f.size
+	}
+
+	return nil, cache
+}
+
+func (r *ResponseCache) path(key string) string {
+	return filepath.Join(r.dir, key[:2], key+".json")
+}
+
+/*
+isHex
+
+whether a name only holds lower case hex digits, cache keys are hex encoded hashes
+*/
+func isHex(name string) bool {
+	for _, char := range name {
+		if (char < '0' || char > '9') && (char < 'a' || char > 'f') {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+/*
+entries
+
+the entries stored in the cache, these are the <xx>/<key>.json files where xx starts the key. anything else in the
+directory is left out
+*/
+func (r *ResponseCache) entries() (error, []cacheFile) {
+	dirs, err := os.ReadDir(r.dir)
+
+	if err != nil {
+		return err, nil
+	}
+
+	var files []cacheFile
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+
+		found, err := os.ReadDir(filepath.Join(r.dir, dir.Name()))
+
+		if err != nil {
+			return err, nil
+		}
+
+		for _, entry := range found {
+			key, ok := strings.CutSuffix(entry.Name(), ".json")
+
+			if entry.IsDir() || !ok || !strings.HasPrefix(key, dir.Name()) || !isHex(key) {
+				continue
+			}
+
+			info, err := entry.Info()
+
+			if err != nil {
+				return err, nil
+			}
+
+			files = append(files, cacheFile{
+				path:    filepath.Join(r.dir, dir.Name(), entry.Name()),
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			})
+		}
+	}
+
+	return nil, files
+}
+
+/*
+remove
+
+deletes an entry and takes it out of the size of the cache, the lock must be held by the caller
+*/
+func (r *ResponseCache) remove(path string) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+
+	r.size -= info.Size()
+	return nil
+}
+
+/*
+read
+
+loads the entry stored under a key, ok is false when there is no valid entry or the cache is being refreshed
+*/
+func (r *ResponseCache) read(key string) (*cacheEntry, bool) {
+	if r.refresh || len(key) < 2 {
+		return nil, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	path := r.path(key)
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		_ = r.remove(path)
+		return nil, false
+	}
+
+	if r.ttl > 0 && time.Since(entry.Created) > r.ttl {
+		_ = r.remove(path)
+		return nil, false
+	}
+
+	// the modification time tracks when an entry was last used, the least recently used entries are evicted first
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return &entry, true
+}
+
+/*
+write
+
+stores an entry, then evicts the least recently used entries until the cache fits its size cap
+*/
+func (r *ResponseCache) write(key string, entry cacheEntry) error {
+	if len(key) < 2 {
+		return errors.New("cache key is too short")
+	}
+
+	entry.Created = time.Now()
+	data, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	path := r.path(key)
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// an entry written again replaces the old one so only the difference in size is added
+	if info, err := os.Stat(path); err == nil {
+		r.size -= info.Size()
+	}
+
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	r.size += int64(len(data))
+
+	return r.evict()
+}
+
+/*
+Get
+
+retrieves a cached completion, ok is false when there is no valid entry or the cache is being refreshed
+*/
+func (r *ResponseCache) Get(key string) (*messages.ChatCompletion, bool) {
+	entry, ok := r.read(key)
+
+	if !ok || entry.Completion == nil {
+		return nil, false
+	}
+
+	return entry.Completion, true
+}
+
+/*
+Put
+
+stores a completion, then evicts the least recently used entries until the cache fits its size cap
+*/
+func (r *ResponseCache) Put(key string, completion *messages.ChatCompletion) error {
+	return r.write(key, cacheEntry{Completion: completion})
+}
+
+/*
+GetEmbedding
+
+retrieves a cached embedding, ok is false when there is no valid entry or the cache is being refreshed
+*/
+func (r *ResponseCache) GetEmbedding(key string) ([]float32, bool) {
+	entry, ok := r.read(key)
+
+	if !ok || entry.Embedding == nil {
+		return nil, false
+	}
+
+	return entry.Embedding, true
+}
+
+/*
+PutEmbedding
+
+stores an embedding, then evicts the least recently used entries until the cache fits its size cap
+*/
+func (r *ResponseCache) PutEmbedding(key string, embedding []float32) error {
+	return r.write(key, cacheEntry{Embedding: embedding})
+}
+
+/*
+embeddingKey
+
+the cache key of a text embedded by an engine, vectors from different engines are not interchangeable
+*/
+func embeddingKey(engine model.EmbeddingEngine, text string) string {
+	hash := sha256.Sum256([]byte("embedding\x00" + engine.Name + "\x00" + text))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+evict
+
+removes the least recently used entries until the cache is under its size cap, the lock must be held by the caller.
+the cache is only scanned once it is over the cap
+*/
+func (r *ResponseCache) evict() error {
+	if r.maxSize <= 0 || r.size <= r.maxSize {
+		return nil
+	}
+
+	err, files := r.entries()
+
+	if err != nil {
+		return err
+	}
+
+	// the scan corrects the running total in case entries were changed by another process
+	r.size = 0
+	for _, f := range files {
+		r.size += f.size
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, f := range files {
+		if r.size <= r.maxSize {
+			break
+		}
+
+		if err = os.Remove(f.path); err != nil {
+			return err
+		}
+
+		r.size -= f.size
+	}
+
+	return nil
+}
+
+/*
+isCacheable
+
+only successful responses where every choice finished and contains parseable json are cached, an empty list is a
+valid answer too
+*/
+func isCacheable(completion *messages.ChatCompletion) bool {
+	if completion == nil || len(completion.Choices) == 0 {
+		return false
+	}
+
+	for _, choice := range completion.Choices {
+		if choice.FinishReason == "length" || choice.Message.Content == nil {
+			return false
+		}
+
+		if !parsesAsJson(*choice.Message.Content) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+parsesAsJson
+
+checks if a response is json of any kind, such as a list of records, an empty list or a list of indexes, or has records
+the parser can pull out of it
+*/
+func parsesAsJson(content string) bool {
+	trimmed := jsonparser.RemoveIdentifier(strings.TrimSpace(content))
+
+	if json.Valid([]byte(strings.TrimSpace(trimmed))) {
+		return true
+	}
+
+	return len(jsonparser.ToJson(content)) > 0
+}
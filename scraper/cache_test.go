@@ -0,0 +1,266 @@
+package scraper
+
+import (
+	"context"
+	"huan/llm/messages"
+	"huan/llm/model"
+	"huan/llm/model/standin"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCompletion(content string) *messages.ChatCompletion {
+	return &messages.ChatCompletion{
+		Choices: []messages.Choice{
+			{
+				FinishReason: "stop",
+				Message:      messages.Message{Role: "assistant", Content: &content},
+			},
+		},
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+
+	t.Run("get and put", func(t *testing.T) {
+		err, cache := NewResponseCache(t.TempDir(), 0, 0, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := cache.Get(key); ok {
+			t.Error("empty cache returned an entry")
+		}
+
+		if err = cache.Put(key, testCompletion(`[{"a": 1}]`)); err != nil {
+			t.Fatal(err)
+		}
+
+		completion, ok := cache.Get(key)
+
+		if !ok || *completion.Choices[0].Message.Content != `[{"a": 1}]` {
+			t.Error("cached completion was not returned")
+		}
+	})
+
+	t.Run("expired entries are ignored", func(t *testing.T) {
+		err, cache := NewResponseCache(t.TempDir(), time.Millisecond, 0, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = cache.Put(key, testCompletion(`[{"a": 1}]`)); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		if _, ok := cache.Get(key); ok {
+			t.Error("expired entry was returned")
+		}
+	})
+
+	t.Run("refresh ignores existing entries", func(t *testing.T) {
+		dir := t.TempDir()
+		_, cache := NewResponseCache(dir, 0, 0, false)
+		_ = cache.Put(key, testCompletion(`[{"a": 1}]`))
+
+		_, refreshed := NewResponseCache(dir, 0, 0, true)
+
+		if _, ok := refreshed.Get(key); ok {
+			t.Error("refreshing cache returned an entry")
+		}
+	})
+
+	t.Run("size cap evicts the least recently used entries", func(t *testing.T) {
+		dir := t.TempDir()
+		_, cache := NewResponseCache(dir, 0, 0, false)
+
+		other := strings.Repeat("cd", 32)
+		_ = cache.Put(key, testCompletion(`[{"a": 1}]`))
+
+		info, err := os.Stat(cache.path(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		past := time.Now().Add(-time.Hour)
+		_ = os.Chtimes(cache.path(key), past, past)
+
+		cache.maxSize = info.Size() + 1
+		_ = cache.Put(other, testCompletion(`[{"a": 1}]`))
+
+		if _, ok := cache.Get(key); ok {
+			t.Error("least recently used entry was not evicted")
+		}
+
+		if _, ok := cache.Get(other); !ok {
+			t.Error("newest entry was evicted")
+		}
+	})
+
+	t.Run("eviction only removes cache entries", func(t *testing.T) {
+		dir := t.TempDir()
+		notes := filepath.Join(dir, "notes.txt")
+		nested := filepath.Join(dir, "ab", "notes.json")
+
+		_ = os.MkdirAll(filepath.Dir(nested), 0755)
+		_ = os.WriteFile(notes, []byte(strings.Repeat("huan", 1000)), 0644)
+		_ = os.WriteFile(nested, []byte(strings.Repeat("huan", 1000)), 0644)
+
+		past := time.Now().Add(-time.Hour)
+		_ = os.Chtimes(notes, past, past)
+		_ = os.Chtimes(nested, past, past)
+
+		_, cache := NewResponseCache(dir, 0, 1, false)
+
+		if cache.size != 0 {
+			t.Errorf("files that are not entries were counted, the cache size is %d", cache.size)
+		}
+
+		_ = cache.Put(key, testCompletion(`[{"a": 1}]`))
+
+		for _, path := range []string{notes, nested} {
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%s was removed by the cache", path)
+			}
+		}
+
+		if _, err := os.Stat(cache.path(key)); err == nil || cache.size != 0 {
+			t.Errorf("the entry over the cap was kept, the cache size is %d", cache.size)
+		}
+	})
+
+	t.Run("the size is tracked across writes", func(t *testing.T) {
+		dir := t.TempDir()
+		_, cache := NewResponseCache(dir, 0, 0, false)
+
+		_ = cache.Put(key, testCompletion(`[{"a": 1}]`))
+		_ = cache.Put(key, testCompletion(`[{"a": 2}]`))
+
+		info, err := os.Stat(cache.path(key))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cache.size != info.Size() {
+			t.Errorf("expected the size of a rewritten entry %d got %d", info.Size(), cache.size)
+		}
+
+		// an existing cache is measured when it is opened
+		if _, reopened := NewResponseCache(dir, 0, 0, false); reopened.size != info.Size() {
+			t.Errorf("expected the reopened cache to hold %d bytes got %d", info.Size(), reopened.size)
+		}
+	})
+}
+
+func Test_isCacheable(t *testing.T) {
+	truncated := testCompletion(`[{"a": 1}]`)
+	truncated.Choices[0].FinishReason = "length"
+
+	tests := []struct {
+		completion *messages.ChatCompletion
+		cacheable  bool
+		name       string
+	}{
+		{completion: testCompletion(`[{"a": 1}]`), cacheable: true, name: "valid json"},
+		{completion: testCompletion("[]"), cacheable: true, name: "no records"},
+		{completion: testCompletion("```json\n[0, 3, 5]\n```"), cacheable: true, name: "picked links"},
+		{completion: testCompletion(`no json here`), cacheable: false, name: "unparseable"},
+		{completion: &messages.ChatCompletion{Choices: []messages.Choice{{}}}, cacheable: false, name: "no content"},
+		{completion: truncated, cacheable: false, name: "truncated"},
+		{completion: &messages.ChatCompletion{}, cacheable: false, name: "no choices"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if isCacheable(tt.completion) != tt.cacheable {
+				t.Errorf("expected cacheable to be %t", tt.cacheable)
+			}
+		})
+	}
+}
+
+func TestLanguageModel_Complete_cache(t *testing.T) {
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		return `[{"content": "123"}]`
+	})
+	defer server.Close()
+
+	_, cache := NewResponseCache(t.TempDir(), 0, 0, false)
+
+	lang := LanguageModel{
+		tryLimit: 1,
+		duration: 10,
+		bot:      &model.ChatGpt{Model: "gpt-4o", Key: "test", BaseUrl: server.URL},
+	}
+	lang.SetCache(cache)
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect"})
+	_, convo := builder.Build()
+
+	for range 3 {
+		if err, _ := lang.Complete(context.Background(), &convo, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if server.Requests() != 1 {
+		t.Errorf("expected 1 request to reach the api got %d", server.Requests())
+	}
+
+	temperature := float32(1)
+	if err, _ := lang.Complete(context.Background(), &convo, &model.Options{Temperature: &temperature}); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Requests() != 2 {
+		t.Error("requests with different sampling settings shared a cache entry")
+	}
+}
+
+func TestLanguageModel_Embed_cache(t *testing.T) {
+	server := standin.NewServer(nil)
+	defer server.Close()
+
+	_, cache := NewResponseCache(t.TempDir(), 0, 0, false)
+
+	lang := LanguageModel{
+		bot: &model.ChatGpt{Key: "test", BaseUrl: server.URL, EmbeddingModel: "text-embedding-3-small"},
+	}
+	lang.SetCache(cache)
+
+	err, first := lang.Embed(context.Background(), []string{"red shoes", "blue hat"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, second := lang.Embed(context.Background(), []string{"blue hat", "green scarf", "red shoes"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.EmbeddingRequests() != 2 {
+		t.Errorf("expected 2 embedding requests got %d", server.EmbeddingRequests())
+	}
+
+	if len(second) != 3 || second[0][0] != first[1][0] || second[2][0] != first[0][0] {
+		t.Error("cached embeddings were not returned in the order of the texts")
+	}
+
+	for _, vector := range second {
+		if len(vector) != 1536 {
+			t.Errorf("expected 1536 dimensions got %d", len(vector))
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huan/llm/messages"
+	"huan/llm/model"
+	scraper2 "huan/scraper"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// returned when the session is stopped while its batch job is still running
+var errBatchStopped = errors.New("the session was stopped before the batch job completed")
+
+/*
+batchRequest
+
+a chunk conversation waiting to be completed by a batch job, along with where it came from
+*/
+type batchRequest struct {
+	Url          string                `json:"url"`
+	Page         string                `json:"page"` // the label of the page, the pages of a url are told apart by it
+	Chunk        int                   `json:"chunk"`
+	Conversation messages.Conversation `json:"conversation"` // kept so a resumed batch can continue and repair
+}
+
+/*
+batchCollector
+
+gathers the chunk conversations of every scraped url so they can be submitted as a single batch job
+*/
+type batchCollector struct {
+	lock     sync.Mutex
+	requests []batchRequest
+}
+
+/*
+add
+
+queues the chunk conversations of a page of a url
+*/
+func (b *batchCollector) add(url, page string, convos []messages.Conversation) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for index, convo := range convos {
+		b.requests = append(b.requests, batchRequest{
+			Url:          url,
+			Page:         page,
+			Chunk:        index,
+			Conversation: convo,
+		})
+	}
+}
+
+/*
+snapshot
+
+a copy of every queued request, scrapers still running cannot alter it
+*/
+func (b *batchCollector) snapshot() []batchRequest {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	requests := make([]batchRequest, len(b.requests))
+	copy(requests, b.requests)
+	return requests
+}
+
+/*
+batchState
+
+what is needed to resume a batch job after the process restarts
+*/
+type batchState struct {
+	BatchId  string         `json:"batchId"`
+	Requests []batchRequest `json:"requests"`
+}
+
+func batchStatePath(savePath, sessionName string) string {
+	return filepath.Join(savePath, fmt.Sprintf("%s-batch.json", sessionName))
+}
+
+/*
+loadBatchState
+
+reads the state of a previously submitted batch job, a nil state is returned if there is none
+*/
+func loadBatchState(path string) (error, *batchState) {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return err, nil
+	}
+
+	var state batchState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return err, nil
+	}
+
+	if state.BatchId == "" {
+		return nil, nil
+	}
+
+	return nil, &state
+}
+
+func saveBatchState(path string, state *batchState) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0666)
+}
+
+/*
+batchCustomId
+
+the id of a request in a batch input file, every chunk can have several samples when self consistency is enabled
+*/
+func batchCustomId(request, sample int) string {
+	return fmt.Sprintf("request-%d-%d", request, sample)
+}
+
+/*
+parseBatchCustomId
+
+the index of the chunk request a custom id belongs to
+*/
+func parseBatchCustomId(customId string) (error, int) {
+	parts := strings.Split(customId, "-")
+
+	if len(parts) != 3 || parts[0] != "request" {
+		return fmt.Errorf("unknown batch custom id %s", customId), 0
+	}
+
+	index, err := strconv.Atoi(parts[1])
+	return err, index
+}
+
+/*
+buildBatchFile
+
+converts the collected chunk conversations into a jsonl batch input file
+*/
+func buildBatchFile(
+	batcher model.Batcher,
+	requests []batchRequest,
+	consistency *scraper2.Consistency) (error, []byte) {
+
+	var builder strings.Builder
+
+	writeLine := func(customId string, convo messages.Conversation, opts *model.Options) error {
+		err, line := batcher.BatchLine(customId, convo, opts)
+
+		if err != nil {
+			return err
+		}
+
+		builder.Write(line)
+		builder.WriteString("\n")
+		return nil
+	}
+
+	for index, request := range requests {
+		var err error
+
+		switch {
+		case consistency == nil:
+			err = writeLine(batchCustomId(index, 0), request.Conversation, nil)
+		case consistency.Strategy == "n":
+			n := int(consistency.Samples)
+			err = writeLine(batchCustomId(index, 0), request.Conversation, &model.Options{N: &n})
+		default:
+			for sample, temperature := range consistency.Temperatures {
+				opts := &model.Options{Temperature: &temperature}
+
+				if err = writeLine(batchCustomId(index, sample), request.Conversation, opts); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	return nil, []byte(builder.String())
+}
+
+/*
+submitBatch
+
+uploads the collected chunk conversations and starts a batch job, the job is saved so the session can be resumed
+*/
+func submitBatch(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	requests []batchRequest,
+	fetchSettings *scraper2.Fetch,
+	statePath string,
+	logger func(message string)) (error, *batchState) {
+
+	err, batcher := llm.GetBatcher()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, data := buildBatchFile(batcher, requests, fetchSettings.Consistency)
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, fileId := batcher.UploadBatchFile(ctx, data)
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, batch := batcher.CreateBatch(ctx, fileId)
+
+	if err != nil {
+		return err, nil
+	}
+
+	state := &batchState{
+		BatchId:  batch.Id,
+		Requests: requests,
+	}
+
+	if err = saveBatchState(statePath, state); err != nil {
+		return err, nil
+	}
+
+	logger(fmt.Sprintf("submitted batch %s with %d chunk requests", batch.Id, len(state.Requests)))
+	return nil, state
+}
+
+/*
+awaitBatch
+
+polls a batch job until it stops processing, errors if it did not complete. closing stop gives the job the grace
+period to complete, errBatchStopped is returned once it is over
+*/
+func awaitBatch(
+	ctx context.Context,
+	stop <-chan struct{},
+	grace time.Duration,
+	batcher model.Batcher,
+	batchId string,
+	interval time.Duration,
+	logger func(message string)) (error, *model.Batch) {
+
+	stopping := stop
+	var timeout <-chan time.Time
+
+	for {
+		err, batch := batcher.GetBatch(ctx, batchId)
+
+		if err != nil {
+			return err, nil
+		}
+
+		if batch.IsDone() {
+			if batch.Status != "completed" {
+				return fmt.Errorf("batch %s stopped with status %s", batchId, batch.Status), nil
+			}
+
+			return nil, batch
+		}
+
+		logger(fmt.Sprintf(
+			"batch %s is %s, %d of %d requests completed",
+			batchId,
+			batch.Status,
+			batch.RequestCounts.Completed,
+			batch.RequestCounts.Total))
+
+		// a stop arriving while waiting for the next poll is handled before polling again
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return ctx.Err(), nil
+			case <-stopping:
+				logger(fmt.Sprintf("stopping the session, batch %s has %v to complete", batchId, grace))
+				stopping, timeout = nil, time.After(grace)
+			case <-timeout:
+				return errBatchStopped, nil
+			case <-time.After(interval):
+				waiting = false
+			}
+		}
+	}
+}
+
+/*
+batchPage
+
+the chunk records of a page of a batch job
+*/
+type batchPage struct {
+	url    string
+	label  string
+	chunks [][]*record
+}
+
+/*
+groupBatchPages
+
+groups the records of every chunk request by the page it belongs to, pages keep the order they were scraped in
+*/
+func groupBatchPages(requests []batchRequest, records [][]*record) []*batchPage {
+	var pages []*batchPage
+	byLabel := map[string]*batchPage{}
+
+	for index, request := range requests {
+		// batches submitted before pages were labelled only know their url
+		label := request.Page
+		if label == "" {
+			label = request.Url
+		}
+
+		page, ok := byLabel[label]
+
+		if !ok {
+			page = &batchPage{url: request.Url, label: label}
+			byLabel[label] = page
+			pages = append(pages, page)
+		}
+
+		for len(page.chunks) <= request.Chunk {
+			page.chunks = append(page.chunks, nil)
+		}
+
+		page.chunks[request.Chunk] = records[index]
+	}
+
+	return pages
+}
+
+/*
+finishBatch
+
+waits for a batch job to complete then maps each result back to its chunk. the chunks of every page go through the
+same steps as in sync mode, overlapping records are merged or dropped and the detail pages are visited
+*/
+func finishBatch(
+	ctx context.Context,
+	stop <-chan struct{},
+	llm *scraper2.LanguageModel,
+	state *batchState,
+	details *detailStage,
+	fetchSettings *scraper2.Fetch,
+	stats *sessionStats,
+	logger func(message string)) (error, []map[string]interface{}) {
+
+	err, batcher := llm.GetBatcher()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, batch := awaitBatch(
+		ctx, stop, fetchSettings.GracePeriod, batcher, state.BatchId, llm.GetPollInterval(), logger)
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, output := batcher.DownloadFile(ctx, batch.OutputFileId)
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, results := model.ParseBatchOutput(output)
+
+	if err != nil {
+		return err, nil
+	}
+
+	template, err := json.MarshalIndent(listTemplate(fetchSettings.ExampleTemplate, fetchSettings.Detail), "", " ")
+
+	if err != nil {
+		return err, nil
+	}
+
+	choices := make([][]messages.Choice, len(state.Requests))
+
+	for _, result := range results {
+		err, index := parseBatchCustomId(result.CustomId)
+
+		if err != nil || index >= len(state.Requests) {
+			logger(fmt.Sprintf("skipping unknown batch result %s", result.CustomId))
+			continue
+		}
+
+		request := state.Requests[index]
+
+		if result.Error != nil {
+			logger(fmt.Sprintf("chunk %d of %s failed: %v", request.Chunk, request.Url, result.Error))
+			continue
+		}
+
+		choices[index] = append(choices[index], result.Completion.Choices...)
+	}
+
+	records := make([][]*record, len(state.Requests))
+
+	for index, chunkChoices := range choices {
+		if len(chunkChoices) == 0 {
+			continue
+		}
+
+		request := state.Requests[index]
+
+		continueChoices(ctx, llm, request.Conversation, chunkChoices, logger)
+		repairChoices(ctx, llm, request.Conversation, chunkChoices, string(template), stats, logger)
+
+		records[index] = recordsFromChoices(chunkChoices, fetchSettings.Confidence, fetchSettings.Consistency, logger)
+		logger(fmt.Sprintf("collected %d samples from chunk %d of %s", len(records[index]), request.Chunk, request.Url))
+	}
+
+	var browser context.Context
+
+	if details != nil {
+		// the detail pages are opened in tabs of a browser shared by every page of the batch
+		var cancel context.CancelFunc
+		browser, cancel = initContext(ctx, fetchSettings.Headless, sessionUserAgent(fetchSettings))
+		defer cancel()
+	}
+
+	samples := make([]map[string]interface{}, 0, fetchSettings.MaxSamples)
+
+	for _, page := range groupBatchPages(state.Requests, records) {
+		if len(samples) >= int(fetchSettings.MaxSamples) {
+			break
+		}
+
+		collected := pageSamples(page.chunks, fetchSettings.Screenshots, fetchSettings.Chunking)
+
+		if details != nil {
+			collected = details.collect(browser, page.url, collected)
+		}
+
+		logger(fmt.Sprintf("finished collecting all data of %s", page.label))
+		samples = append(samples, collected...)
+	}
+
+	if len(samples) > int(fetchSettings.MaxSamples) {
+		samples = samples[:fetchSettings.MaxSamples]
+	}
+
+	return nil, samples
+}
+
+/*
+completeBatch
+
+submits the collected chunks as a batch job when it has not been submitted yet, waits for it and writes the
+samples. the saved job is removed once the data is written. a session stopped before the job completed writes what
+was collected and keeps the job so the next run picks it up
+*/
+func completeBatch(
+	ctx context.Context,
+	stop <-chan struct{},
+	llm *scraper2.LanguageModel,
+	collector *batchCollector,
+	state *batchState,
+	details *detailStage,
+	fetchSettings *scraper2.Fetch,
+	set *scraper2.Settings,
+	stats *sessionStats,
+	logger func(message string)) error {
+
+	// a batch job outlives the max runtime of the session, it can take up to a day to complete so only a signal ends
+	// it early. the chunks queued before a signal are still submitted so the work done to scrape them is kept
+	statePath := batchStatePath(fetchSettings.SavePath, set.SessionName)
+
+	if state == nil {
+		requests := collector.snapshot()
+
+		if len(requests) == 0 {
+			logger("no chunks were collected, skipping the batch job")
+			samples := make([]map[string]interface{}, 0)
+			return writeData(&samples, fetchSettings.SavePath, set.SessionName)
+		}
+
+		var err error
+		if err, state = submitBatch(ctx, llm, requests, fetchSettings, statePath, logger); err != nil {
+			return err
+		}
+
+		announceSession(set.SessionName, statePath)
+	}
+
+	err, samples := finishBatch(ctx, stop, llm, state, details, fetchSettings, stats, logger)
+
+	if errors.Is(err, errBatchStopped) {
+		logger(fmt.Sprintf("batch %s is still running, run the session again to collect its results", state.BatchId))
+		samples = make([]map[string]interface{}, 0)
+		logger(stats.summary(len(samples)))
+
+		return writeData(&samples, fetchSettings.SavePath, set.SessionName)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logger(stats.summary(len(samples)))
+
+	if err = writeData(&samples, fetchSettings.SavePath, set.SessionName); err != nil {
+		return err
+	}
+
+	return os.Remove(statePath)
+}
@@ -0,0 +1,213 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"huan/llm/messages"
+	"huan/llm/model"
+	"huan/llm/model/standin"
+	scraper2 "huan/scraper"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_completeBatch(t *testing.T) {
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		return `[{"content": "123"}, {"content": "456"}]`
+	})
+	defer server.Close()
+
+	err, llm := scraper2.GetBatchLanguageModel(&model.ChatGpt{
+		Model:   "gpt-4o",
+		Key:     "test",
+		BaseUrl: server.URL,
+	}, time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := &messages.ConversationBuilder{}
+	chunks := []string{"<p>123</p>", "<p>456</p>"}
+	strs := []*string{&chunks[0], &chunks[1]}
+
+	err, convos := buildChunkConversations(
+		context.Background(),
+		defaultCollectTemplate,
+		scraper2.PromptData{Task: "collect", Template: `{"content": ""}`},
+		&llm,
+		builder,
+		strs,
+		nil,
+		nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector := &batchCollector{}
+	collector.add("https://huan.dev", "https://huan.dev page 1", convos)
+
+	fetchSettings := &scraper2.Fetch{
+		MaxSamples: 3,
+		SavePath:   t.TempDir(),
+	}
+
+	set := &scraper2.Settings{SessionName: "batch"}
+
+	statePath := batchStatePath(fetchSettings.SavePath, set.SessionName)
+	err, state := submitBatch(
+		context.Background(),
+		&llm,
+		collector.snapshot(),
+		fetchSettings,
+		statePath,
+		func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a restart by resuming from the saved state
+	err, resumed := loadBatchState(statePath)
+
+	if err != nil || resumed == nil || resumed.BatchId != state.BatchId {
+		t.Fatalf("batch state was not saved, err: %v", err)
+	}
+
+	if len(resumed.Requests) != 2 || resumed.Requests[1].Chunk != 1 {
+		t.Fatal("batch requests were not saved")
+	}
+
+	if resumed.Requests[1].Page != "https://huan.dev page 1" {
+		t.Fatal("the page of a batch request was not saved")
+	}
+
+	if saved, _ := json.Marshal(resumed.Requests[1].Conversation); string(saved) != mustMarshal(t, convos[1]) {
+		t.Fatalf("the conversation of a batch request was not saved %s", saved)
+	}
+
+	if err = completeBatch(
+		context.Background(), nil, &llm, nil, resumed, nil, fetchSettings, set, &sessionStats{}, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(fetchSettings.SavePath, "batch-fetched.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var samples []map[string]interface{}
+	if err = json.Unmarshal(data, &samples); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 3 {
+		t.Errorf("expected samples to be capped at 3 got %d", len(samples))
+	}
+
+	if _, err = os.Stat(statePath); !os.IsNotExist(err) {
+		t.Error("batch state was not removed once the data was written")
+	}
+
+	if server.Requests() != 2 {
+		t.Errorf("expected 2 chunk requests got %d", server.Requests())
+	}
+}
+
+func Test_groupBatchPages(t *testing.T) {
+	requests := []batchRequest{
+		{Url: "https://huan.dev", Page: "https://huan.dev page 1", Chunk: 0},
+		{Url: "https://huan.dev", Page: "https://huan.dev page 1", Chunk: 1},
+		{Url: "https://huan.dev", Page: "https://huan.dev page 2", Chunk: 0},
+		{Url: "https://huan.dev/dogs", Chunk: 0},
+	}
+
+	beren := map[string]interface{}{"name": "beren"}
+	records := [][]*record{
+		newRecords([]map[string]interface{}{{"name": "huan"}, beren}),
+		newRecords([]map[string]interface{}{beren}),
+		newRecords([]map[string]interface{}{beren}),
+		nil,
+	}
+
+	pages := groupBatchPages(requests, records)
+
+	if len(pages) != 3 || pages[2].label != "https://huan.dev/dogs" || pages[1].url != "https://huan.dev" {
+		t.Fatalf("the pages of a url were not told apart %+v", pages)
+	}
+
+	// beren was repeated by the overlap between the chunks of the first page but found again on the second page
+	first := pageSamples(pages[0].chunks, nil, &scraper2.Chunking{Overlap: 0.1})
+	second := pageSamples(pages[1].chunks, nil, &scraper2.Chunking{Overlap: 0.1})
+
+	if len(first) != 2 || len(second) != 1 {
+		t.Errorf("expected 2 and 1 samples got %d and %d", len(first), len(second))
+	}
+}
+
+/*
+pollingBatcher
+
+a batcher whose job completes after a number of polls
+*/
+type pollingBatcher struct {
+	model.Batcher
+	polls     atomic.Int64
+	completes int64
+}
+
+func (p *pollingBatcher) GetBatch(ctx context.Context, batchId string) (error, *model.Batch) {
+	batch := &model.Batch{Id: batchId, Status: "in_progress"}
+
+	if p.polls.Add(1) >= p.completes {
+		batch.Status = "completed"
+	}
+
+	return nil, batch
+}
+
+func Test_awaitBatch_stop(t *testing.T) {
+	stop := make(chan struct{})
+	close(stop)
+
+	t.Run("the grace period ends", func(t *testing.T) {
+		batcher := &pollingBatcher{completes: 1_000}
+		started := time.Now()
+
+		err, _ := awaitBatch(context.Background(), stop, 50*time.Millisecond, batcher, "huan", time.Hour, func(string) {})
+
+		if !errors.Is(err, errBatchStopped) {
+			t.Errorf("expected the batch to be stopped got %v", err)
+		}
+
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Errorf("the stopped batch was polled for %v", elapsed)
+		}
+	})
+
+	t.Run("the job completes within the grace period", func(t *testing.T) {
+		batcher := &pollingBatcher{completes: 3}
+
+		err, batch := awaitBatch(context.Background(), stop, time.Minute, batcher, "huan", time.Millisecond, func(string) {})
+
+		if err != nil || batch.Status != "completed" {
+			t.Errorf("the batch completing within the grace period was not returned %v", err)
+		}
+	})
+}
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
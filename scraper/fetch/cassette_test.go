@@ -0,0 +1,99 @@
+package fetch
+
+import (
+	"context"
+	"huan/llm/messages"
+	"huan/llm/model"
+	scraper2 "huan/scraper"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_promptPoolReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fetch.cassette.jsonl")
+
+	chunks := []string{"<li>huan</li>", "<li>beren</li>"}
+	strs := []*string{&chunks[0], &chunks[1]}
+	template := `{"name": ""}`
+
+	// record the chunk prompts as they would be sent to the llm
+	recordLlm := scraper2.GetTestLanguageModel(scraper2.TestModel{WorkTime: time.Duration(0)})
+
+	err, convos := buildChunkConversations(
+		context.Background(),
+		defaultCollectTemplate,
+		scraper2.PromptData{Task: "collect names", Template: template},
+		&recordLlm,
+		&messages.ConversationBuilder{},
+		strs,
+		nil,
+		nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, recorder := model.NewRecorder(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, convo := range convos {
+		reply := `[{"name": "` + chunks[index][4:len(chunks[index])-5] + `"}]`
+		completion := &messages.ChatCompletion{
+			Choices: []messages.Choice{{Message: messages.Message{Role: "assistant", Content: &reply}}},
+		}
+
+		if err = recorder.Record(convo, nil, completion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = recorder.Close()
+
+	err, llm := scraper2.InitLanguageModel(
+		"replay",
+		map[string]interface{}{"cassette": path, "match": "strict"},
+		nil, nil, nil, false, nil, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, _ = scraper2.InitLanguageModel(
+		"replay",
+		map[string]interface{}{"cassette": path, "match": "fuzzy", "minSimilarity": 2},
+		nil, nil, nil, false, nil, nil, nil)
+
+	if err == nil {
+		t.Error("expected a minimum similarity over 1 to be rejected")
+	}
+
+	samples := promptPool(
+		2,
+		llm,
+		context.Background(),
+		"https://huan.dev",
+		convos,
+		template,
+		nil,
+		nil,
+		nil,
+		nil,
+		func(string) {})
+
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples got %d", len(samples))
+	}
+
+	names := map[interface{}]bool{}
+	for _, sample := range samples {
+		names[sample["name"]] = true
+	}
+
+	if !names["huan"] || !names["beren"] {
+		t.Errorf("replayed samples do not match their chunks %v", samples)
+	}
+}
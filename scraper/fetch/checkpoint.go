@@ -0,0 +1,387 @@
+package fetch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	samplesLog  = "samples.jsonl"  // a line per finished page holding the samples collected from it
+	progressLog = "progress.jsonl" // a line per finished chunk or url
+	maxLogLine  = 64 << 20         // the longest line a state log can hold
+)
+
+/*
+pageEntry
+
+a line of the sample log, the line is written once every sample of the page was collected so it marks the page as
+finished as well
+*/
+type pageEntry struct {
+	Url     string                   `json:"url"`
+	Page    int                      `json:"page"`
+	Samples []map[string]interface{} `json:"samples"`
+}
+
+/*
+progressEntry
+
+a line of the progress log marking a chunk or a url as finished
+*/
+type progressEntry struct {
+	Chunk   string                   `json:"chunk,omitempty"`   // the label of a finished chunk
+	Records []map[string]interface{} `json:"records,omitempty"` // the records collected from the chunk
+	Url     string                   `json:"url,omitempty"`     // a url whose every page was finished
+	Links   []string                 `json:"links,omitempty"`   // the links found on the url to be crawled
+}
+
+/*
+checkpoint
+
+the state of a fetch session kept on disk so a session that was interrupted can be resumed without repeating finished
+work. a nil checkpoint keeps no state
+*/
+type checkpoint struct {
+	lock     sync.Mutex // serialises writes to the logs
+	samples  *os.File
+	progress *os.File
+	logger   func(message string)
+
+	pages   map[string]struct{}
+	chunks  map[string][]map[string]interface{}
+	urls    map[string][]string
+	resumed []map[string]interface{} // the samples collected before the session was resumed
+}
+
+/*
+stateDir
+
+the directory holding the state of a session
+*/
+func stateDir(savePath, sessionName string) string {
+	return filepath.Join(savePath, fmt.Sprintf("%s-state", sessionName))
+}
+
+/*
+announceSession
+
+prints where the state of a session is kept and how to resume it even when verbose is off, the generated name of a
+session is otherwise never shown
+*/
+func announceSession(sessionName, path string) {
+	log.Printf("session %s keeps its state in %s, resume it with: huan fetch --resume %s", sessionName, path, sessionName)
+}
+
+/*
+pageKey
+
+the key of a page of a url
+*/
+func pageKey(url string, page int) string {
+	return fmt.Sprintf("%s page %d", url, page)
+}
+
+/*
+readLog
+
+calls read with every complete line of a log and returns the size of the log up to the last complete line. a line cut
+off by a crash ends the log
+*/
+func readLog(path string, read func(line []byte) error) (error, int64) {
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0
+	}
+
+	if err != nil {
+		return err, 0
+	}
+
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReaderSize(file, 1<<16)
+	var size int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		if errors.Is(err, io.EOF) {
+			// anything after the last new line was not written completely
+			return nil, size
+		}
+
+		if err != nil {
+			return err, size
+		}
+
+		if len(line) > maxLogLine {
+			return fmt.Errorf("%s has a line longer than %d bytes", path, maxLogLine), size
+		}
+
+		if err = read(line); err != nil {
+			// a line that cannot be parsed was cut off and every line after it is ignored
+			return nil, size
+		}
+
+		size += int64(len(line))
+	}
+}
+
+/*
+openLog
+
+opens a log for appending, anything after its last complete line is removed
+*/
+func openLog(path string, size int64) (error, *os.File) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if err = file.Truncate(size); err != nil {
+		_ = file.Close()
+		return err, nil
+	}
+
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err, nil
+	}
+
+	return nil, file
+}
+
+/*
+openCheckpoint
+
+opens the state of a session. resuming loads the finished work of the previous run, otherwise any earlier state of
+the session is removed
+*/
+func openCheckpoint(dir string, resume bool, logger func(message string)) (error, *checkpoint) {
+	if resume {
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("the session cannot be resumed, no state was found at %s", dir), nil
+		}
+	} else if err := os.RemoveAll(dir); err != nil {
+		return err, nil
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err, nil
+	}
+
+	c := &checkpoint{
+		logger: logger,
+		pages:  map[string]struct{}{},
+		chunks: map[string][]map[string]interface{}{},
+		urls:   map[string][]string{},
+	}
+
+	samplesPath := filepath.Join(dir, samplesLog)
+	err, samplesSize := readLog(samplesPath, func(line []byte) error {
+		var entry pageEntry
+
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+
+		c.pages[pageKey(entry.Url, entry.Page)] = struct{}{}
+		c.resumed = append(c.resumed, entry.Samples...)
+		return nil
+	})
+
+	if err != nil {
+		return err, nil
+	}
+
+	progressPath := filepath.Join(dir, progressLog)
+	err, progressSize := readLog(progressPath, func(line []byte) error {
+		var entry progressEntry
+
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+
+		if entry.Chunk != "" {
+			c.chunks[entry.Chunk] = entry.Records
+		}
+
+		if entry.Url != "" {
+			c.urls[entry.Url] = entry.Links
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err, nil
+	}
+
+	if err, c.samples = openLog(samplesPath, samplesSize); err != nil {
+		return err, nil
+	}
+
+	if err, c.progress = openLog(progressPath, progressSize); err != nil {
+		_ = c.samples.Close()
+		return err, nil
+	}
+
+	if resume {
+		logger(fmt.Sprintf(
+			"resuming with %d samples, %d finished urls and %d finished chunks",
+			len(c.resumed),
+			len(c.urls),
+			len(c.chunks)))
+	}
+
+	return nil, c
+}
+
+/*
+append
+
+writes a line to a log, failures are logged as the session can continue without its state
+*/
+func (c *checkpoint) append(file *os.File, entry interface{}) {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		c.logger(fmt.Sprintf("failed to save the session state: %v", err))
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the line is written at once so a crash can only cut off the last line
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		c.logger(fmt.Sprintf("failed to save the session state: %v", err))
+	}
+}
+
+/*
+resumedSamples
+
+the samples collected before the session was resumed
+*/
+func (c *checkpoint) resumedSamples() []map[string]interface{} {
+	if c == nil {
+		return nil
+	}
+
+	return c.resumed
+}
+
+/*
+pageDone
+
+whether every sample of a page was collected before the session was resumed
+*/
+func (c *checkpoint) pageDone(url string, page int) bool {
+	if c == nil {
+		return false
+	}
+
+	_, ok := c.pages[pageKey(url, page)]
+	return ok
+}
+
+/*
+finishPage
+
+saves the samples of a page marking it as finished
+*/
+func (c *checkpoint) finishPage(url string, page int, samples []map[string]interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.append(c.samples, pageEntry{Url: url, Page: page, Samples: samples})
+}
+
+/*
+chunkDone
+
+the records of a chunk that was finished before the session was resumed
+*/
+func (c *checkpoint) chunkDone(label string) ([]*record, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	samples, ok := c.chunks[label]
+
+	if !ok {
+		return nil, false
+	}
+
+	records := make([]*record, len(samples))
+
+	for index, sample := range samples {
+		records[index] = fromSample(sample)
+	}
+
+	return records, true
+}
+
+/*
+finishChunk
+
+saves the records of a chunk marking it as finished
+*/
+func (c *checkpoint) finishChunk(label string, records []*record) {
+	if c == nil {
+		return
+	}
+
+	c.append(c.progress, progressEntry{Chunk: label, Records: toSamples(records)})
+}
+
+/*
+urlDone
+
+whether every page of a url was finished before the session was resumed along with the links found on it
+*/
+func (c *checkpoint) urlDone(url string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	links, ok := c.urls[url]
+	return links, ok
+}
+
+/*
+finishUrl
+
+marks a url as finished, the links found on it are kept so they can be crawled after resuming
+*/
+func (c *checkpoint) finishUrl(url string, links []string) {
+	if c == nil {
+		return
+	}
+
+	c.append(c.progress, progressEntry{Url: url, Links: links})
+}
+
+/*
+close
+
+closes the logs of the session state
+*/
+func (c *checkpoint) close() error {
+	if c == nil {
+		return nil
+	}
+
+	return errors.Join(c.samples.Close(), c.progress.Close())
+}
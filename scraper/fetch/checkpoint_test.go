@@ -0,0 +1,164 @@
+package fetch
+
+import (
+	"context"
+	"huan/llm/messages"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_checkpoint_resume(t *testing.T) {
+	dir := stateDir(t.TempDir(), "huan")
+
+	err, progress := openCheckpoint(dir, false, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress.finishPage("https://huan.dev", 1, []map[string]interface{}{{"name": "huan"}, {"name": "beren"}})
+	progress.finishChunk("https://huan.dev page 2 chunk 1 of 2", newRecords([]map[string]interface{}{{"name": "luthien"}}))
+	progress.finishUrl("https://huan.dev/dogs", []string{"https://huan.dev/dogs/huan"})
+
+	if err = progress.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while a line is written leaves it cut off
+	file, _ := os.OpenFile(filepath.Join(dir, samplesLog), os.O_APPEND|os.O_WRONLY, 0666)
+	_, _ = file.WriteString(`{"url":"https://huan.dev","page":2,"samp`)
+	_ = file.Close()
+
+	err, progress = openCheckpoint(dir, true, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if samples := progress.resumedSamples(); len(samples) != 2 || samples[1]["name"] != "beren" {
+		t.Errorf("unexpected resumed samples %v", samples)
+	}
+
+	if !progress.pageDone("https://huan.dev", 1) || progress.pageDone("https://huan.dev", 2) {
+		t.Error("the finished pages were not restored")
+	}
+
+	records, ok := progress.chunkDone("https://huan.dev page 2 chunk 1 of 2")
+
+	if !ok || records[0].values["name"] != "luthien" {
+		t.Errorf("the finished chunk was not restored %v", records)
+	}
+
+	if links, ok := progress.urlDone("https://huan.dev/dogs"); !ok || len(links) != 1 {
+		t.Errorf("the finished url was not restored %v", links)
+	}
+
+	// the cut off line is removed so new lines stay readable
+	progress.finishPage("https://huan.dev", 2, []map[string]interface{}{{"name": "tevildo"}})
+	_ = progress.close()
+
+	err, progress = openCheckpoint(dir, true, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if samples := progress.resumedSamples(); len(samples) != 3 {
+		t.Errorf("expected 3 samples after resuming twice got %v", samples)
+	}
+
+	_ = progress.close()
+
+	// starting the session again without resuming forgets its state
+	err, progress = openCheckpoint(dir, false, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(progress.resumedSamples()) != 0 || progress.pageDone("https://huan.dev", 1) {
+		t.Error("the state of the previous run was kept")
+	}
+
+	_ = progress.close()
+}
+
+func Test_checkpoint_missing(t *testing.T) {
+	if err, _ := openCheckpoint(stateDir(t.TempDir(), "huan"), true, func(string) {}); err == nil {
+		t.Error("resuming a session without state did not fail")
+	}
+
+	var progress *checkpoint
+
+	if progress.pageDone("https://huan.dev", 1) || progress.close() != nil {
+		t.Error("a nil checkpoint should keep no state")
+	}
+
+	progress.finishPage("https://huan.dev", 1, nil)
+}
+
+func Test_fromSample(t *testing.T) {
+	rec := &record{
+		values:     map[string]interface{}{"name": "huan", "age": 3.0},
+		confidence: map[string]float64{"name": 0.9, "age": 0.2},
+		uncertain:  []string{"age"},
+		agreement:  map[string]float64{"name": 1},
+	}
+
+	dir := t.TempDir()
+	err, progress := openCheckpoint(dir, false, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress.finishChunk("chunk", []*record{rec})
+	_ = progress.close()
+
+	// the record is read back from disk so the metadata went through json
+	err, progress = openCheckpoint(dir, true, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = progress.close() }()
+
+	records, _ := progress.chunkDone("chunk")
+
+	if len(records) != 1 || !reflect.DeepEqual(records[0], rec) {
+		t.Errorf("expected %+v got %+v", rec, records[0])
+	}
+}
+
+func Test_promptPoolRecords_resumed(t *testing.T) {
+	err, progress := openCheckpoint(t.TempDir(), false, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = progress.close() }()
+
+	progress.chunks["https://huan.dev chunk 1 of 1"] = []map[string]interface{}{{"name": "huan"}}
+
+	// without a language model any request would fail so the records must come from the checkpoint
+	results := promptPoolRecords(
+		2,
+		nil,
+		context.Background(),
+		"https://huan.dev",
+		[]messages.Conversation{{}},
+		"",
+		nil,
+		nil,
+		nil,
+		progress,
+		func(string) {})
+
+	if len(results) != 1 || len(results[0]) != 1 || results[0][0].values["name"] != "huan" {
+		t.Errorf("the finished chunk was requested again %v", results)
+	}
+}
@@ -0,0 +1,267 @@
+package fetch
+
+import (
+	"golang.org/x/net/html"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+chunker
+
+packs the elements of a page into chunks of at most limit characters
+*/
+type chunker struct {
+	limit   int
+	overlap int // the most characters of a chunk repeated at the start of the next one
+	chunks  []string
+}
+
+/*
+chunkHtml
+
+splits the html of a page into chunks of at most limit characters. sibling elements are packed together and an
+element is only split when it does not fit in a chunk on its own, in which case its children are packed inside copies
+of its tags. overlap is the share of a chunk repeated at the start of the next one
+*/
+func chunkHtml(htmlData string, limit uint, overlap float64) []string {
+	if limit == 0 {
+		panic("limit cannot be 0")
+	}
+
+	document, err := html.Parse(strings.NewReader(htmlData))
+
+	// the parser recovers from malformed html so this only happens when reading fails
+	if err != nil {
+		return pieces(htmlData, int(limit))
+	}
+
+	c := &chunker{
+		limit:   int(limit),
+		overlap: int(float64(limit) * overlap),
+	}
+
+	body := findBody(document)
+
+	if body == nil {
+		body = document
+	}
+
+	c.pack(children(body), "", "")
+
+	return c.chunks
+}
+
+/*
+findBody
+
+the body element of a parsed document
+*/
+func findBody(node *html.Node) *html.Node {
+	if node.Type == html.ElementNode && node.Data == "body" {
+		return node
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if body := findBody(child); body != nil {
+			return body
+		}
+	}
+
+	return nil
+}
+
+/*
+children
+
+the child nodes of a node, comments are left out as they carry no data
+*/
+func children(node *html.Node) []*html.Node {
+	var nodes []*html.Node
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.CommentNode {
+			nodes = append(nodes, child)
+		}
+	}
+
+	return nodes
+}
+
+/*
+render
+
+the html of a node and its children
+*/
+func render(node *html.Node) string {
+	var builder strings.Builder
+
+	if err := html.Render(&builder, node); err != nil {
+		return ""
+	}
+
+	return builder.String()
+}
+
+/*
+tags
+
+the start and end tags of an element without its children
+*/
+func tags(node *html.Node) (string, string) {
+	shallow := &html.Node{
+		Type:      node.Type,
+		DataAtom:  node.DataAtom,
+		Data:      node.Data,
+		Namespace: node.Namespace,
+		Attr:      node.Attr,
+	}
+
+	rendered := render(shallow)
+	end := "</" + node.Data + ">"
+
+	return strings.TrimSuffix(rendered, end), end
+}
+
+/*
+pieces
+
+cuts text into pieces of at most limit characters, it is only used for text that cannot be split any other way
+*/
+func pieces(text string, limit int) []string {
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+
+	split := splitStringByLen(&text, uint(limit))
+	parts := make([]string, len(split))
+
+	for index, part := range split {
+		parts[index] = *part
+	}
+
+	return parts
+}
+
+/*
+add
+
+adds a chunk unless it has nothing but white space
+*/
+func (c *chunker) add(chunk string) {
+	if strings.TrimSpace(chunk) != "" {
+		c.chunks = append(c.chunks, chunk)
+	}
+}
+
+/*
+pack
+
+packs sibling nodes into chunks, every chunk is wrapped in the tags of the elements the nodes are nested in
+*/
+func (c *chunker) pack(nodes []*html.Node, open, close string) {
+	budget := c.limit - utf8.RuneCountInString(open) - utf8.RuneCountInString(close)
+
+	// the tags of the parent elements are left out when they leave no room for their children
+	if budget < c.limit/2 {
+		open, close, budget = "", "", c.limit
+	}
+
+	var current []string
+	size, fresh := 0, 0
+
+	flush := func() {
+		// a chunk holding nothing but the overlap of the previous one is not needed
+		if fresh == 0 {
+			return
+		}
+
+		c.add(open + strings.Join(current, "") + close)
+
+		// the last siblings of the chunk that fit in the overlap start the next one
+		keep, kept := len(current), 0
+		for keep > 0 && kept+utf8.RuneCountInString(current[keep-1]) <= c.overlap {
+			keep--
+			kept += utf8.RuneCountInString(current[keep])
+		}
+
+		current = append([]string(nil), current[keep:]...)
+		size, fresh = kept, 0
+	}
+
+	for _, node := range nodes {
+		rendered := render(node)
+		length := utf8.RuneCountInString(rendered)
+
+		if length > budget {
+			flush()
+			current, size = nil, 0
+			c.split(node, rendered, open, close, budget)
+			continue
+		}
+
+		if size+length > budget {
+			flush()
+
+			if size+length > budget {
+				current, size = nil, 0
+			}
+		}
+
+		current = append(current, rendered)
+		size += length
+		fresh++
+	}
+
+	flush()
+}
+
+/*
+split
+
+splits a node that does not fit in a chunk on its own. the children of an element are packed inside copies of its
+tags while text and elements without children are cut by length
+*/
+func (c *chunker) split(node *html.Node, rendered, open, close string, budget int) {
+	if node.Type == html.ElementNode && node.FirstChild != nil {
+		start, end := tags(node)
+		c.pack(children(node), open+start, end+close)
+		return
+	}
+
+	for _, piece := range pieces(rendered, budget) {
+		c.add(open + piece + close)
+	}
+}
+
+/*
+dropOverlap
+
+flattens the records of consecutive chunks, a record repeated by the overlap between two chunks is only kept once.
+records are only compared with those of the previous chunk, so identical records found in the same chunk are all kept
+*/
+func dropOverlap(groups [][]*record) []*record {
+	var kept []*record
+	previous := map[string]int{}
+
+	for _, group := range groups {
+		current := map[string]int{}
+
+		for _, rec := range group {
+			key := valueKey(rec.values)
+			current[key]++
+
+			// every copy in the previous chunk accounts for one copy in this chunk
+			if previous[key] > 0 {
+				previous[key]--
+				continue
+			}
+
+			kept = append(kept, rec)
+		}
+
+		previous = current
+	}
+
+	return kept
+}
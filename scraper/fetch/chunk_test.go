@@ -0,0 +1,143 @@
+package fetch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func listing(count int) string {
+	var builder strings.Builder
+
+	builder.WriteString("<html><head><title>dogs</title></head><body><ul class=\"dogs\">")
+
+	for index := range count {
+		builder.WriteString(fmt.Sprintf("<li><b>dog %03d</b><i>good boy</i></li>", index))
+	}
+
+	builder.WriteString("</ul></body></html>")
+	return builder.String()
+}
+
+func Test_chunkHtml(t *testing.T) {
+	t.Run("short page", func(t *testing.T) {
+		chunks := chunkHtml("<p>huan</p><p>beren</p>", 1000, 0)
+
+		if len(chunks) != 1 || chunks[0] != "<p>huan</p><p>beren</p>" {
+			t.Errorf("expected a single chunk got %q", chunks)
+		}
+	})
+
+	t.Run("siblings are kept whole", func(t *testing.T) {
+		chunks := chunkHtml(listing(100), 500, 0)
+
+		if len(chunks) < 2 {
+			t.Fatalf("expected the listing to be split got %d chunks", len(chunks))
+		}
+
+		items := 0
+
+		for _, chunk := range chunks {
+			if length := utf8.RuneCountInString(chunk); length > 500 {
+				t.Errorf("a chunk of %d characters is over the limit", length)
+			}
+
+			// every chunk is wrapped in the list so the items keep their parent
+			if !strings.HasPrefix(chunk, `<ul class="dogs"><li>`) || !strings.HasSuffix(chunk, "</li></ul>") {
+				t.Errorf("the chunk is not wrapped in the list %q", chunk)
+			}
+
+			if strings.Count(chunk, "<li>") != strings.Count(chunk, "</li>") {
+				t.Errorf("an item was split %q", chunk)
+			}
+
+			items += strings.Count(chunk, "<li>")
+		}
+
+		if items != 100 {
+			t.Errorf("expected 100 items got %d", items)
+		}
+	})
+
+	t.Run("oversized text is cut", func(t *testing.T) {
+		text := strings.Repeat("huan ", 100)
+		chunks := chunkHtml("<p>"+text+"</p>", 100, 0)
+
+		if len(chunks) != 6 {
+			t.Fatalf("expected 6 chunks got %d", len(chunks))
+		}
+
+		var joined strings.Builder
+
+		for _, chunk := range chunks {
+			if length := utf8.RuneCountInString(chunk); length > 100 {
+				t.Errorf("a chunk of %d characters is over the limit", length)
+			}
+
+			joined.WriteString(strings.TrimSuffix(strings.TrimPrefix(chunk, "<p>"), "</p>"))
+		}
+
+		if joined.String() != text {
+			t.Error("the text was not kept whole across the chunks")
+		}
+	})
+
+	t.Run("overlap repeats the last siblings", func(t *testing.T) {
+		chunks := chunkHtml(listing(100), 500, 0.2)
+
+		if len(chunks) < 2 {
+			t.Fatalf("expected the listing to be split got %d chunks", len(chunks))
+		}
+
+		for index := 1; index < len(chunks); index++ {
+			previous := strings.Split(chunks[index-1], "<li>")
+			last := "<li>" + strings.TrimSuffix(previous[len(previous)-1], "</ul>")
+
+			if !strings.Contains(chunks[index], last) {
+				t.Errorf("chunk %d does not repeat the last item of the previous chunk", index)
+			}
+
+			if length := utf8.RuneCountInString(chunks[index]); length > 500 {
+				t.Errorf("a chunk of %d characters is over the limit", length)
+			}
+		}
+
+		if without := chunkHtml(listing(100), 500, 0); len(chunks) <= len(without) {
+			t.Errorf("expected the overlap to add chunks got %d with and %d without", len(chunks), len(without))
+		}
+	})
+
+	t.Run("comments are dropped", func(t *testing.T) {
+		chunks := chunkHtml("<p>huan</p><!-- beren -->", 1000, 0)
+
+		if len(chunks) != 1 || strings.Contains(chunks[0], "beren") {
+			t.Errorf("expected the comment to be dropped got %q", chunks)
+		}
+	})
+}
+
+func Test_dropOverlap(t *testing.T) {
+	huan := map[string]interface{}{"name": "huan"}
+	beren := map[string]interface{}{"name": "beren"}
+	luthien := map[string]interface{}{"name": "luthien"}
+
+	groups := [][]*record{
+		newRecords([]map[string]interface{}{huan, beren, beren}),
+		// beren was repeated by the overlap
+		newRecords([]map[string]interface{}{beren, luthien}),
+		// huan is not in the previous chunk so it is a new record
+		newRecords([]map[string]interface{}{huan, luthien, luthien}),
+	}
+
+	kept := toSamples(dropOverlap(groups))
+	names := make([]string, len(kept))
+
+	for index, sample := range kept {
+		names[index] = sample["name"].(string)
+	}
+
+	if expected := "huan beren beren luthien huan luthien"; strings.Join(names, " ") != expected {
+		t.Errorf("expected %s got %s", expected, strings.Join(names, " "))
+	}
+}
@@ -0,0 +1,134 @@
+package fetch
+
+import (
+	"huan/jsonparser"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"math"
+	"reflect"
+	"sort"
+)
+
+/*
+tokenLength
+
+the bytes a token takes up in the response, a token holding part of a multibyte character is not valid utf-8 so its
+text cannot be measured
+*/
+func tokenLength(token messages.FullLogprobContent) int {
+	if token.Bytes != nil {
+		return len(*token.Bytes)
+	}
+
+	return len(token.Token)
+}
+
+/*
+tokenSpans
+
+maps every generated token to the byte range of the response it produced
+*/
+func tokenSpans(tokens []messages.FullLogprobContent) []jsonparser.Span {
+	spans := make([]jsonparser.Span, len(tokens))
+
+	var offset int
+	for index, token := range tokens {
+		length := tokenLength(token)
+		spans[index] = jsonparser.Span{Start: offset, End: offset + length}
+		offset += length
+	}
+
+	return spans
+}
+
+/*
+spanConfidence
+
+the geometric mean probability of every token overlapping the span, ok is false when no token overlaps it. an empty
+string is scored by the token holding its closing quote, that is where the llm decided the value was empty
+*/
+func spanConfidence(
+	tokens []messages.FullLogprobContent,
+	spans []jsonparser.Span,
+	span jsonparser.Span) (float64, bool) {
+
+	if span.Start == span.End {
+		span.End++
+	}
+
+	var total float64
+	var count int
+
+	for index, tokenSpan := range spans {
+		if tokenSpan.Overlaps(span) {
+			total += tokens[index].Logprob
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	return math.Exp(total / float64(count)), true
+}
+
+/*
+scoreRecords
+
+attaches a confidence to each field of the records, using the log probabilities of the response they were parsed
+from. records are matched to their position in the response by value
+*/
+func scoreRecords(response string, logprobs *messages.Logprobs, records []*record) {
+	located := jsonparser.Locate(response)
+	spans := tokenSpans(logprobs.Content)
+	used := make([]bool, len(located))
+
+	for _, rec := range records {
+		for index, loc := range located {
+			if used[index] || !reflect.DeepEqual(loc.Sample, rec.values) {
+				continue
+			}
+
+			used[index] = true
+			rec.confidence = map[string]float64{}
+
+			for field, span := range loc.Fields {
+				if score, ok := spanConfidence(logprobs.Content, spans, span); ok {
+					rec.confidence[field] = score
+				}
+			}
+
+			break
+		}
+	}
+}
+
+/*
+applyConfidence
+
+flags or drops every value with a confidence below the threshold
+*/
+func applyConfidence(records []*record, conf *scraper2.Confidence) {
+	for _, rec := range records {
+		var uncertain []string
+
+		for field, score := range rec.confidence {
+			if score < conf.Threshold {
+				uncertain = append(uncertain, field)
+			}
+		}
+
+		sort.Strings(uncertain)
+
+		if conf.Action == "drop" {
+			for _, field := range uncertain {
+				delete(rec.values, field)
+				delete(rec.confidence, field)
+			}
+			continue
+		}
+
+		rec.uncertain = uncertain
+	}
+}
@@ -0,0 +1,93 @@
+package fetch
+
+import (
+	"huan/jsonparser"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"math"
+	"testing"
+)
+
+func tokenize(tokens []string, logprobs []float64) *messages.Logprobs {
+	content := make([]messages.FullLogprobContent, len(tokens))
+
+	for index, token := range tokens {
+		content[index].Token = token
+		content[index].Logprob = logprobs[index]
+	}
+
+	return &messages.Logprobs{Content: content}
+}
+
+func Test_scoreRecords(t *testing.T) {
+	tokens := []string{`[{"`, `name`, `":"`, `hu`, `an`, `","`, `age`, `":`, `3`, `}]`}
+	logprobs := []float64{0, 0, 0, math.Log(0.9), math.Log(0.4), 0, 0, 0, math.Log(0.2), 0}
+
+	response := ""
+	for _, token := range tokens {
+		response += token
+	}
+
+	records := newRecords(jsonparser.ToJson(response))
+	scoreRecords(response, tokenize(tokens, logprobs), records)
+
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record got %d", len(records))
+	}
+
+	name := records[0].confidence["name"]
+	if math.Abs(name-math.Sqrt(0.9*0.4)) > 1e-9 {
+		t.Errorf("expected name confidence of %f got %f", math.Sqrt(0.9*0.4), name)
+	}
+
+	age := records[0].confidence["age"]
+	if math.Abs(age-0.2) > 1e-9 {
+		t.Errorf("expected age confidence of 0.2 got %f", age)
+	}
+
+	t.Run("empty string", func(t *testing.T) {
+		tokens := []string{`[{"`, `name`, `":"`, `",`, `"age`, `":`, `3`, `}]`}
+		logprobs := []float64{0, 0, math.Log(0.9), math.Log(0.3), 0, 0, 0, 0}
+
+		response := ""
+		for _, token := range tokens {
+			response += token
+		}
+
+		empty := newRecords(jsonparser.ToJson(response))
+		scoreRecords(response, tokenize(tokens, logprobs), empty)
+
+		// the opening quote belongs to the key separator, only the closing quote decides the value
+		if score, ok := empty[0].confidence["name"]; !ok || math.Abs(score-0.3) > 1e-9 {
+			t.Errorf("expected the empty name to be scored by its closing quote got %f", score)
+		}
+	})
+
+	t.Run("flag low confidence", func(t *testing.T) {
+		applyConfidence(records, &scraper2.Confidence{Threshold: 0.5, Action: "flag"})
+		sample := records[0].toSample()
+
+		if _, ok := sample["age"]; !ok {
+			t.Error("flagged value was removed")
+		}
+
+		flagged, ok := sample[lowConfidenceKey].([]string)
+		if !ok || len(flagged) != 1 || flagged[0] != "age" {
+			t.Errorf("expected age to be flagged got %v", sample[lowConfidenceKey])
+		}
+	})
+
+	t.Run("drop low confidence", func(t *testing.T) {
+		records[0].uncertain = nil
+		applyConfidence(records, &scraper2.Confidence{Threshold: 0.5, Action: "drop"})
+		sample := records[0].toSample()
+
+		if _, ok := sample["age"]; ok {
+			t.Error("low confidence value was not dropped")
+		}
+
+		if _, ok := sample["name"]; !ok {
+			t.Error("confident value was dropped")
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"huan/llm/messages"
+	"huan/llm/model"
+	scraper2 "huan/scraper"
+	"sort"
+)
+
+/*
+sampleChoices
+
+requests every completion needed for a chunk, either as n choices of a single request or as one request per
+temperature made in order. failed temperature requests are skipped as long as one succeeds
+*/
+func sampleChoices(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	convo *messages.Conversation,
+	consistency *scraper2.Consistency) (error, []messages.Choice) {
+
+	if consistency == nil || consistency.Strategy == "n" {
+		var opts *model.Options
+
+		if consistency != nil {
+			n := int(consistency.Samples)
+			opts = &model.Options{N: &n}
+		}
+
+		err, completion := llm.Complete(ctx, convo, opts)
+
+		if err != nil {
+			return err, nil
+		}
+
+		return nil, completion.Choices
+	}
+
+	// the temperatures are requested one after another so a chunk never makes more than one request at a time and
+	// the llm workers stay the limit on concurrent requests
+	var choices []messages.Choice
+	var errs []error
+
+	for _, temperature := range consistency.Temperatures {
+		err, completion := llm.Complete(ctx, convo, &model.Options{Temperature: &temperature})
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		choices = append(choices, completion.Choices[0])
+	}
+
+	if len(choices) == 0 {
+		return errors.Join(errs...), nil
+	}
+
+	return nil, choices
+}
+
+/*
+valueKey
+
+a comparable representation of a json value, map keys are sorted so equal values always share a key
+*/
+func valueKey(value interface{}) string {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+/*
+similarity
+
+how many fields two records agree on, along with how many distinct fields they have between them
+*/
+func similarity(a, b *record) (int, int) {
+	var agree int
+	fields := len(a.values)
+
+	for field, value := range b.values {
+		other, ok := a.values[field]
+
+		if !ok {
+			fields++
+			continue
+		}
+
+		if valueKey(other) == valueKey(value) {
+			agree++
+		}
+	}
+
+	return agree, fields
+}
+
+/*
+cluster
+
+records from different completions that describe the same sample
+*/
+type cluster struct {
+	members []*record
+	sources map[int]struct{}
+}
+
+/*
+alignRecords
+
+groups the records of every completion into clusters, a record joins the cluster it agrees with on at least half
+of its fields. a cluster never holds two records from the same completion
+*/
+func alignRecords(completions [][]*record) []*cluster {
+	var clusters []*cluster
+
+	for source, records := range completions {
+		for _, rec := range records {
+			var best *cluster
+			var bestScore int
+
+			for _, c := range clusters {
+				if _, ok := c.sources[source]; ok {
+					continue
+				}
+
+				for _, member := range c.members {
+					agree, fields := similarity(member, rec)
+
+					if agree > bestScore && agree*2 >= fields {
+						best = c
+						bestScore = agree
+					}
+				}
+			}
+
+			if best == nil {
+				best = &cluster{sources: map[int]struct{}{}}
+				clusters = append(clusters, best)
+			}
+
+			best.members = append(best.members, rec)
+			best.sources[source] = struct{}{}
+		}
+	}
+
+	return clusters
+}
+
+/*
+vote
+
+merges a cluster into a single record, each field keeps the value most completions agreed on. fields whose winning
+value falls under the minimum agreement are dropped, confidence is averaged over the records that voted for the
+winning value
+*/
+func (c *cluster) vote(total int, minAgreement float64) *record {
+	fieldSet := map[string]struct{}{}
+
+	for _, member := range c.members {
+		for field := range member.values {
+			fieldSet[field] = struct{}{}
+		}
+	}
+
+	fields := make([]string, 0, len(fieldSet))
+	for field := range fieldSet {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	merged := &record{
+		values:    map[string]interface{}{},
+		agreement: map[string]float64{},
+	}
+
+	for _, field := range fields {
+		counts := map[string]int{}
+		var winner string
+
+		for _, member := range c.members {
+			value, ok := member.values[field]
+
+			if !ok {
+				continue
+			}
+
+			key := valueKey(value)
+			counts[key]++
+
+			if counts[key] > counts[winner] {
+				winner = key
+			}
+		}
+
+		ratio := float64(counts[winner]) / float64(total)
+
+		if ratio < minAgreement {
+			continue
+		}
+
+		var confidence float64
+		var scored int
+
+		for _, member := range c.members {
+			value, ok := member.values[field]
+
+			if !ok || valueKey(value) != winner {
+				continue
+			}
+
+			if _, ok := merged.values[field]; !ok {
+				merged.values[field] = value
+			}
+
+			if score, ok := member.confidence[field]; ok {
+				confidence += score
+				scored++
+			}
+		}
+
+		merged.agreement[field] = ratio
+
+		if scored > 0 {
+			if merged.confidence == nil {
+				merged.confidence = map[string]float64{}
+			}
+
+			merged.confidence[field] = confidence / float64(scored)
+		}
+	}
+
+	return merged
+}
+
+/*
+voteRecords
+
+aligns the records of every completion and keeps the samples that enough completions agree on
+*/
+func voteRecords(completions [][]*record, minAgreement float64) []*record {
+	var records []*record
+
+	for _, c := range alignRecords(completions) {
+		if float64(len(c.members))/float64(len(completions)) < minAgreement {
+			continue
+		}
+
+		merged := c.vote(len(completions), minAgreement)
+
+		if len(merged.values) > 0 {
+			records = append(records, merged)
+		}
+	}
+
+	return records
+}
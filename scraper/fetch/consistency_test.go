@@ -0,0 +1,150 @@
+package fetch
+
+import (
+	"context"
+	"huan/llm/messages"
+	"huan/llm/model/standin"
+	scraper2 "huan/scraper"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_voteRecords(t *testing.T) {
+	completions := [][]*record{
+		newRecords([]map[string]interface{}{
+			{"name": "huan", "kind": "hound", "home": "valinor"},
+			{"name": "beren", "kind": "man"},
+		}),
+		newRecords([]map[string]interface{}{
+			{"name": "beren", "kind": "man"},
+			{"name": "huan", "kind": "hound", "home": "doriath"},
+		}),
+		newRecords([]map[string]interface{}{
+			{"name": "huan", "kind": "hound", "home": "valinor"},
+			{"name": "carcharoth", "kind": "wolf"},
+		}),
+	}
+
+	records := voteRecords(completions, 0.5)
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records got %d", len(records))
+	}
+
+	huan := records[0]
+
+	if huan.values["home"] != "valinor" {
+		t.Errorf("expected the majority value valinor got %v", huan.values["home"])
+	}
+
+	if ratio := huan.agreement["home"]; ratio < 0.66 || ratio > 0.67 {
+		t.Errorf("expected an agreement of 2/3 got %f", ratio)
+	}
+
+	if huan.agreement["name"] != 1 {
+		t.Errorf("expected full agreement on name got %f", huan.agreement["name"])
+	}
+
+	beren := records[1]
+
+	if beren.values["name"] != "beren" {
+		t.Errorf("expected beren got %v", beren.values["name"])
+	}
+
+	t.Run("minority values are dropped", func(t *testing.T) {
+		strict := voteRecords(completions, 1)
+
+		if len(strict) != 1 {
+			t.Fatalf("expected 1 record got %d", len(strict))
+		}
+
+		if _, ok := strict[0].values["home"]; ok {
+			t.Error("disputed value was kept")
+		}
+	})
+}
+
+func Test_extractRecords(t *testing.T) {
+	tem := scraper2.TestModel{
+		WorkTime: time.Duration(0),
+		Template: map[string]interface{}{
+			"content": "123",
+		},
+		Quantity: 2,
+	}
+
+	llm := scraper2.GetTestLanguageModel(tem)
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect"})
+	_, convo := builder.Build()
+
+	consistencies := []*scraper2.Consistency{
+		{Samples: 3, Strategy: "n", MinAgreement: 0.5},
+		{Samples: 2, Strategy: "temperature", Temperatures: []float32{0.2, 1}, MinAgreement: 0.5},
+	}
+
+	for _, consistency := range consistencies {
+		t.Run(consistency.Strategy, func(t *testing.T) {
+			err, records := extractRecords(context.Background(), &llm, &convo, "", "", nil, consistency, nil, func(string) {})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(records) != 2 {
+				t.Fatalf("expected 2 records got %d", len(records))
+			}
+
+			if records[0].agreement["content"] != 1 {
+				t.Errorf("expected full agreement got %f", records[0].agreement["content"])
+			}
+		})
+	}
+}
+
+func Test_sampleChoices_temperatures(t *testing.T) {
+	var active, peak atomic.Int64
+
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		now := active.Add(1)
+		defer active.Add(-1)
+
+		for old := peak.Load(); now > old && !peak.CompareAndSwap(old, now); old = peak.Load() {
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		return `[{"name": "huan"}]`
+	})
+	defer server.Close()
+
+	err, llm := scraper2.InitLanguageModel(
+		"openai",
+		map[string]interface{}{"apiKey": "test", "model": "gpt-4o", "baseUrl": server.URL},
+		nil, nil, nil, false, nil, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect"})
+	_, convo := builder.Build()
+
+	consistency := &scraper2.Consistency{Strategy: "temperature", Temperatures: []float32{0, 0.5, 1, 1.5}}
+	err, choices := sampleChoices(context.Background(), llm, &convo, consistency)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(choices) != 4 || server.Requests() != 4 {
+		t.Errorf("expected a choice per temperature got %d choices from %d requests", len(choices), server.Requests())
+	}
+
+	// the llm workers limit the requests of a session so a chunk only makes one at a time
+	if peak.Load() != 1 {
+		t.Errorf("a chunk made %d requests at once", peak.Load())
+	}
+}
@@ -0,0 +1,188 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"strings"
+)
+
+const (
+	maxContinuations = 3 // how many times a response cut off by the token limit is continued
+	minOverlap       = 8 // the shortest repeated text removed when stitching a continuation
+	continuePrompt   = "your response was cut off by the token limit, continue it exactly where it ended. do not repeat " +
+		"anything you already wrote and do not start a new array"
+)
+
+/*
+overlapLength
+
+the length of the longest end of partial the continuation starts with, continuations often repeat the last few
+characters they were shown. overlaps shorter than minOverlap are ignored as they are likely coincidental
+*/
+func overlapLength(partial, continuation string) int {
+	for length := min(len(partial), len(continuation)); length >= minOverlap; length-- {
+		if strings.HasSuffix(partial, continuation[:length]) {
+			return length
+		}
+	}
+
+	return 0
+}
+
+/*
+stitchLogprobs
+
+joins the log probabilities of a continuation onto those of the partial response, the tokens covering the repeated
+text are dropped. nil is returned when the repeated text does not end on a token boundary, as the tokens would no
+longer line up with the stitched response
+*/
+func stitchLogprobs(partial, continuation *messages.Logprobs, overlap int) *messages.Logprobs {
+	if partial == nil || continuation == nil {
+		return nil
+	}
+
+	var skipped, index int
+
+	for index < len(continuation.Content) && skipped < overlap {
+		skipped += tokenLength(continuation.Content[index])
+		index++
+	}
+
+	if skipped != overlap {
+		return nil
+	}
+
+	content := make([]messages.FullLogprobContent, 0, len(partial.Content)+len(continuation.Content)-index)
+	content = append(content, partial.Content...)
+	content = append(content, continuation.Content[index:]...)
+
+	return &messages.Logprobs{Content: content}
+}
+
+/*
+stitchChoice
+
+appends a continuation onto a choice that was cut off, the finish reason of the continuation is kept
+*/
+func stitchChoice(partial, continuation messages.Choice) messages.Choice {
+	var before, after string
+
+	if partial.Message.Content != nil {
+		before = *partial.Message.Content
+	}
+
+	if continuation.Message.Content != nil {
+		after = *continuation.Message.Content
+	}
+
+	overlap := overlapLength(before, after)
+	content := before + after[overlap:]
+
+	partial.Message.Content = &content
+	partial.FinishReason = continuation.FinishReason
+	partial.Logprobs = stitchLogprobs(partial.Logprobs, continuation.Logprobs, overlap)
+
+	return partial
+}
+
+/*
+continueChoice
+
+completes a choice that was cut off by the token limit. the partial response is sent back as an assistant turn
+followed by a request to continue, and the outputs are stitched together until the response finishes or
+maxContinuations is reached. the partial choice is returned along with any error
+*/
+func continueChoice(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	convo messages.Conversation,
+	choice messages.Choice,
+	logger func(message string)) (error, messages.Choice) {
+
+	for attempt := 1; choice.FinishReason == "length" && attempt <= maxContinuations; attempt++ {
+		if choice.Message.Content == nil || *choice.Message.Content == "" {
+			return nil, choice
+		}
+
+		err, builder := messages.NewConversationBuilder(convo)
+
+		if err != nil {
+			return err, choice
+		}
+
+		// built directly as ToAssistant would send an empty list of tool calls along with the content
+		builder.AddAssistantMessage(&messages.AssistantMessage{
+			Role:    "assistant",
+			Content: choice.Message.Content,
+		})
+
+		builder.AddStandardMessage(&messages.StandardMessage{
+			Role:    "user",
+			Content: continuePrompt,
+		})
+
+		// the chunk prompt and the response are needed to continue, so nothing is truncated
+		if err = llm.CheckBudget(builder); err != nil {
+			return err, choice
+		}
+
+		if err = llm.Validate(builder); err != nil {
+			return err, choice
+		}
+
+		err, continuation := builder.Build()
+
+		if err != nil {
+			return err, choice
+		}
+
+		logger(fmt.Sprintf("response was cut off by the token limit, requesting continuation %d", attempt))
+
+		err, completion := llm.Complete(ctx, &continuation, nil)
+
+		if err != nil {
+			return err, choice
+		}
+
+		if len(completion.Choices) == 0 {
+			return fmt.Errorf("continuation %d returned no choices", attempt), choice
+		}
+
+		choice = stitchChoice(choice, completion.Choices[0])
+	}
+
+	if choice.FinishReason == "length" {
+		logger(fmt.Sprintf("response was still cut off after %d continuations", maxContinuations))
+	}
+
+	return nil, choice
+}
+
+/*
+continueChoices
+
+continues every choice that was cut off by the token limit, a choice that fails to continue keeps what was generated
+*/
+func continueChoices(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	convo messages.Conversation,
+	choices []messages.Choice,
+	logger func(message string)) {
+
+	for index, choice := range choices {
+		if choice.FinishReason != "length" {
+			continue
+		}
+
+		err, continued := continueChoice(ctx, llm, convo, choice, logger)
+
+		if err != nil {
+			logger(fmt.Sprintf("failed to continue a response cut off by the token limit: %v", err))
+		}
+
+		choices[index] = continued
+	}
+}
@@ -0,0 +1,182 @@
+package fetch
+
+import (
+	"context"
+	"huan/llm/messages"
+	"huan/llm/model/standin"
+	scraper2 "huan/scraper"
+	"strings"
+	"testing"
+)
+
+func Test_overlapLength(t *testing.T) {
+	tests := []struct {
+		name         string
+		partial      string
+		continuation string
+		want         int
+	}{
+		{"no overlap", `[{"name": "hu`, `an"}]`, 0},
+		{"repeated end", `[{"name": "huan"}, {"na`, `"huan"}, {"name": "beren"}]`, 13},
+		{"too short to trust", `[{"id": 1}, {"id": 1`, `1}]`, 0},
+		{"empty", "", `[]`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overlapLength(tt.partial, tt.continuation); got != tt.want {
+				t.Errorf("overlapLength() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func logprobsOf(tokens ...string) *messages.Logprobs {
+	logprobs := &messages.Logprobs{}
+
+	for _, token := range tokens {
+		logprobs.Content = append(logprobs.Content, messages.FullLogprobContent{
+			LogprobContent: messages.LogprobContent{Token: token},
+		})
+	}
+
+	return logprobs
+}
+
+func Test_stitchChoice(t *testing.T) {
+	before, after := `[{"name": "huan"}, {"na`, `"huan"}, {"name": "beren"}]`
+
+	partial := messages.Choice{
+		FinishReason: "length",
+		Message:      messages.Message{Content: &before},
+		Logprobs:     logprobsOf(`[{"`, `name`, `": "`, `huan`, `"}, {"`, `na`),
+	}
+
+	continuation := messages.Choice{
+		FinishReason: "stop",
+		Message:      messages.Message{Content: &after},
+		Logprobs:     logprobsOf(`"`, `huan`, `"}, {"`, `na`, `me`, `": "`, `beren`, `"}]`),
+	}
+
+	stitched := stitchChoice(partial, continuation)
+
+	if *stitched.Message.Content != `[{"name": "huan"}, {"name": "beren"}]` {
+		t.Fatalf("unexpected stitched content %s", *stitched.Message.Content)
+	}
+
+	if stitched.FinishReason != "stop" {
+		t.Errorf("expected the finish reason of the continuation got %s", stitched.FinishReason)
+	}
+
+	var text string
+	for _, token := range stitched.Logprobs.Content {
+		text += token.Token
+	}
+
+	if text != *stitched.Message.Content {
+		t.Errorf("the stitched tokens do not line up with the content %s", text)
+	}
+
+	// the repeated text ends part way through a token
+	continuation.Logprobs = logprobsOf(`"hu`, `an"}, {"`, `name`, `": "`, `beren`, `"}]`)
+
+	if stitchChoice(partial, continuation).Logprobs != nil {
+		t.Error("misaligned log probabilities were kept")
+	}
+
+	// the é starting the repeated text is split over two tokens whose text is a replacement character each
+	before, after = `[{"name": "éléonore`, `éléonore"}]`
+	partial.Message.Content, continuation.Message.Content = &before, &after
+	partial.Logprobs = logprobsOf(`[{"`, `name`, `": "`, `éléonore`)
+	continuation.Logprobs = logprobsOf("\ufffd", "\ufffd", `léonore`, `"}]`)
+	continuation.Logprobs.Content[0].Bytes = &[]int32{0xc3}
+	continuation.Logprobs.Content[1].Bytes = &[]int32{0xa9}
+
+	stitched = stitchChoice(partial, continuation)
+
+	if stitched.Logprobs == nil || len(stitched.Logprobs.Content) != 5 {
+		t.Errorf("the tokens of a multibyte overlap were not stitched %v", stitched.Logprobs)
+	}
+}
+
+func Test_extractRecords_continuation(t *testing.T) {
+	full := `[{"name": "huan"}, {"name": "beren"}, {"name": "luthien"}]`
+
+	// every request continues from the end of the last assistant turn, the server cuts each one off at 20 characters
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		var written int
+
+		for _, mess := range body["messages"].([]interface{}) {
+			if m := mess.(map[string]interface{}); m["role"] == "assistant" {
+				written = len(m["content"].(string))
+			}
+		}
+
+		return full[written:]
+	})
+	defer server.Close()
+
+	maxTokens := uint16(5)
+	err, llm := scraper2.InitLanguageModel(
+		"openai",
+		map[string]interface{}{"apiKey": "test", "model": "gpt-4o", "baseUrl": server.URL},
+		nil, &maxTokens, nil, false, nil, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect names"})
+	_, convo := builder.Build()
+
+	err, records := extractRecords(context.Background(), llm, &convo, "test", "", nil, nil, nil, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 || records[2].values["name"] != "luthien" {
+		t.Errorf("the cut off samples were not recovered %v", toSamples(records))
+	}
+
+	if server.Requests() != 3 {
+		t.Errorf("expected 3 requests got %d", server.Requests())
+	}
+}
+
+func Test_continueChoice_budget(t *testing.T) {
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		return `[{"name": "huan"}]`
+	})
+	defer server.Close()
+
+	err, llm := scraper2.InitLanguageModel(
+		"openai",
+		map[string]interface{}{"apiKey": "test", "model": "gpt-4o", "baseUrl": server.URL},
+		nil, nil, nil, false, nil, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = llm.SetTruncation("dropOldest"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunk prompt alone is over the context window, truncation would leave only the request to continue
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: strings.Repeat("huan ", 200000)})
+	_, convo := builder.Build()
+
+	partial := `[{"name": "hu`
+	choice := messages.Choice{FinishReason: "length", Message: messages.Message{Content: &partial}}
+
+	if err, _ = continueChoice(context.Background(), llm, convo, choice, func(string) {}); err == nil {
+		t.Error("expected a continuation over the budget to fail")
+	}
+
+	if server.Requests() != 0 {
+		t.Errorf("expected no requests got %d", server.Requests())
+	}
+}
@@ -0,0 +1,360 @@
+package fetch
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huan/chrome"
+	"huan/jsonparser"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed prompts/links.txt
+var linksPrompt string
+
+const maxPickLinks = 300 // the most links the llm is asked to choose from at once
+
+var linksTemplate = template.Must(template.New("links").Option("missingkey=error").Parse(linksPrompt))
+
+// query parameters that only track where a visitor came from, they never change the page
+var trackingParams = []string{"fbclid", "gclid", "msclkid", "mc_cid", "mc_eid", "ref"}
+
+/*
+canonicalUrl
+
+normalises a url so different spellings of the same page share a key. relative urls are resolved against base, the
+scheme and host are lower cased, default ports, fragments, tracking parameters and trailing slashes are removed and
+the query parameters are sorted. only http and https urls are accepted
+*/
+func canonicalUrl(raw string, base *url.URL) (error, string) {
+	link, err := url.Parse(strings.TrimSpace(raw))
+
+	if err != nil {
+		return err, ""
+	}
+
+	if base != nil {
+		link = base.ResolveReference(link)
+	}
+
+	link.Scheme = strings.ToLower(link.Scheme)
+
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return fmt.Errorf("%s is not a http url", raw), ""
+	}
+
+	host := strings.ToLower(link.Hostname())
+
+	if host == "" {
+		return fmt.Errorf("%s has no host", raw), ""
+	}
+
+	port := link.Port()
+	defaultPort := (link.Scheme == "http" && port == "80") || (link.Scheme == "https" && port == "443")
+
+	if port != "" && !defaultPort {
+		host += ":" + port
+	}
+
+	link.Host = host
+	link.Fragment = ""
+	link.RawFragment = ""
+	link.User = nil
+
+	if link.Path != "/" {
+		link.Path = strings.TrimSuffix(link.Path, "/")
+		link.RawPath = strings.TrimSuffix(link.RawPath, "/")
+	}
+
+	if link.Path == "" {
+		link.Path = "/"
+	}
+
+	query := link.Query()
+
+	for param := range query {
+		if strings.HasPrefix(param, "utm_") || slices.Contains(trackingParams, param) {
+			query.Del(param)
+		}
+	}
+
+	// Encode sorts the parameters by key
+	link.RawQuery = query.Encode()
+
+	return nil, link.String()
+}
+
+/*
+sameDomain
+
+whether two hosts belong to the same site, a leading www is ignored
+*/
+func sameDomain(a, b string) bool {
+	return strings.TrimPrefix(strings.ToLower(a), "www.") == strings.TrimPrefix(strings.ToLower(b), "www.")
+}
+
+/*
+crawlTarget
+
+a url waiting to be scraped along with how many links away from a seed url it is
+*/
+type crawlTarget struct {
+	url   string
+	depth uint8
+}
+
+/*
+crawler
+
+decides which links are followed during a fetch session and remembers every page that was visited
+*/
+type crawler struct {
+	settings *scraper2.Crawl
+	lock     sync.Mutex
+	visited  map[string]struct{}
+}
+
+/*
+newCrawler
+
+creates a crawler, the seed urls are marked as visited
+*/
+func newCrawler(settings *scraper2.Crawl, seeds []string) *crawler {
+	c := &crawler{
+		settings: settings,
+		visited:  make(map[string]struct{}, len(seeds)),
+	}
+
+	for _, seed := range seeds {
+		if err, canonical := canonicalUrl(seed, nil); err == nil {
+			c.visited[canonical] = struct{}{}
+		}
+	}
+
+	return c
+}
+
+/*
+follows
+
+whether a canonical link found on page passes the domain, allow and deny rules
+*/
+func (c *crawler) follows(link string, page *url.URL) bool {
+	parsed, err := url.Parse(link)
+
+	if err != nil {
+		return false
+	}
+
+	if c.settings.SameDomain && page != nil && !sameDomain(parsed.Hostname(), page.Hostname()) {
+		return false
+	}
+
+	for _, deny := range c.settings.Deny {
+		if deny.MatchString(link) {
+			return false
+		}
+	}
+
+	if len(c.settings.Allow) == 0 {
+		return true
+	}
+
+	for _, allow := range c.settings.Allow {
+		if allow.MatchString(link) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+seen
+
+whether a canonical link has already been visited
+*/
+func (c *crawler) seen(link string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.visited[link]
+	return ok
+}
+
+/*
+admit
+
+marks a canonical link as visited, false is returned when it was already visited or the page limit was reached. a
+nil crawler admits nothing, a resumed session may hold links saved while crawling was still enabled
+*/
+func (c *crawler) admit(link string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.visited[link]; ok {
+		return false
+	}
+
+	if len(c.visited) >= int(c.settings.MaxPages) {
+		return false
+	}
+
+	c.visited[link] = struct{}{}
+	return true
+}
+
+/*
+filterLinks
+
+canonicalises the links found on a page and keeps the unvisited ones that pass the crawl rules, each link is kept
+once
+*/
+func (c *crawler) filterLinks(pageUrl string, links []chrome.Link) []chrome.Link {
+	page, err := url.Parse(pageUrl)
+
+	if err != nil {
+		page = nil
+	}
+
+	kept := make([]chrome.Link, 0, len(links))
+	unique := map[string]struct{}{}
+
+	for _, link := range links {
+		err, canonical := canonicalUrl(link.Href, page)
+
+		if err != nil {
+			continue
+		}
+
+		if _, ok := unique[canonical]; ok || c.seen(canonical) || !c.follows(canonical, page) {
+			continue
+		}
+
+		unique[canonical] = struct{}{}
+		kept = append(kept, chrome.Link{Href: canonical, Text: link.Text})
+	}
+
+	return kept
+}
+
+/*
+pickLinks
+
+asks the llm which links lead to data for the task, only the first maxPickLinks links are offered
+*/
+func pickLinks(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	task string,
+	links []chrome.Link) (error, []chrome.Link) {
+
+	if len(links) == 0 {
+		return nil, nil
+	}
+
+	links = links[:min(len(links), maxPickLinks)]
+
+	var prompt strings.Builder
+	err := linksTemplate.Execute(&prompt, struct {
+		Task  string
+		Links []chrome.Link
+	}{task, links})
+
+	if err != nil {
+		return err, nil
+	}
+
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: prompt.String()})
+
+	if err = llm.Validate(builder); err != nil {
+		return err, nil
+	}
+
+	err, convo := builder.Build()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, completion := llm.Complete(ctx, &convo, nil)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if completion.Choices[0].Message.Content == nil {
+		return errors.New("the llm did not pick any links"), nil
+	}
+
+	var picked []int
+	response := jsonparser.RemoveIdentifier(strings.TrimSpace(*completion.Choices[0].Message.Content))
+
+	if err = json.Unmarshal([]byte(strings.TrimSpace(response)), &picked); err != nil {
+		return fmt.Errorf("failed to read the picked links %v", err), nil
+	}
+
+	chosen := make([]chrome.Link, 0, len(picked))
+
+	for _, index := range picked {
+		if index >= 0 && index < len(links) {
+			chosen = append(chosen, links[index])
+		}
+	}
+
+	return nil, chosen
+}
+
+/*
+discover
+
+collects the links of the open page that should be followed, when PickLinks is enabled the llm narrows them down to
+those suiting the task. should the llm fail every link passing the rules is followed
+*/
+func (c *crawler) discover(
+	ctx context.Context,
+	pageUrl string,
+	llm *scraper2.LanguageModel,
+	task string,
+	logger func(message string)) (error, []string) {
+
+	var links []chrome.Link
+
+	if err := chrome.CollectLinks(&links).Do(ctx); err != nil {
+		return err, nil
+	}
+
+	links = c.filterLinks(pageUrl, links)
+	logger(fmt.Sprintf("found %d links to follow on %s", len(links), pageUrl))
+
+	if c.settings.PickLinks && len(links) > 0 {
+		err, picked := pickLinks(ctx, llm, task, links)
+
+		if err != nil {
+			logger(fmt.Sprintf("failed to pick links with the llm, following all of them: %v", err))
+		} else {
+			logger(fmt.Sprintf("the llm picked %d of %d links on %s", len(picked), len(links), pageUrl))
+			links = picked
+		}
+	}
+
+	hrefs := make([]string, len(links))
+	for index, link := range links {
+		hrefs[index] = link.Href
+	}
+
+	return nil, hrefs
+}
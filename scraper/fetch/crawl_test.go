@@ -0,0 +1,132 @@
+package fetch
+
+import (
+	"context"
+	"huan/chrome"
+	"huan/llm/model/standin"
+	scraper2 "huan/scraper"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_canonicalUrl(t *testing.T) {
+	base, _ := url.Parse("https://huan.dev/dogs/")
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"relative", "beren?b=2&a=1", "https://huan.dev/dogs/beren?a=1&b=2", false},
+		{"case and port", "HTTPS://Huan.DEV:443/Dogs/", "https://huan.dev/Dogs", false},
+		{"fragment and tracking", "/dogs?utm_source=mail&page=2#top", "https://huan.dev/dogs?page=2", false},
+		{"root", "https://huan.dev", "https://huan.dev/", false},
+		{"custom port", "http://huan.dev:8080/", "http://huan.dev:8080/", false},
+		{"mail", "mailto:huan@huan.dev", "", true},
+		{"script", "javascript:void(0)", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, got := canonicalUrl(tt.raw, base)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("canonicalUrl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("canonicalUrl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_crawler_filterLinks(t *testing.T) {
+	settings := &scraper2.Crawl{
+		Allow:      []*regexp.Regexp{regexp.MustCompile(`/dogs/`)},
+		Deny:       []*regexp.Regexp{regexp.MustCompile(`/dogs/private`)},
+		SameDomain: true,
+		MaxPages:   10,
+	}
+
+	crawl := newCrawler(settings, []string{"https://huan.dev/dogs/huan"})
+
+	links := crawl.filterLinks("https://huan.dev/dogs", []chrome.Link{
+		{Href: "https://huan.dev/dogs/huan/"},         // the seed
+		{Href: "https://www.huan.dev/dogs/beren"},     // same site
+		{Href: "https://huan.dev/dogs/beren#photos"},  // the same page again
+		{Href: "https://huan.dev/dogs/private/huan"},  // denied
+		{Href: "https://huan.dev/cats/tevildo"},       // not allowed
+		{Href: "https://elsewhere.dev/dogs/luthien"},  // another domain
+		{Href: "https://huan.dev/dogs/luthien?ref=x"}, // tracked
+	})
+
+	if len(links) != 3 {
+		t.Fatalf("expected 3 links got %v", links)
+	}
+
+	if links[2].Href != "https://huan.dev/dogs/luthien" {
+		t.Errorf("the link was not canonicalised %s", links[2].Href)
+	}
+}
+
+func Test_crawler_admit(t *testing.T) {
+	crawl := newCrawler(&scraper2.Crawl{MaxPages: 2}, []string{"https://huan.dev"})
+
+	if crawl.admit("https://huan.dev/") {
+		t.Error("the seed url was admitted again")
+	}
+
+	if !crawl.admit("https://huan.dev/beren") {
+		t.Error("a new url was not admitted")
+	}
+
+	if crawl.admit("https://huan.dev/luthien") {
+		t.Error("the page limit was exceeded")
+	}
+
+	var disabled *crawler
+
+	if disabled.admit("https://huan.dev/luthien") {
+		t.Error("a session without crawling admitted a link")
+	}
+}
+
+func Test_pickLinks(t *testing.T) {
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		prompt := body["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
+
+		if !strings.Contains(prompt, "1. beren - https://huan.dev/dogs/beren") {
+			return "[]"
+		}
+
+		return "```json\n[1, 7]```"
+	})
+	defer server.Close()
+
+	err, llm := scraper2.InitLanguageModel(
+		"openai",
+		map[string]interface{}{"apiKey": "test", "model": "gpt-4o", "baseUrl": server.URL},
+		nil, nil, nil, false, nil, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, picked := pickLinks(context.Background(), llm, "collect dogs", []chrome.Link{
+		{Href: "https://huan.dev/about", Text: "about"},
+		{Href: "https://huan.dev/dogs/beren", Text: "beren"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// indexes out of range are ignored
+	if len(picked) != 1 || picked[0].Text != "beren" {
+		t.Errorf("unexpected links picked %v", picked)
+	}
+}
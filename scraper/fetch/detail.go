@@ -0,0 +1,328 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chromedp/chromedp"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"net/url"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const detailErrorKey = "_detailError" // the sample key holding why the detail page of an item could not be used
+
+/*
+listTemplate
+
+the template used on list pages, the detail fields are left to the detail pages and the link field is added so every
+item points to its detail page
+*/
+func listTemplate(template map[string]interface{}, detail *scraper2.Detail) map[string]interface{} {
+	if detail == nil {
+		return template
+	}
+
+	list := make(map[string]interface{}, len(template)+1)
+
+	for field, value := range template {
+		if !slices.Contains(detail.Fields, field) {
+			list[field] = value
+		}
+	}
+
+	if _, ok := list[detail.LinkField]; !ok {
+		list[detail.LinkField] = "the url of the page with the details of this item"
+	}
+
+	return list
+}
+
+/*
+missingFields
+
+the part of the template a detail page should fill in for an item, these are the detail fields, or every field when
+none were configured, that the item left blank
+*/
+func missingFields(
+	item map[string]interface{},
+	template map[string]interface{},
+	detail *scraper2.Detail) map[string]interface{} {
+
+	missing := map[string]interface{}{}
+
+	for field, value := range template {
+		if field == detail.LinkField || (len(detail.Fields) > 0 && !slices.Contains(detail.Fields, field)) {
+			continue
+		}
+
+		if isBlank(item[field]) {
+			missing[field] = value
+		}
+	}
+
+	return missing
+}
+
+/*
+mergeDetail
+
+fills in the missing fields of an item with the values found on its detail page
+*/
+func mergeDetail(item, found, missing map[string]interface{}) {
+	for field := range missing {
+		if value, ok := found[field]; ok && !isBlank(value) {
+			item[field] = value
+		}
+	}
+}
+
+/*
+groupItems
+
+keys the items of a list page by the canonical url of their detail page, items sharing a detail page are merged into
+the first of them. items without a usable link are returned separately
+*/
+func groupItems(
+	samples []map[string]interface{},
+	linkField string,
+	pageUrl string) (keys []string, items map[string]map[string]interface{}, unlinked []map[string]interface{}) {
+
+	page, err := url.Parse(pageUrl)
+
+	if err != nil {
+		page = nil
+	}
+
+	items = map[string]map[string]interface{}{}
+
+	for _, sample := range samples {
+		link, _ := sample[linkField].(string)
+		err, key := canonicalUrl(link, page)
+
+		if link == "" || err != nil {
+			unlinked = append(unlinked, sample)
+			continue
+		}
+
+		if existing, ok := items[key]; ok {
+			for field, value := range sample {
+				if isBlank(existing[field]) {
+					existing[field] = value
+				}
+			}
+
+			continue
+		}
+
+		sample[linkField] = key
+		items[key] = sample
+		keys = append(keys, key)
+	}
+
+	return keys, items, unlinked
+}
+
+/*
+detailStage
+
+visits the detail pages of the items found on a list page, extracting the fields the list page did not show
+*/
+type detailStage struct {
+	settings     *scraper2.Detail
+	llm          *scraper2.LanguageModel
+	prompt       *template.Template
+	systemPrompt *template.Template
+	task         string
+	template     map[string]interface{}
+	confidence   *scraper2.Confidence
+	consistency  *scraper2.Consistency
+	chunking     *scraper2.Chunking
+	stats        *sessionStats
+	workers      uint8
+	polite       *politeness
+	progress     *checkpoint
+	logger       func(message string)
+}
+
+/*
+newDetailStage
+
+creates the detail stage of a session, nil is returned when detail pages are not visited
+*/
+func newDetailStage(
+	llm *scraper2.LanguageModel,
+	fetchSettings *scraper2.Fetch,
+	prompt *template.Template,
+	systemPrompt *template.Template,
+	stats *sessionStats,
+	polite *politeness,
+	progress *checkpoint,
+	logger func(message string)) *detailStage {
+
+	if fetchSettings.Detail == nil {
+		return nil
+	}
+
+	return &detailStage{
+		settings:     fetchSettings.Detail,
+		llm:          llm,
+		prompt:       prompt,
+		systemPrompt: systemPrompt,
+		task:         fetchSettings.Task,
+		template:     fetchSettings.ExampleTemplate,
+		confidence:   fetchSettings.Confidence,
+		consistency:  fetchSettings.Consistency,
+		chunking:     fetchSettings.Chunking,
+		stats:        stats,
+		workers:      fetchSettings.Workers,
+		polite:       polite,
+		progress:     progress,
+		logger:       logger,
+	}
+}
+
+/*
+visit
+
+opens a detail page in a new tab of the browser and extracts the missing fields of an item from it. the list page
+at pageUrl is still open so the detail page shares its politeness slot
+*/
+func (d *detailStage) visit(
+	ctx context.Context,
+	pageUrl string,
+	link string,
+	item map[string]interface{},
+	missing map[string]interface{}) (error, map[string]interface{}) {
+
+	err, allowed, release := d.polite.acquireFrom(ctx, pageUrl, link)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if !allowed {
+		return errors.New("the page is disallowed by robots.txt"), nil
+	}
+
+	defer release()
+
+	tab, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+
+	var htmlData string
+
+	err = chromedp.Run(
+		tab,
+		chromedp.Navigate(link),
+		chromedp.Sleep(time.Duration(d.settings.Wait)*time.Millisecond),
+		collectHtml(&htmlData))
+
+	if err != nil {
+		return err, nil
+	}
+
+	known, err := json.Marshal(item)
+
+	if err != nil {
+		return err, nil
+	}
+
+	fields, err := json.MarshalIndent(missing, "", " ")
+
+	if err != nil {
+		return err, nil
+	}
+
+	data := scraper2.PromptData{
+		Task:     fmt.Sprintf("%s. the page describes a single item, what is known about it is %s", d.task, known),
+		Template: string(fields),
+		Url:      link,
+	}
+
+	builder := &messages.ConversationBuilder{}
+	err, convos := htmlConversations(
+		tab, htmlData, d.prompt, d.systemPrompt, data, nil, nil, d.chunking, d.llm, builder, d.logger)
+
+	if err != nil {
+		return err, nil
+	}
+
+	label := fmt.Sprintf("%s detail", link)
+	samples := promptPool(
+		d.llm.GetWorkers(), d.llm, tab, label, convos, data.Template, d.confidence, d.consistency, d.stats, d.progress, d.logger)
+
+	for _, sample := range samples {
+		for field := range missing {
+			if !isBlank(sample[field]) {
+				return nil, sample
+			}
+		}
+	}
+
+	return errors.New("no details were found on the page"), nil
+}
+
+/*
+collect
+
+visits the detail page of every item of a list page, at most workers pages are open at once. items whose detail
+page fails are kept with what the list page showed along with the reason under detailErrorKey
+*/
+func (d *detailStage) collect(
+	ctx context.Context,
+	pageUrl string,
+	samples []map[string]interface{}) []map[string]interface{} {
+
+	keys, items, unlinked := groupItems(samples, d.settings.LinkField, pageUrl)
+
+	for _, item := range unlinked {
+		item[detailErrorKey] = "the item has no detail link"
+	}
+
+	workerPool := make(chan struct{}, max(d.workers, 1))
+	wg := sync.WaitGroup{}
+
+	for _, key := range keys {
+		item := items[key]
+		missing := missingFields(item, d.template, d.settings)
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			workerPool <- struct{}{}
+			defer func() { <-workerPool }()
+
+			// the item is only read here, it is updated once the detail page was extracted
+			err, found := d.visit(ctx, pageUrl, key, item, missing)
+
+			if err != nil {
+				d.logger(fmt.Sprintf("failed to collect the detail page %s: %v", key, err))
+				item[detailErrorKey] = err.Error()
+				return
+			}
+
+			mergeDetail(item, found, missing)
+		}()
+	}
+
+	wg.Wait()
+
+	merged := make([]map[string]interface{}, 0, len(keys)+len(unlinked))
+
+	for _, key := range keys {
+		merged = append(merged, items[key])
+	}
+
+	return append(merged, unlinked...)
+}
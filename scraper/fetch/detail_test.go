@@ -0,0 +1,98 @@
+package fetch
+
+import (
+	scraper2 "huan/scraper"
+	"testing"
+)
+
+func Test_listTemplate(t *testing.T) {
+	template := map[string]interface{}{"name": "", "price": 0, "description": ""}
+	detail := &scraper2.Detail{LinkField: "link", Fields: []string{"description"}}
+
+	list := listTemplate(template, detail)
+
+	if _, ok := list["description"]; ok {
+		t.Error("the detail field was kept in the list template")
+	}
+
+	if _, ok := list["link"]; !ok {
+		t.Error("the link field was not added to the list template")
+	}
+
+	if len(template) != 3 {
+		t.Error("the session template was changed")
+	}
+
+	if got := listTemplate(template, nil); len(got) != 3 {
+		t.Error("the template changed without detail pages")
+	}
+}
+
+func Test_missingFields(t *testing.T) {
+	template := map[string]interface{}{"name": "", "price": 0, "description": "", "link": ""}
+	item := map[string]interface{}{"name": "huan", "price": nil, "link": "https://huan.dev/huan"}
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string
+	}{
+		{"every blank field", nil, []string{"price", "description"}},
+		{"detail fields only", []string{"description"}, []string{"description"}},
+		{"filled detail field", []string{"name"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missing := missingFields(item, template, &scraper2.Detail{LinkField: "link", Fields: tt.fields})
+
+			if len(missing) != len(tt.want) {
+				t.Fatalf("expected %v got %v", tt.want, missing)
+			}
+
+			for _, field := range tt.want {
+				if _, ok := missing[field]; !ok {
+					t.Errorf("%s was not missing %v", field, missing)
+				}
+			}
+		})
+	}
+}
+
+func Test_mergeDetail(t *testing.T) {
+	item := map[string]interface{}{"name": "huan", "price": nil}
+	found := map[string]interface{}{"name": "not huan", "price": 10.0, "colour": "grey"}
+
+	mergeDetail(item, found, map[string]interface{}{"price": 0})
+
+	if item["price"] != 10.0 || item["name"] != "huan" {
+		t.Errorf("only the missing fields should be merged %v", item)
+	}
+
+	if _, ok := item["colour"]; ok {
+		t.Errorf("a field outside the template was merged %v", item)
+	}
+}
+
+func Test_groupItems(t *testing.T) {
+	samples := []map[string]interface{}{
+		{"name": "huan", "link": "/dogs/huan"},
+		{"name": "beren", "link": "https://huan.dev/dogs/beren"},
+		{"name": "", "price": 10.0, "link": "https://huan.dev/dogs/huan#top"},
+		{"name": "luthien"},
+	}
+
+	keys, items, unlinked := groupItems(samples, "link", "https://huan.dev/dogs")
+
+	if len(keys) != 2 || keys[0] != "https://huan.dev/dogs/huan" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if items[keys[0]]["price"] != 10.0 || items[keys[0]]["name"] != "huan" {
+		t.Errorf("items sharing a detail page were not merged %v", items[keys[0]])
+	}
+
+	if len(unlinked) != 1 || unlinked[0]["name"] != "luthien" {
+		t.Errorf("unexpected unlinked items %v", unlinked)
+	}
+}
@@ -3,38 +3,50 @@ package fetch
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/chromedp/chromedp"
 	"huan/llm/messages"
 	scraper2 "huan/scraper"
 	"os"
 	"path/filepath"
-	"sync"
+	"text/template"
 	"time"
 )
 
-func initContext(parentContext context.Context, headless bool) (context.Context, context.CancelFunc) {
-	var ctx context.Context
-	var cancel context.CancelFunc
+const teardownTimeout = 10 * time.Second // how long the urls in progress have to stop once the session ends
 
-	if headless {
-		ctx, cancel = chromedp.NewContext(
-			parentContext,
-		)
-	} else {
-		actx, _ := chromedp.NewExecAllocator(
-			parentContext,
-			append(
-				chromedp.DefaultExecAllocatorOptions[:],
-				chromedp.Flag("headless", false))...)
+/*
+initContext
+
+starts a browser identifying as userAgent, cancelling the returned context closes the browser
+*/
+func initContext(parentContext context.Context, headless bool, userAgent string) (context.Context, context.CancelFunc) {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.Flag("headless", headless))
+
+	if userAgent != "" {
+		opts = append(opts, chromedp.UserAgent(userAgent))
+	}
+
+	actx, allocatorCancel := chromedp.NewExecAllocator(parentContext, opts...)
+	ctx, cancel := chromedp.NewContext(actx)
+
+	return ctx, func() {
+		cancel()
+		allocatorCancel()
+	}
+}
 
-		ctx, cancel = chromedp.NewContext(
-			actx,
-		)
+/*
+sessionUserAgent
+
+the user agent the browsers of a session identify as, empty keeps the default of the browser
+*/
+func sessionUserAgent(fetchSettings *scraper2.Fetch) string {
+	if fetchSettings.Politeness == nil {
+		return ""
 	}
 
-	return ctx, cancel
+	return fetchSettings.Politeness.UserAgent
 }
 
 func collectHtml(pString *string) chromedp.ActionFunc {
@@ -71,52 +83,30 @@ func collectScreenshot(buffer *[]byte, quality uint8) (chromedp.ActionFunc, stri
 	}, ext
 }
 
-func collectContext(pString *string, pBuffer *[]byte, ctx context.Context) (error, string) {
-	err := collectHtml(pString).Do(ctx)
-	if err != nil {
-		return err, ""
-	}
-
-	function, ext := collectScreenshot(pBuffer, 100)
-
-	err = function.Do(ctx)
-	if err != nil {
-		return err, ""
-	}
-
-	return err, ext
-}
-
-func addVisualContext(
-	builder *messages.ConversationBuilder,
-	pImageBuffer *[]byte,
-	ext string) {
-	mess := messages.StandardMessage{
-		Role:    "user",
-		Content: `the following image is the webpage that needs to be scraped`,
-	}
-
-	mm := messages.MultiModalMessage{
-		Role: "user",
-	}
-	mm.AppendImageBytes(*pImageBuffer, nil, ext)
-
-	builder.AddStandardMessage(&mess).AddMultimodalMessage(&mm)
-
-	return
-}
-
 func scraper(
 	url string,
-	samples *[]map[string]interface{},
-	capacity uint16,
+	store *sampleStore,
 	model *scraper2.LanguageModel,
 	task string,
 	template map[string]interface{},
+	prompt *template.Template,
+	systemPrompt *template.Template,
+	examples []scraper2.Example,
+	visual *scraper2.Visual,
+	screens *scraper2.Screenshots,
+	chunking *scraper2.Chunking,
+	pagination []*scraper2.Pagination,
+	polite *politeness,
+	confidence *scraper2.Confidence,
+	consistency *scraper2.Consistency,
+	stats *sessionStats,
 	builder *messages.ConversationBuilder,
 	logger func(message string),
-	lock *sync.Mutex,
-	urls *[]string) chromedp.ActionFunc {
+	crawl *crawler,
+	details *detailStage,
+	urls *[]string,
+	collector *batchCollector,
+	progress *checkpoint) chromedp.ActionFunc {
 
 	return func(c context.Context) error {
 		err := chromedp.Navigate(url).Do(c)
@@ -126,59 +116,188 @@ func scraper(
 			return err
 		}
 
-		system := messages.StandardMessage{
-			Role:    "system",
-			Content: "you are an expert webscraper specialized in collecting html data",
-		}
-
-		builder.AddStandardMessage(&system)
+		bytes, err := json.MarshalIndent(template, "", " ")
 
 		if err != nil {
-			panic(err)
+			return err
+		}
+
+		data := scraper2.PromptData{
+			Task:     task,
+			Template: string(bytes),
+			Url:      url,
 		}
 
-		for capacity > uint16(len(*samples)) {
-			var htmlData string
-			var imageBuffer []byte
+		if crawl != nil {
+			// links are collected before the page is changed by extraction
+			err, links := crawl.discover(c, url, model, task, logger)
 
-			err, _ := collectContext(&htmlData, &imageBuffer, c)
 			if err != nil {
-				return errors.New("failed to collect website data such as background or html")
+				logger(fmt.Sprintf("failed to collect the links of %s: %v", url, err))
 			}
 
-			//addVisualContext(model, &imageBuffer, ext)
+			*urls = append(*urls, links...)
+		}
 
-			var limit uint
+		pages := newPager(pagination, url, polite)
+		var htmlData string
 
-			// TODO: Request in the yaml splittable limits
+		if pages != nil {
+			err, htmlData = pages.start(c)
+		} else {
+			err = collectHtml(&htmlData).Do(c)
+		}
 
-			limit += 40_000 * 4
-			strArr := splitStringByLen(&htmlData, limit)
+		if err != nil {
+			return fmt.Errorf("failed to collect the html of %s: %v", url, err)
+		}
 
-			bytes, err := json.MarshalIndent(template, "", " ")
+		/*
+			collects the samples of the page the browser is on, they are saved to the session state once collected
+		*/
+		collectPage := func(c context.Context, label string, page int) error {
+			var convos []messages.Conversation
+			var err error
+
+			if screens != nil {
+				// the page is only seen through screenshots, its html is only used to tell pages apart
+				err, convos = screenConversations(c, prompt, systemPrompt, data, model, builder, screens)
+			} else {
+				err, convos = htmlConversations(
+					c, htmlData, prompt, systemPrompt, data, examples, visual, chunking, model, builder, logger)
+			}
 
 			if err != nil {
 				return err
 			}
 
-			samp := promptPool(2, task, string(bytes), model, c, builder, strArr, logger)
-			logger("finished collecting all page data")
+			if collector != nil {
+				// batch mode, the chunks are completed once every url has been scraped
+				collector.add(url, label, convos)
+				logger(fmt.Sprintf("queued all data of %s for the batch job", label))
+				return nil
+			}
+
+			records := promptPoolRecords(
+				model.GetWorkers(), model, c, label, convos, data.Template, confidence, consistency, stats, progress, logger)
+			samp := pageSamples(records, screens, chunking)
+
+			if details != nil {
+				samp = details.collect(c, url, samp)
+			}
+
+			logger(fmt.Sprintf("finished collecting all data of %s", label))
+
+			// only the samples within the limit of the session are kept
+			progress.finishPage(url, page, store.add(samp))
+
+			return nil
+		}
 
-			lock.Lock()
-			*samples = append(*samples, samp...)
-			lock.Unlock()
+		for page := 1; !store.isFull(); page++ {
+			label := fmt.Sprintf("%s page %d", url, page)
 
-			*urls = append(*urls, []string{}...)
+			if progress.pageDone(url, page) {
+				// the samples of the page were loaded when the session was resumed
+				logger(fmt.Sprintf("skipping %s, it was collected before the session was resumed", label))
+			} else if err = collectPage(c, label, page); err != nil {
+				return err
+			}
 
-			break
+			if pages == nil {
+				break
+			}
 
+			var more bool
+			if err, more, htmlData = pages.advance(c); err != nil {
+				return err
+			}
+
+			if !more {
+				logger(fmt.Sprintf("reached the last page of %s after %d pages", url, page))
+				break
+			}
 		}
 
+		stats.addUrl()
+
 		return err
 	}
 
 }
 
+/*
+htmlConversations
+
+builds a conversation for every chunk of the html of a page, a screenshot of the page is taken when visual context
+is enabled. the conversations start with a copy of builder, it is left unchanged so it can be shared between pages
+*/
+func htmlConversations(
+	ctx context.Context,
+	htmlData string,
+	prompt *template.Template,
+	systemPrompt *template.Template,
+	data scraper2.PromptData,
+	examples []scraper2.Example,
+	visual *scraper2.Visual,
+	chunking *scraper2.Chunking,
+	model *scraper2.LanguageModel,
+	builder *messages.ConversationBuilder,
+	logger func(message string)) (error, []messages.Conversation) {
+
+	if chunking == nil {
+		chunking = &scraper2.Chunking{Tokens: chunkTokens}
+	}
+
+	err, limit := chunkLimit(prompt, data, examples, visual, chunking.Tokens)
+
+	if err != nil {
+		return err, nil
+	}
+
+	chunks := chunkHtml(htmlData, limit, chunking.Overlap)
+	strArr := make([]*string, len(chunks))
+
+	for index := range chunks {
+		strArr[index] = &chunks[index]
+	}
+
+	data.ChunkCount = len(strArr)
+
+	err, content := scraper2.RenderPrompt(systemPrompt, data)
+
+	if err != nil {
+		return err, nil
+	}
+
+	builder = builder.Clone()
+	builder.AddStandardMessage(&messages.StandardMessage{
+		Role:    "system",
+		Content: content,
+	})
+
+	if err = addExamples(prompt, data, examples, builder); err != nil {
+		return err, nil
+	}
+
+	var tiles [][][]byte
+
+	if visual != nil {
+		var imageBuffer []byte
+		screenshot, _ := collectScreenshot(&imageBuffer, 100)
+
+		if err = screenshot.Do(ctx); err != nil {
+			return fmt.Errorf("failed to take a screenshot of the page %v", err), nil
+		}
+
+		if err, tiles = tileScreenshot(imageBuffer, len(strArr), visual, logger); err != nil {
+			return err, nil
+		}
+	}
+
+	return buildChunkConversations(ctx, prompt, data, model, builder, strArr, tiles, visual)
+}
+
 func splitStringByLen(pStr *string, strLen uint) []*string {
 	var chunks []*string
 
@@ -188,8 +307,8 @@ func splitStringByLen(pStr *string, strLen uint) []*string {
 
 	str := []rune(*pStr)
 
-	if uint(len(str)) < strLen {
-		panic("the length of the str is less then strlen")
+	if uint(len(str)) <= strLen {
+		return []*string{pStr}
 	}
 
 	hasRemainder := (len(str) % int(strLen)) != 0
@@ -264,99 +383,201 @@ func splitStringIntoBuckets(pStr *string, bucketCount uint) []*string {
 	return pStrSlice
 }
 
+/*
+Collect
+
+scrapes every url of the session with a pool of workers and writes the collected samples once it ends. closing stop
+ends the session gracefully, no new urls are started and the urls in progress have the grace period to finish.
+cancelling ctx ends it at once, either way the samples collected so far are written. every conversation starts with
+a copy of conversationBuilder, it is never changed so every url can share it
+*/
 func Collect(
+	ctx context.Context,
+	stop <-chan struct{},
 	llm *scraper2.LanguageModel,
 	fetchSettings *scraper2.Fetch,
 	set *scraper2.Settings,
 	conversationBuilder *messages.ConversationBuilder,
 	logger func(message string)) error {
 
-	// make it so scraper returns list of funcs
+	var collector *batchCollector
+
+	if llm.IsBatch() {
+		err, state := loadBatchState(batchStatePath(fetchSettings.SavePath, set.SessionName))
+
+		if err != nil {
+			return err
+		}
+
+		if state != nil {
+			logger(fmt.Sprintf("resuming batch %s", state.BatchId))
+
+			stats := &sessionStats{}
+			prompt, systemPrompt := promptTemplates(fetchSettings)
+			polite := newPoliteness(ctx, fetchSettings.Politeness, logger)
+			details := newDetailStage(llm, fetchSettings, prompt, systemPrompt, stats, polite, nil, logger)
+
+			return completeBatch(ctx, stop, llm, nil, state, details, fetchSettings, set, stats, logger)
+		}
+
+		collector = &batchCollector{}
+	}
 
 	logger("started fetch session")
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(fetchSettings.MaxRuntime)*time.Second)
-	lock := sync.Mutex{}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(parent, time.Duration(fetchSettings.MaxRuntime)*time.Second)
 	defer cancel()
 
-	sampleSlice := make([]map[string]interface{}, 0, fetchSettings.MaxSamples)
+	stats := &sessionStats{}
+	prompt, systemPrompt := promptTemplates(fetchSettings)
 
-	urlList := fetchSettings.Urls
-	wg := sync.WaitGroup{}
-	urlChan := make(chan string, fetchSettings.Workers)
+	err, urlList := seedUrls(ctx, fetchSettings, logger)
 
-	wg.Add(len(urlList))
+	if err != nil {
+		return err
+	}
 
-	go func() {
-		// add urls to the worker queue
-		for _, url := range urlList {
-			urlChan <- url
+	var progress *checkpoint
+
+	if collector == nil {
+		// batch jobs keep a state of their own
+		dir := stateDir(fetchSettings.SavePath, set.SessionName)
+		err, progress = openCheckpoint(dir, set.Resume, logger)
+
+		if err != nil {
+			return err
 		}
-	}()
 
-	go func() {
-		// start goroutine to wait for all url jobs to finish
-		wg.Wait()
-		cancel()
-	}()
+		announceSession(set.SessionName, dir)
 
-	go func() {
-		// goroutine that constantly checks if we have enough samples
-		for {
-			if len(sampleSlice) >= int(fetchSettings.MaxSamples) {
-				cancel()
-				return
+		defer func() {
+			if err := progress.close(); err != nil {
+				logger(fmt.Sprintf("failed to close the session state: %v", err))
 			}
-		}
-	}()
+		}()
+	}
 
-	for {
+	store := newSampleStore(int(fetchSettings.MaxSamples), progress.resumedSamples())
+
+	go func() {
+		// the session ends once enough samples were collected
 		select {
+		case <-store.full:
+			logger(fmt.Sprintf("collected %d samples, stopping the session", fetchSettings.MaxSamples))
+			cancel()
 		case <-ctx.Done():
-			// check if the parent context finished if so end the session
-			err := writeData(&sampleSlice, fetchSettings.SavePath, set.SessionName)
-			return err
+		}
+	}()
 
-		case currentUrl := <-urlChan:
-			go func() {
+	var crawl *crawler
+	if fetchSettings.Crawl != nil {
+		crawl = newCrawler(fetchSettings.Crawl, urlList)
+	}
 
-				// start scraping the url
-				var collectedUrls []string
+	polite := newPoliteness(ctx, fetchSettings.Politeness, logger)
+	userAgent := sessionUserAgent(fetchSettings)
 
-				scraperAction := scraper(
-					currentUrl,
-					&sampleSlice,
-					fetchSettings.MaxSamples,
-					llm,
-					fetchSettings.Task,
-					fetchSettings.ExampleTemplate,
-					conversationBuilder,
-					logger,
-					&lock,
-					&collectedUrls)
+	// in batch mode the detail pages are visited once the batch job completed
+	details := newDetailStage(llm, fetchSettings, prompt, systemPrompt, stats, polite, progress, logger)
 
-				logger(fmt.Sprintf("fetching data from %s ...", currentUrl))
-				browserContext, browserCancel := initContext(ctx, fetchSettings.Headless)
-				err := chromedp.Run(browserContext, scraperAction)
-				browserCancel()
+	/*
+		scrapes a url and returns the links found on it that should be crawled
+	*/
+	visit := func(ctx context.Context, target crawlTarget) []crawlTarget {
+		currentUrl := target.url
+		var collectedUrls []string
 
-				// add any collected urls
+		// links are only collected from pages that are not at the max depth
+		var pageCrawl *crawler
+		if crawl != nil && target.depth < crawl.settings.MaxDepth {
+			pageCrawl = crawl
+		}
 
-				wg.Add(len(collectedUrls))
+		scraperAction := scraper(
+			currentUrl,
+			store,
+			llm,
+			fetchSettings.Task,
+			listTemplate(fetchSettings.ExampleTemplate, fetchSettings.Detail),
+			prompt,
+			systemPrompt,
+			fetchSettings.Examples,
+			fetchSettings.Visual,
+			fetchSettings.Screenshots,
+			fetchSettings.Chunking,
+			fetchSettings.Pagination,
+			polite,
+			fetchSettings.Confidence,
+			fetchSettings.Consistency,
+			stats,
+			conversationBuilder,
+			logger,
+			pageCrawl,
+			details,
+			&collectedUrls,
+			collector,
+			progress)
+
+		links, done := progress.urlDone(currentUrl)
+		var err error
+
+		if done {
+			// only the links of a url finished before the session was resumed are needed to keep crawling
+			logger(fmt.Sprintf("skipping %s, it was finished before the session was resumed", currentUrl))
+			collectedUrls = links
+		} else {
+			var allowed bool
+			var release func()
 
-				for _, scraped := range collectedUrls {
-					urlChan <- scraped
-				}
+			err, allowed, release = polite.acquire(ctx, currentUrl)
 
-				// After all urls are added signal that this session completed
-				wg.Done()
+			if err == nil && allowed {
+				logger(fmt.Sprintf("fetching data from %s ...", currentUrl))
+				browserContext, browserCancel := initContext(ctx, fetchSettings.Headless, userAgent)
+				err = chromedp.Run(browserContext, scraperAction)
+				browserCancel()
+				release()
 
-				if err != nil {
-					logger(fmt.Sprintf("received non critical error upon scraping session exit: %v \n", err))
+				if err == nil {
+					progress.finishUrl(currentUrl, collectedUrls)
 				}
-			}()
+			}
 		}
+
+		if err != nil {
+			logger(fmt.Sprintf("received non critical error upon scraping session exit: %v \n", err))
+		}
+
+		// only links that have not been visited and fit within the page limit are followed
+		var found []crawlTarget
+
+		for _, scraped := range collectedUrls {
+			if crawl.admit(scraped) {
+				found = append(found, crawlTarget{url: scraped, depth: target.depth + 1})
+			}
+		}
+
+		return found
 	}
+
+	seeds := make([]crawlTarget, len(urlList))
+	for index, url := range urlList {
+		seeds[index] = crawlTarget{url: url}
+	}
+
+	if !store.isFull() {
+		schedule(ctx, stop, fetchSettings.GracePeriod, fetchSettings.Workers, seeds, visit, logger)
+	}
+
+	if collector != nil {
+		return completeBatch(parent, stop, llm, collector, nil, details, fetchSettings, set, stats, logger)
+	}
+
+	samples := store.snapshot()
+	logger(stats.summary(len(samples)))
+
+	return writeData(&samples, fetchSettings.SavePath, set.SessionName)
 }
 
 func writeData(samples *[]map[string]interface{}, savePath, sessionName string) error {
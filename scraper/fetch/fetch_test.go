@@ -1,6 +1,7 @@
 package fetch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"huan/llm/messages"
@@ -103,9 +104,12 @@ func Test_splitStringByLen(t *testing.T) {
 	}
 
 	t.Run("split string with sequence longer than provided string", func(t *testing.T) {
-		defer defRecover(t, true)
+		defer defRecover(t, false)
 		data := randomString(10)
-		splitStringByLen(&data, 20)
+
+		if chunks := splitStringByLen(&data, 20); len(chunks) != 1 || *chunks[0] != data {
+			t.Errorf("expected the whole string in a single chunk got %d chunks", len(chunks))
+		}
 	})
 
 	t.Run("split string with 0 len sequence", func(t *testing.T) {
@@ -219,7 +223,7 @@ func Test_Collect(t *testing.T) {
 		}
 
 		n := time.Now()
-		err := Collect(&llm, &fetch, &set, &cb, logger)
+		err := Collect(context.Background(), nil, &llm, &fetch, &set, &cb, logger)
 
 		if err == nil {
 			err := os.Remove(filepath.Join(os.TempDir(), "temp-fetched.json"))
@@ -279,7 +283,7 @@ func Test_Collect(t *testing.T) {
 
 		}
 
-		err := Collect(&llm, &fetch, &set, &cb, logger)
+		err := Collect(context.Background(), nil, &llm, &fetch, &set, &cb, logger)
 
 		if err == nil {
 			pth := filepath.Join(os.TempDir(), "temp-fetched.json")
@@ -311,3 +315,41 @@ func Test_Collect(t *testing.T) {
 	})
 
 }
+
+func Test_Collect_stop(t *testing.T) {
+	llm := scraper2.GetTestLanguageModel(scraper2.TestModel{
+		Template: map[string]interface{}{"content": "123"},
+		Key:      "content",
+	})
+
+	fetch := scraper2.Fetch{
+		MaxRuntime:      600,
+		Headless:        true,
+		MaxSamples:      50,
+		Task:            "huan",
+		Urls:            []string{"http://127.0.0.1:1/{1..100}"},
+		ExampleTemplate: map[string]interface{}{"content": "123"},
+		SavePath:        t.TempDir(),
+	}
+
+	set := scraper2.Settings{SessionName: "stopped"}
+
+	// the session is stopped before it starts so it should end right away and still write its data
+	stop := make(chan struct{})
+	close(stop)
+
+	started := time.Now()
+	err := Collect(context.Background(), stop, &llm, &fetch, &set, &messages.ConversationBuilder{}, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(started); elapsed > teardownTimeout+5*time.Second {
+		t.Errorf("the stopped session ran for %v", elapsed)
+	}
+
+	if _, err = os.Stat(filepath.Join(fetch.SavePath, "stopped-fetched.json")); err != nil {
+		t.Errorf("the data of the stopped session was not written %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/chromedp/chromedp"
+	"huan/chrome"
+	scraper2 "huan/scraper"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// marks every element of the page as seen so content added later can be told apart
+	markSeenJs = `document.body.querySelectorAll('*').forEach(e => e.setAttribute('data-huan-seen', ''))`
+
+	// the html of every element added since the page was last marked, nested new elements are only included once
+	// through their outermost new ancestor
+	newContentJs = `(() => {
+		const seen = e => e === document.body || e.hasAttribute('data-huan-seen');
+		const fresh = Array.from(document.body.querySelectorAll('*:not([data-huan-seen])'))
+			.filter(e => e.parentElement && seen(e.parentElement));
+		const html = fresh.map(e => e.outerHTML).join('\n');
+		` + markSeenJs + `;
+		return html;
+	})()`
+)
+
+/*
+matchPagination
+
+the first pagination strategy applying to a url, nil is returned when none do
+*/
+func matchPagination(strategies []*scraper2.Pagination, pageUrl string) *scraper2.Pagination {
+	for _, strategy := range strategies {
+		if strategy.Match == nil || strategy.Match.MatchString(pageUrl) {
+			return strategy
+		}
+	}
+
+	return nil
+}
+
+/*
+pageUrl
+
+sets the page number query parameter of a url
+*/
+func pageUrl(raw, param string, page int) (error, string) {
+	link, err := url.Parse(raw)
+
+	if err != nil {
+		return err, ""
+	}
+
+	query := link.Query()
+	query.Set(param, strconv.Itoa(page))
+	link.RawQuery = query.Encode()
+
+	return nil, link.String()
+}
+
+/*
+pager
+
+moves through the pages of a listing with a pagination strategy, remembering what was already seen so traversal
+stops once no new content appears
+*/
+type pager struct {
+	settings    *scraper2.Pagination
+	polite      *politeness
+	url         string
+	visited     int
+	fingerprint [32]byte
+}
+
+/*
+newPager
+
+creates a pager for a url, nil is returned when no pagination strategy applies to it. every page load is spaced out
+and checked against robots.txt by polite
+*/
+func newPager(strategies []*scraper2.Pagination, pageUrl string, polite *politeness) *pager {
+	settings := matchPagination(strategies, pageUrl)
+
+	if settings == nil {
+		return nil
+	}
+
+	return &pager{settings: settings, polite: polite, url: pageUrl}
+}
+
+/*
+incremental
+
+whether new pages add content to the current page rather than replacing it
+*/
+func (p *pager) incremental() bool {
+	return p.settings.Strategy == "loadMore" || p.settings.Strategy == "scroll"
+}
+
+func (p *pager) wait(ctx context.Context) error {
+	return chromedp.Sleep(time.Duration(p.settings.Wait) * time.Millisecond).Do(ctx)
+}
+
+/*
+start
+
+opens the first page and returns its html. the pages strategy starts at the from page rather than the url as given
+*/
+func (p *pager) start(ctx context.Context) (error, string) {
+	if p.settings.Strategy == "pages" {
+		err, first := pageUrl(p.url, p.settings.Param, p.settings.From)
+
+		if err != nil {
+			return err, ""
+		}
+
+		err, allowed := p.polite.pace(ctx, first)
+
+		if err != nil {
+			return err, ""
+		}
+
+		if !allowed {
+			return fmt.Errorf("the first page %s is disallowed by robots.txt", first), ""
+		}
+
+		if err = chromedp.Navigate(first).Do(ctx); err != nil {
+			return err, ""
+		}
+
+		if err = p.wait(ctx); err != nil {
+			return err, ""
+		}
+	}
+
+	var html string
+
+	if err := collectHtml(&html).Do(ctx); err != nil {
+		return err, ""
+	}
+
+	if p.incremental() {
+		if err := chromedp.Evaluate(markSeenJs, nil).Do(ctx); err != nil {
+			return err, ""
+		}
+	}
+
+	p.visited = 1
+	p.fingerprint = sha256.Sum256([]byte(html))
+
+	return nil, html
+}
+
+/*
+changed
+
+whether a page differs from the last one, the page is remembered for the next comparison
+*/
+func (p *pager) changed(html string) bool {
+	fingerprint := sha256.Sum256([]byte(html))
+
+	if fingerprint == p.fingerprint {
+		return false
+	}
+
+	p.fingerprint = fingerprint
+	return true
+}
+
+/*
+advance
+
+moves to the next page and returns its html, incremental strategies only return the content that was added. false
+is returned once the page limit is reached, there is nothing to click or no new content appeared
+*/
+func (p *pager) advance(ctx context.Context) (error, bool, string) {
+	if p.visited >= int(p.settings.MaxPages) {
+		return nil, false, ""
+	}
+
+	var html string
+
+	switch p.settings.Strategy {
+	case "next", "loadMore":
+		var clicked bool
+
+		// the page the click leads to is not known beforehand so the listing stands in for it
+		if err, allowed := p.polite.pace(ctx, p.url); err != nil || !allowed {
+			return err, false, ""
+		}
+
+		if err := chrome.ClickIfPresent(p.settings.Selector, &clicked).Do(ctx); err != nil {
+			return err, false, ""
+		}
+
+		if !clicked {
+			return nil, false, ""
+		}
+
+	case "pages":
+		page := p.settings.From + p.visited
+
+		if page > p.settings.To {
+			return nil, false, ""
+		}
+
+		err, next := pageUrl(p.url, p.settings.Param, page)
+
+		if err != nil {
+			return err, false, ""
+		}
+
+		err, allowed := p.polite.pace(ctx, next)
+
+		if err != nil || !allowed {
+			return err, false, ""
+		}
+
+		if err = chromedp.Navigate(next).Do(ctx); err != nil {
+			return err, false, ""
+		}
+
+	case "scroll":
+		_, scroll := chrome.ScrollByPercentage(1)
+
+		if err := scroll.Do(ctx); err != nil {
+			return err, false, ""
+		}
+
+	default:
+		return fmt.Errorf("there is no pagination strategy %s", p.settings.Strategy), false, ""
+	}
+
+	if err := p.wait(ctx); err != nil {
+		return err, false, ""
+	}
+
+	if p.incremental() {
+		if err := chromedp.Evaluate(newContentJs, &html).Do(ctx); err != nil {
+			return err, false, ""
+		}
+
+		if html == "" {
+			return nil, false, ""
+		}
+	} else {
+		if err := collectHtml(&html).Do(ctx); err != nil {
+			return err, false, ""
+		}
+
+		if !p.changed(html) {
+			return nil, false, ""
+		}
+	}
+
+	p.visited++
+	return nil, true, html
+}
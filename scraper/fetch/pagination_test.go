@@ -0,0 +1,82 @@
+package fetch
+
+import (
+	"context"
+	scraper2 "huan/scraper"
+	"regexp"
+	"testing"
+)
+
+func Test_matchPagination(t *testing.T) {
+	listing := &scraper2.Pagination{Match: regexp.MustCompile(`/dogs`), Strategy: "next"}
+	fallback := &scraper2.Pagination{Strategy: "scroll"}
+	strategies := []*scraper2.Pagination{listing, fallback}
+
+	if got := matchPagination(strategies, "https://huan.dev/dogs"); got != listing {
+		t.Errorf("expected the listing strategy got %v", got)
+	}
+
+	if got := matchPagination(strategies, "https://huan.dev/cats"); got != fallback {
+		t.Errorf("expected the fallback strategy got %v", got)
+	}
+
+	if got := matchPagination(strategies[:1], "https://huan.dev/cats"); got != nil {
+		t.Errorf("expected no strategy got %v", got)
+	}
+}
+
+func Test_pageUrl(t *testing.T) {
+	err, got := pageUrl("https://huan.dev/dogs?page=1&sort=name", "page", 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "https://huan.dev/dogs?page=4&sort=name" {
+		t.Errorf("unexpected page url %s", got)
+	}
+}
+
+func Test_pager_changed(t *testing.T) {
+	p := &pager{}
+
+	if !p.changed("<li>huan</li>") {
+		t.Error("the first page was considered unchanged")
+	}
+
+	if p.changed("<li>huan</li>") {
+		t.Error("the same page was considered changed")
+	}
+
+	if !p.changed("<li>beren</li>") {
+		t.Error("a new page was considered unchanged")
+	}
+}
+
+func Test_pager_advance_limits(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings *scraper2.Pagination
+		visited  int
+	}{
+		{"max pages", &scraper2.Pagination{Strategy: "next", MaxPages: 3}, 3},
+		{"last page number", &scraper2.Pagination{Strategy: "pages", Param: "page", From: 1, To: 2, MaxPages: 10}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pager{settings: tt.settings, url: "https://huan.dev", visited: tt.visited}
+
+			// the limits are checked before the browser is used
+			err, more, _ := p.advance(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if more {
+				t.Error("traversal continued past its limit")
+			}
+		})
+	}
+}
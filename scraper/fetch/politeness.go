@@ -0,0 +1,276 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	scraper2 "huan/scraper"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const robotsTimeout = 10 * time.Second // how long fetching a robots.txt can take
+
+/*
+hostSlot
+
+the state shared by every request to a single host
+*/
+type hostSlot struct {
+	once   sync.Once
+	robots *robotsRules
+
+	open chan struct{} // limits how many pages of the host are open at once
+	lock sync.Mutex
+	next time.Time // the earliest time the next request may start
+}
+
+/*
+politeness
+
+obeys robots.txt and spaces out the requests made to every host
+*/
+type politeness struct {
+	settings *scraper2.Politeness
+	client   *http.Client
+	logger   func(message string)
+	session  context.Context // robots.txt is fetched within the session so a cancelled page cannot cache a failure
+
+	lock  sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+/*
+newPoliteness
+
+creates the politeness limits of a session, nil is returned when the session has none
+*/
+func newPoliteness(
+	session context.Context,
+	settings *scraper2.Politeness,
+	logger func(message string)) *politeness {
+	if settings == nil {
+		return nil
+	}
+
+	if !settings.ObeyRobots {
+		logger("robots.txt will not be obeyed, obeyRobots was disabled in the politeness settings")
+	}
+
+	return &politeness{
+		settings: settings,
+		client:   &http.Client{Timeout: robotsTimeout},
+		logger:   logger,
+		session:  session,
+		hosts:    map[string]*hostSlot{},
+	}
+}
+
+/*
+slot
+
+the shared state of the host of a url
+*/
+func (p *politeness) slot(link *url.URL) *hostSlot {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := link.Scheme + "://" + link.Host
+	slot, ok := p.hosts[key]
+
+	if !ok {
+		slot = &hostSlot{open: make(chan struct{}, p.settings.HostConcurrency)}
+		p.hosts[key] = slot
+	}
+
+	return slot
+}
+
+/*
+fetchRobots
+
+downloads and parses the robots.txt of a host. a missing robots.txt allows everything while a server error
+disallows everything until the session ends
+*/
+func (p *politeness) fetchRobots(ctx context.Context, link *url.URL) *robotsRules {
+	robotsUrl := link.Scheme + "://" + link.Host + "/robots.txt"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsUrl, nil)
+
+	if err != nil {
+		return &robotsRules{}
+	}
+
+	request.Header.Set("User-Agent", p.settings.UserAgent)
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		p.logger(fmt.Sprintf("failed to fetch %s, the host will not be visited: %v", robotsUrl, err))
+		return &robotsRules{disallowAll: true}
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	switch {
+	case response.StatusCode >= 500:
+		p.logger(fmt.Sprintf("%s returned %d, the host will not be visited", robotsUrl, response.StatusCode))
+		return &robotsRules{disallowAll: true}
+	case response.StatusCode >= 400:
+		return &robotsRules{}
+	}
+
+	return parseRobots(response.Body, robotsAgent(p.settings.UserAgent))
+}
+
+/*
+rules
+
+the cached robots.txt rules of the host of a url, they are fetched once per host
+*/
+func (p *politeness) rules(link *url.URL, slot *hostSlot) *robotsRules {
+	slot.once.Do(func() {
+		slot.robots = p.fetchRobots(p.session, link)
+	})
+
+	return slot.robots
+}
+
+/*
+check
+
+whether robots.txt allows a url along with the interval its host needs between requests
+*/
+func (p *politeness) check(link *url.URL, slot *hostSlot) (bool, time.Duration) {
+	interval := p.settings.MinInterval
+
+	if !p.settings.ObeyRobots {
+		return true, interval
+	}
+
+	robots := p.rules(link, slot)
+
+	// the query is matched as well so rules like /*?sort= apply
+	if !robots.allowed(link.RequestURI()) {
+		p.logger(fmt.Sprintf("skipping %s, it is disallowed by robots.txt", link))
+		return false, interval
+	}
+
+	return true, max(interval, robots.crawlDelay)
+}
+
+/*
+reserve
+
+waits for the next start time of a host, the time after it is reserved so concurrent pages of the host are spaced out
+as well
+*/
+func (p *politeness) reserve(ctx context.Context, slot *hostSlot, interval time.Duration) error {
+	slot.lock.Lock()
+	start := time.Now()
+
+	if slot.next.After(start) {
+		start = slot.next
+	}
+
+	slot.next = start.Add(interval)
+	slot.lock.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+acquire
+
+waits until a page of the url may be opened, false is returned when robots.txt disallows it. release must be called
+once the page is closed
+*/
+func (p *politeness) acquire(ctx context.Context, rawUrl string) (error, bool, func()) {
+	if p == nil {
+		return nil, true, func() {}
+	}
+
+	link, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return err, false, nil
+	}
+
+	slot := p.slot(link)
+	allowed, interval := p.check(link, slot)
+
+	if !allowed {
+		return nil, false, nil
+	}
+
+	select {
+	case slot.open <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err(), false, nil
+	}
+
+	if err = p.reserve(ctx, slot, interval); err != nil {
+		<-slot.open
+		return err, false, nil
+	}
+
+	return nil, true, func() { <-slot.open }
+}
+
+/*
+acquireFrom
+
+like acquire for a page opened while the page of parentUrl is still open. a page of the same host shares the slot of
+its parent so only robots.txt and the interval apply, waiting for a slot of its own would wait for the parent
+*/
+func (p *politeness) acquireFrom(ctx context.Context, parentUrl, rawUrl string) (error, bool, func()) {
+	parent, err := url.Parse(parentUrl)
+
+	if err != nil {
+		return p.acquire(ctx, rawUrl)
+	}
+
+	link, err := url.Parse(rawUrl)
+
+	if err != nil || link.Scheme != parent.Scheme || link.Host != parent.Host {
+		return p.acquire(ctx, rawUrl)
+	}
+
+	err, allowed := p.pace(ctx, rawUrl)
+	return err, allowed, func() {}
+}
+
+/*
+pace
+
+waits until the page the browser is on may move to a url without opening another page, false is returned when
+robots.txt disallows the url
+*/
+func (p *politeness) pace(ctx context.Context, rawUrl string) (error, bool) {
+	if p == nil {
+		return nil, true
+	}
+
+	link, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return err, false
+	}
+
+	slot := p.slot(link)
+	allowed, interval := p.check(link, slot)
+
+	if !allowed {
+		return nil, false
+	}
+
+	if err = p.reserve(ctx, slot, interval); err != nil {
+		return err, false
+	}
+
+	return nil, true
+}
@@ -0,0 +1,223 @@
+package fetch
+
+import (
+	"context"
+	scraper2 "huan/scraper"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func robotsServer(status int, body string, agents *atomic.Value) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if agents != nil {
+			agents.Store(r.UserAgent())
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func Test_politeness_acquire(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		obey   bool
+		path   string
+		want   bool
+	}{
+		{"allowed", http.StatusOK, true, "/dogs", true},
+		{"disallowed", http.StatusOK, true, "/private", false},
+		{"disallowed query", http.StatusOK, true, "/dogs?sort=age", false},
+		{"override", http.StatusOK, false, "/private", true},
+		{"missing robots", http.StatusNotFound, true, "/private", true},
+		{"server error", http.StatusInternalServerError, true, "/dogs", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var agents atomic.Value
+			server := robotsServer(tt.status, "User-agent: *\nDisallow: /private\nDisallow: /*?sort=", &agents)
+			defer server.Close()
+
+			polite := newPoliteness(context.Background(), &scraper2.Politeness{
+				UserAgent:       "huan-test/1.0",
+				ObeyRobots:      tt.obey,
+				HostConcurrency: 1,
+			}, func(string) {})
+
+			err, allowed, release := polite.acquire(context.Background(), server.URL+tt.path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if allowed != tt.want {
+				t.Fatalf("expected allowed to be %v", tt.want)
+			}
+
+			if allowed {
+				release()
+			}
+
+			if tt.obey && agents.Load() != "huan-test/1.0" {
+				t.Errorf("robots.txt was requested as %v", agents.Load())
+			}
+		})
+	}
+}
+
+func Test_politeness_interval(t *testing.T) {
+	server := robotsServer(http.StatusOK, "User-agent: *\nCrawl-delay: 0.1", nil)
+	defer server.Close()
+
+	polite := newPoliteness(context.Background(), &scraper2.Politeness{
+		UserAgent:       "huan-test/1.0",
+		ObeyRobots:      true,
+		HostConcurrency: 2,
+		MinInterval:     10 * time.Millisecond,
+	}, func(string) {})
+
+	started := time.Now()
+
+	for range 3 {
+		err, allowed, release := polite.acquire(context.Background(), server.URL+"/dogs")
+
+		if err != nil || !allowed {
+			t.Fatalf("the page was not allowed %v", err)
+		}
+
+		release()
+	}
+
+	// the crawl delay is longer than the min interval so it spaces out the requests
+	if elapsed := time.Since(started); elapsed < 200*time.Millisecond {
+		t.Errorf("three requests took %v, expected at least 200ms", elapsed)
+	}
+}
+
+func Test_politeness_concurrency(t *testing.T) {
+	server := robotsServer(http.StatusNotFound, "", nil)
+	defer server.Close()
+
+	polite := newPoliteness(context.Background(), &scraper2.Politeness{UserAgent: "huan-test/1.0", HostConcurrency: 1}, func(string) {})
+
+	_, _, release := polite.acquire(context.Background(), server.URL+"/huan")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the only slot of the host is taken so the second page has to wait
+	if err, _, _ := polite.acquire(ctx, server.URL+"/beren"); err == nil {
+		t.Error("a second page of the host was opened at once")
+	}
+
+	release()
+
+	if err, allowed, _ := polite.acquire(context.Background(), server.URL+"/beren"); err != nil || !allowed {
+		t.Errorf("the released slot could not be taken %v", err)
+	}
+}
+
+func Test_politeness_nil(t *testing.T) {
+	var polite *politeness
+
+	if err, allowed, release := polite.acquire(context.Background(), "https://huan.dev"); err != nil || !allowed {
+		t.Error("a session without politeness limits should allow every page")
+	} else {
+		release()
+	}
+}
+
+func Test_politeness_cancelledPage(t *testing.T) {
+	server := robotsServer(http.StatusOK, "User-agent: *\nDisallow: /private", nil)
+	defer server.Close()
+
+	polite := newPoliteness(context.Background(), &scraper2.Politeness{
+		UserAgent:       "huan-test/1.0",
+		ObeyRobots:      true,
+		HostConcurrency: 1,
+	}, func(string) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// robots.txt is fetched within the session so the cancelled page does not disallow the host
+	if _, allowed, release := polite.acquire(ctx, server.URL+"/dogs"); allowed {
+		release()
+	}
+
+	if err, allowed, release := polite.acquire(context.Background(), server.URL+"/dogs"); err != nil || !allowed {
+		t.Errorf("the host was disallowed after a cancelled page %v", err)
+	} else {
+		release()
+	}
+}
+
+func Test_politeness_acquireFrom(t *testing.T) {
+	server := robotsServer(http.StatusOK, "User-agent: *\nDisallow: /private", nil)
+	defer server.Close()
+
+	polite := newPoliteness(context.Background(), &scraper2.Politeness{
+		UserAgent:       "huan-test/1.0",
+		ObeyRobots:      true,
+		HostConcurrency: 1,
+	}, func(string) {})
+
+	_, _, release := polite.acquire(context.Background(), server.URL+"/dogs")
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the list page holds the only slot of the host so its detail pages share it
+	err, allowed, done := polite.acquireFrom(ctx, server.URL+"/dogs", server.URL+"/dogs/huan")
+
+	if err != nil || !allowed {
+		t.Fatalf("the detail page waited for the slot of its list page %v", err)
+	}
+
+	done()
+
+	if err, allowed, _ = polite.acquireFrom(ctx, server.URL+"/dogs", server.URL+"/private"); err != nil || allowed {
+		t.Error("a detail page disallowed by robots.txt was allowed")
+	}
+}
+
+func Test_politeness_pace(t *testing.T) {
+	server := robotsServer(http.StatusOK, "User-agent: *\nDisallow: /dogs?page=3", nil)
+	defer server.Close()
+
+	polite := newPoliteness(context.Background(), &scraper2.Politeness{
+		UserAgent:       "huan-test/1.0",
+		ObeyRobots:      true,
+		HostConcurrency: 1,
+		MinInterval:     50 * time.Millisecond,
+	}, func(string) {})
+
+	_, _, release := polite.acquire(context.Background(), server.URL+"/dogs")
+	defer release()
+
+	started := time.Now()
+
+	if err, allowed := polite.pace(context.Background(), server.URL+"/dogs?page=2"); err != nil || !allowed {
+		t.Fatalf("the next page was not allowed %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed < 40*time.Millisecond {
+		t.Errorf("the next page was loaded after %v, expected the min interval", elapsed)
+	}
+
+	if err, allowed := polite.pace(context.Background(), server.URL+"/dogs?page=3"); err != nil || allowed {
+		t.Error("a page disallowed by robots.txt was allowed")
+	}
+
+	var none *politeness
+
+	if err, allowed := none.pace(context.Background(), server.URL+"/dogs?page=3"); err != nil || !allowed {
+		t.Error("a session without politeness limits should allow every page")
+	}
+}
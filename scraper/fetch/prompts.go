@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"huan/jsonparser"
 	"huan/llm/messages"
+	"huan/llm/model"
 	scraper2 "huan/scraper"
 	"sync"
+	"text/template"
 )
 
 /*
@@ -21,87 +23,421 @@ add threads too yaml config
 //go:embed prompts/collect.txt
 var collectPrompt string
 
-func loadCollectionPrompt(html, task, template string) string {
-	data := fmt.Sprintf(collectPrompt, html, task, template)
-	return data
+//go:embed prompts/system.txt
+var systemPrompt string
+
+//go:embed prompts/screenshots.txt
+var screenshotPrompt string
+
+const (
+	chunkTokens   = 40_000 // the tokens of html and examples sent with every chunk when chunking is not configured
+	charsPerToken = 4
+)
+
+var (
+	defaultCollectTemplate = template.Must(template.New("prompt").Option("missingkey=error").Parse(collectPrompt))
+	defaultSystemTemplate  = template.Must(template.New("systemPrompt").Option("missingkey=error").Parse(systemPrompt))
+
+	defaultScreenshotTemplate = template.Must(template.New("prompt").Option("missingkey=error").Parse(screenshotPrompt))
+)
+
+/*
+promptTemplates
+
+the collection and system prompt templates of a session, falling back to the defaults when they were not configured
+*/
+func promptTemplates(fetchSettings *scraper2.Fetch) (collect, system *template.Template) {
+	collect, system = defaultCollectTemplate, defaultSystemTemplate
+
+	if fetchSettings.Screenshots != nil {
+		collect = defaultScreenshotTemplate
+	}
+
+	if fetchSettings.Prompt != nil {
+		collect = fetchSettings.Prompt
+	}
+
+	if fetchSettings.SystemPrompt != nil {
+		system = fetchSettings.SystemPrompt
+	}
+
+	return collect, system
 }
 
+/*
+processLoadCollectionPrompt
+
+renders the collection prompt of a chunk and adds it to the end of the conversation. when screenshot
+tiles are provided they are sent in the same message
+*/
 func processLoadCollectionPrompt(
-	html,
-	task,
-	template string,
-	builder *messages.ConversationBuilder) {
+	prompt *template.Template,
+	data scraper2.PromptData,
+	tiles [][]byte,
+	visual *scraper2.Visual,
+	builder *messages.ConversationBuilder) error {
+
+	err, content := scraper2.RenderPrompt(prompt, data)
+
+	if err != nil {
+		return err
+	}
+
+	if visual != nil && len(tiles) > 0 {
+		addVisualContext(builder, content, tiles, visual.Detail)
+		return nil
+	}
 
-	prompt := loadCollectionPrompt(html, task, template)
 	mess := messages.StandardMessage{
 		Role:    "user",
-		Content: prompt,
+		Content: content,
 	}
 
 	builder.AddStandardMessage(&mess)
+	return nil
+}
+
+/*
+addExamples
+
+adds every example as a user turn holding the collection prompt of its html, followed by an assistant turn holding the
+data that should be collected from it
+*/
+func addExamples(
+	prompt *template.Template,
+	data scraper2.PromptData,
+	examples []scraper2.Example,
+	builder *messages.ConversationBuilder) error {
+
+	data.ChunkIndex = 0
+	data.ChunkCount = 1
+
+	for _, example := range examples {
+		data.Html = example.Html
+		err, content := scraper2.RenderPrompt(prompt, data)
+
+		if err != nil {
+			return err
+		}
+
+		output := example.Output
+
+		builder.AddStandardMessage(&messages.StandardMessage{
+			Role:    "user",
+			Content: content,
+		}).AddAssistantMessage(&messages.AssistantMessage{
+			Role:    "assistant",
+			Content: &output,
+		})
+	}
+
+	return nil
+}
+
+/*
+chunkLimit
+
+the max characters of html in a chunk of tokens, the tokens used by the examples and the screenshot tiles sent with
+every chunk are taken out of the budget of every chunk
+*/
+func chunkLimit(
+	prompt *template.Template,
+	data scraper2.PromptData,
+	examples []scraper2.Example,
+	visual *scraper2.Visual,
+	tokens uint32) (error, uint) {
+
+	builder := &messages.ConversationBuilder{}
+
+	if err := addExamples(prompt, data, examples, builder); err != nil {
+		return err, 0
+	}
+
+	exampleTokens := model.EstimateTokens(builder)
+	imageTokens := tileTokens(visual)
+
+	if exampleTokens+imageTokens >= int(tokens) {
+		return fmt.Errorf(
+			"the examples and screenshot tiles use an estimated %d and %d tokens leaving no room for the %d token chunk "+
+				"budget",
+			exampleTokens,
+			imageTokens,
+			tokens), 0
+	}
+
+	return nil, uint(int(tokens)-exampleTokens-imageTokens) * charsPerToken
+}
+
+/*
+parseChoice
+
+converts a completion choice into records, scoring each field when confidence was requested
+*/
+func parseChoice(
+	choice messages.Choice,
+	confidence *scraper2.Confidence,
+	logger func(message string)) []*record {
+
+	var response string
+
+	if choice.Message.Content != nil {
+		response = *choice.Message.Content
+	}
+
+	jsonData := jsonparser.ToJson(response)
+
+	if len(jsonData) > 0 {
+		logger("successfully converted response to json")
+	} else {
+		logger("failed to convert response to json")
+	}
+
+	records := newRecords(jsonData)
+
+	if confidence != nil {
+		if choice.Logprobs != nil {
+			scoreRecords(response, choice.Logprobs, records)
+		} else {
+			logger("response contained no log probabilities, skipping confidence scoring")
+		}
+	}
+
+	return records
+}
+
+/*
+extractRecords
+
+requests the completions for a single chunk and converts them into records, completions cut off by the token limit
+are continued and those that cannot be parsed are repaired first. when self consistency is enabled the records of
+every completion are voted on. label identifies the chunk in the transcript, template is the json template the
+samples follow
+*/
+func extractRecords(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	convo *messages.Conversation,
+	label string,
+	template string,
+	confidence *scraper2.Confidence,
+	consistency *scraper2.Consistency,
+	stats *sessionStats,
+	logger func(message string)) (error, []*record) {
+
+	err, choices := sampleChoices(ctx, llm, convo, consistency)
+
+	if err != nil {
+		return err, nil
+	}
+
+	continueChoices(ctx, llm, *convo, choices, logger)
+	repairChoices(ctx, llm, *convo, choices, template, stats, logger)
+	llm.Transcribe(label, *convo, choices)
+
+	return nil, recordsFromChoices(choices, confidence, consistency, logger)
+}
+
+/*
+recordsFromChoices
+
+converts every choice generated for a chunk into records, voting on them when self consistency is enabled
+*/
+func recordsFromChoices(
+	choices []messages.Choice,
+	confidence *scraper2.Confidence,
+	consistency *scraper2.Consistency,
+	logger func(message string)) []*record {
+
+	completions := make([][]*record, len(choices))
+
+	for index, choice := range choices {
+		completions[index] = parseChoice(choice, confidence, logger)
+	}
+
+	var records []*record
+
+	if consistency != nil {
+		records = voteRecords(completions, consistency.MinAgreement)
+	} else {
+		records = completions[0]
+	}
+
+	if confidence != nil {
+		applyConfidence(records, confidence)
+	}
+
+	return records
+}
+
+/*
+buildChunkConversations
+
+builds a conversation for every chunk of html, each one is a copy of prefix ending with the collection prompt for
+its chunk so truncating one does not change the others. data holds the variables shared by every chunk, the html and
+chunk position are filled in here. tiles holds the screenshot tiles of every chunk, it is nil when visual context is
+disabled
+*/
+func buildChunkConversations(
+	ctx context.Context,
+	prompt *template.Template,
+	data scraper2.PromptData,
+	llm *scraper2.LanguageModel,
+	prefix *messages.ConversationBuilder,
+	strs []*string,
+	tiles [][][]byte,
+	visual *scraper2.Visual) (error, []messages.Conversation) {
+
+	convos := make([]messages.Conversation, 0, len(strs))
+	data.ChunkCount = len(strs)
+
+	for index, str := range strs {
+		builder := prefix.Clone()
+		data.Html = *str
+		data.ChunkIndex = index
+
+		var chunkTiles [][]byte
+		if index < len(tiles) {
+			chunkTiles = tiles[index]
+		}
+
+		if err := processLoadCollectionPrompt(prompt, data, chunkTiles, visual, builder); err != nil {
+			return err, nil
+		}
+
+		if err := llm.Fit(ctx, builder); err != nil {
+			return err, nil
+		}
+
+		if err := llm.Validate(builder); err != nil {
+			return err, nil
+		}
+
+		err, convo := builder.Build()
+		if err != nil {
+			return err, nil
+		}
+
+		convos = append(convos, convo)
+	}
+
+	return nil, convos
+}
+
+/*
+pageSamples
+
+turns the records of every chunk of a page into its samples. the records of overlapping screenshots are merged and
+the records repeated by the overlap between html chunks are dropped
+*/
+func pageSamples(
+	groups [][]*record,
+	screens *scraper2.Screenshots,
+	chunking *scraper2.Chunking) []map[string]interface{} {
+
+	switch {
+	case screens != nil:
+		return toSamples(mergeOverlapping(groups))
+	case chunking != nil && chunking.Overlap > 0:
+		return toSamples(dropOverlap(groups))
+	}
+
+	var samples []map[string]interface{}
+
+	for _, records := range groups {
+		samples = append(samples, toSamples(records)...)
+	}
+
+	return samples
 }
 
 /*
 promptPool
 
-ensures that multiple chat completion requests happen concurrently
+ensures that the chat completion requests of every chunk conversation of a url happen concurrently
 */
 func promptPool(
 	threadCount uint8,
-	task,
-	template string,
 	llm *scraper2.LanguageModel,
 	ctx context.Context,
-	builder *messages.ConversationBuilder,
-	strs []*string,
+	url string,
+	convos []messages.Conversation,
+	template string,
+	confidence *scraper2.Confidence,
+	consistency *scraper2.Consistency,
+	stats *sessionStats,
+	progress *checkpoint,
 	logger func(message string)) []map[string]interface{} {
 
+	var samples []map[string]interface{}
+
+	for _, records := range promptPoolRecords(
+		threadCount, llm, ctx, url, convos, template, confidence, consistency, stats, progress, logger) {
+		samples = append(samples, toSamples(records)...)
+	}
+
+	return samples
+}
+
+/*
+promptPoolRecords
+
+completes every chunk conversation of a url concurrently, the records of each conversation are returned at the
+index of the conversation. conversations whose request failed have no records. chunks finished before the session
+was resumed are not requested again
+*/
+func promptPoolRecords(
+	threadCount uint8,
+	llm *scraper2.LanguageModel,
+	ctx context.Context,
+	url string,
+	convos []messages.Conversation,
+	template string,
+	confidence *scraper2.Confidence,
+	consistency *scraper2.Consistency,
+	stats *sessionStats,
+	progress *checkpoint,
+	logger func(message string)) [][]*record {
+
 	type chatResult struct {
-		err      error
-		response string
+		index   int
+		err     error
+		records []*record
 	}
 
-	channel := make(chan chatResult)               // the channel that will contain the results of each request
-	workerPool := make(chan struct{}, threadCount) // limits how many requests can happen at the same time
+	channel := make(chan chatResult)                       // the channel that will contain the results of each request
+	workerPool := make(chan struct{}, max(threadCount, 1)) // limits how many requests can happen at the same time
 
 	wg := sync.WaitGroup{}
 
 	/*
 		schedules all the chat requests that need to happen concurrently
 	*/
-	processLoadCollectionPrompt(*strs[0], task, template, builder)
-
-	for _, str := range strs {
+	for index, convo := range convos {
 		/*
 			a goroutine that completes a chat request
 		*/
-		builder.Pop(builder.Size() - 1)
-		processLoadCollectionPrompt(*str, task, template, builder)
-
-		err := llm.Validate(builder)
-		if err != nil {
-			panic(err)
-		}
-
-		err, convo := builder.Build()
-		if err != nil {
-			panic(err)
-		}
+		label := fmt.Sprintf("%s chunk %d of %d", url, index+1, len(convos))
 
 		wg.Add(1)
 		go func() {
-			workerPool <- struct{}{}             // signal to the worker pool that, work is being done, blocking it once the buffer is full
-			err, result := llm.Chat(ctx, &convo) // start the request
+			workerPool <- struct{}{} // signal to the worker pool that, work is being done, blocking it once the buffer is full
+
+			records, done := progress.chunkDone(label)
+			var err error
 
-			var response string
+			// start the request unless the chunk was finished before
+			if !done {
+				err, records = extractRecords(ctx, llm, &convo, label, template, confidence, consistency, stats, logger)
 
-			if err == nil {
-				response = *result.Content
+				if err == nil {
+					progress.finishChunk(label, records)
+				}
 			}
 
 			channel <- chatResult{
-				err:      err,
-				response: response,
+				index:   index,
+				err:     err,
+				records: records,
 			}
 			wg.Done()
 		}()
@@ -115,22 +451,14 @@ func promptPool(
 		close(channel)
 	}()
 
-	var samples []map[string]interface{}
+	results := make([][]*record, len(convos))
 
 	for chatRes := range channel {
 		<-workerPool // free a space in the worker pool
 		if chatRes.err == nil {
-			jsonData := jsonparser.ToJson(chatRes.response)
-
-			if len(jsonData) > 0 {
-				logger("successfully converted response to json")
-			} else {
-				logger("failed to convert response to json")
-			}
-
-			samples = append(samples, jsonData...)
+			results[chatRes.index] = chatRes.records
 		}
 	}
 
-	return samples
+	return results
 }
@@ -0,0 +1,104 @@
+package fetch
+
+import (
+	"context"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_addExamples(t *testing.T) {
+	examples := []scraper2.Example{
+		{Html: "<li>huan</li>", Output: `[{"name": "huan"}]`},
+		{Html: "<li>beren</li>", Output: `[{"name": "beren"}]`},
+	}
+
+	data := scraper2.PromptData{Task: "collect names", Template: `{"name": ""}`}
+	builder := &messages.ConversationBuilder{}
+
+	if err := addExamples(defaultCollectTemplate, data, examples, builder); err != nil {
+		t.Fatal(err)
+	}
+
+	if builder.Size() != 4 {
+		t.Fatalf("expected 4 turns got %d", builder.Size())
+	}
+
+	if !strings.Contains(builder.ConvertToStandard(2).Content, "<li>beren</li>") {
+		t.Error("example html was not rendered into the collection prompt")
+	}
+
+	if *builder.ConvertToAssistant(3).Content != examples[1].Output {
+		t.Error("example output was not added as an assistant turn")
+	}
+
+	err, withoutExamples := chunkLimit(defaultCollectTemplate, data, nil, nil, chunkTokens)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, withExamples := chunkLimit(defaultCollectTemplate, data, examples, nil, chunkTokens)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withExamples >= withoutExamples {
+		t.Errorf("examples did not reduce the chunk size, %d >= %d", withExamples, withoutExamples)
+	}
+
+	huge := []scraper2.Example{{Html: strings.Repeat("a", chunkTokens*charsPerToken), Output: "[]"}}
+
+	if err, _ = chunkLimit(defaultCollectTemplate, data, huge, nil, chunkTokens); err == nil {
+		t.Error("examples larger than the chunk budget were accepted")
+	}
+
+	visual := &scraper2.Visual{MaxWidth: 1024, TileHeight: 768, MaxTiles: 4, Detail: "high"}
+	err, withTiles := chunkLimit(defaultCollectTemplate, data, nil, visual, chunkTokens)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every tile is a 1024x768 high detail image costing 765 tokens
+	if withoutExamples-withTiles != 4*765*charsPerToken {
+		t.Errorf("the screenshot tiles were not taken out of the chunk, %d and %d", withTiles, withoutExamples)
+	}
+}
+
+func Test_buildChunkConversations_isolated(t *testing.T) {
+	llm := scraper2.GetTestLanguageModel(scraper2.TestModel{WorkTime: time.Duration(0)})
+	chunks := []string{"<li>huan</li>", "<li>beren</li>"}
+
+	prefix := &messages.ConversationBuilder{}
+	prefix.AddStandardMessage(&messages.StandardMessage{Role: "system", Content: "collect dogs"})
+
+	err, convos := buildChunkConversations(
+		context.Background(),
+		defaultCollectTemplate,
+		scraper2.PromptData{Task: "collect names", Template: `{"name": ""}`},
+		&llm,
+		prefix,
+		[]*string{&chunks[0], &chunks[1]},
+		nil,
+		nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prefix.Size() != 1 {
+		t.Errorf("the shared prefix was changed, it has %d messages", prefix.Size())
+	}
+
+	for index, convo := range convos {
+		last := convo[len(convo)-1].(*messages.StandardMessage)
+
+		if len(convo) != 2 || !strings.Contains(last.Content, chunks[index]) {
+			t.Errorf("chunk %d does not have a conversation of its own %v", index, convo)
+		}
+	}
+}
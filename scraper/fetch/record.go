@@ -0,0 +1,126 @@
+package fetch
+
+const (
+	confidenceKey    = "_confidence"    // the sample key holding the confidence of each field
+	lowConfidenceKey = "_lowConfidence" // the sample key listing fields below the confidence threshold
+	agreementKey     = "_agreement"     // the sample key holding the share of completions that agreed on each field
+)
+
+/*
+record
+
+a sample collected by the llm along with the metadata gathered while extracting it
+*/
+type record struct {
+	values     map[string]interface{}
+	confidence map[string]float64
+	uncertain  []string
+	agreement  map[string]float64
+}
+
+/*
+newRecords
+
+wraps parsed json samples in records
+*/
+func newRecords(samples []map[string]interface{}) []*record {
+	records := make([]*record, len(samples))
+
+	for index, sample := range samples {
+		records[index] = &record{
+			values: sample,
+		}
+	}
+
+	return records
+}
+
+/*
+toSample
+
+flattens the record into the map that is written to disk, metadata is stored under underscore prefixed keys
+*/
+func (r *record) toSample() map[string]interface{} {
+	sample := make(map[string]interface{}, len(r.values)+3)
+
+	for k, v := range r.values {
+		sample[k] = v
+	}
+
+	if r.confidence != nil {
+		sample[confidenceKey] = r.confidence
+	}
+
+	if len(r.uncertain) > 0 {
+		sample[lowConfidenceKey] = r.uncertain
+	}
+
+	if r.agreement != nil {
+		sample[agreementKey] = r.agreement
+	}
+
+	return sample
+}
+
+/*
+toSamples
+
+flattens a slice of records
+*/
+func toSamples(records []*record) []map[string]interface{} {
+	samples := make([]map[string]interface{}, len(records))
+
+	for index, rec := range records {
+		samples[index] = rec.toSample()
+	}
+
+	return samples
+}
+
+/*
+fromSample
+
+restores a record from a sample written to disk, the inverse of toSample
+*/
+func fromSample(sample map[string]interface{}) *record {
+	rec := &record{values: make(map[string]interface{}, len(sample))}
+
+	scores := func(value interface{}) map[string]float64 {
+		raw, ok := value.(map[string]interface{})
+
+		if !ok {
+			return nil
+		}
+
+		scores := make(map[string]float64, len(raw))
+
+		for field, score := range raw {
+			if number, ok := score.(float64); ok {
+				scores[field] = number
+			}
+		}
+
+		return scores
+	}
+
+	for key, value := range sample {
+		switch key {
+		case confidenceKey:
+			rec.confidence = scores(value)
+		case agreementKey:
+			rec.agreement = scores(value)
+		case lowConfidenceKey:
+			fields, _ := value.([]interface{})
+
+			for _, field := range fields {
+				if name, ok := field.(string); ok {
+					rec.uncertain = append(rec.uncertain, name)
+				}
+			}
+		default:
+			rec.values[key] = value
+		}
+	}
+
+	return rec
+}
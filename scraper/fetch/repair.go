@@ -0,0 +1,154 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huan/jsonparser"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+)
+
+const (
+	maxRepairs   = 2 // how many times a response that cannot be parsed is sent back to be fixed
+	repairPrompt = "your response could not be parsed as json: %v\n\nreturn valid json matching this template, an " +
+		"array of dictionaries and nothing else:\n%s"
+)
+
+/*
+parseError
+
+why a response could not be converted into samples, nil is returned when samples were found or the response is an
+empty array
+*/
+func parseError(response string) error {
+	if len(jsonparser.ToJson(response)) > 0 {
+		return nil
+	}
+
+	var samples []map[string]interface{}
+	err := json.Unmarshal([]byte(jsonparser.RemoveIdentifier(response)), &samples)
+
+	if err == nil {
+		return nil
+	}
+
+	if response == "" {
+		return errors.New("the response was empty")
+	}
+
+	return err
+}
+
+/*
+repairChoice
+
+sends a response that cannot be parsed back to the llm along with the parse error and the template, until it can be
+parsed or maxRepairs is reached. the latest choice is returned along with how many repairs were requested
+*/
+func repairChoice(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	convo messages.Conversation,
+	choice messages.Choice,
+	template string,
+	logger func(message string)) (error, messages.Choice, int) {
+
+	var attempt int
+
+	for attempt < maxRepairs {
+		var response string
+
+		if choice.Message.Content != nil {
+			response = *choice.Message.Content
+		}
+
+		parseErr := parseError(response)
+
+		if parseErr == nil {
+			return nil, choice, attempt
+		}
+
+		err, builder := messages.NewConversationBuilder(convo)
+
+		if err != nil {
+			return err, choice, attempt
+		}
+
+		if response != "" {
+			builder.AddAssistantMessage(&messages.AssistantMessage{
+				Role:    "assistant",
+				Content: &response,
+			})
+		}
+
+		builder.AddStandardMessage(&messages.StandardMessage{
+			Role:    "user",
+			Content: fmt.Sprintf(repairPrompt, parseErr, template),
+		})
+
+		// the chunk prompt and the response are needed to repair the response, so nothing is truncated
+		if err = llm.CheckBudget(builder); err != nil {
+			return err, choice, attempt
+		}
+
+		if err = llm.Validate(builder); err != nil {
+			return err, choice, attempt
+		}
+
+		err, repair := builder.Build()
+
+		if err != nil {
+			return err, choice, attempt
+		}
+
+		attempt++
+		logger(fmt.Sprintf("failed to convert response to json, requesting repair %d: %v", attempt, parseErr))
+
+		err, completion := llm.Complete(ctx, &repair, nil)
+
+		if err != nil {
+			return err, choice, attempt
+		}
+
+		choice = completion.Choices[0]
+	}
+
+	return nil, choice, attempt
+}
+
+/*
+repairChoices
+
+repairs every choice that cannot be parsed, the number of repairs and their outcome are added to the session stats
+*/
+func repairChoices(
+	ctx context.Context,
+	llm *scraper2.LanguageModel,
+	convo messages.Conversation,
+	choices []messages.Choice,
+	template string,
+	stats *sessionStats,
+	logger func(message string)) {
+
+	for index, choice := range choices {
+		err, repaired, attempts := repairChoice(ctx, llm, convo, choice, template, logger)
+
+		if err != nil {
+			logger(fmt.Sprintf("failed to repair a response that could not be parsed: %v", err))
+		}
+
+		if attempts == 0 {
+			continue
+		}
+
+		var response string
+		if repaired.Message.Content != nil {
+			response = *repaired.Message.Content
+		}
+
+		stats.addRepairs(attempts, parseError(response) == nil)
+		choices[index] = repaired
+	}
+}
@@ -0,0 +1,139 @@
+package fetch
+
+import (
+	"context"
+	"huan/llm/messages"
+	"huan/llm/model/standin"
+	scraper2 "huan/scraper"
+	"strings"
+	"testing"
+)
+
+func Test_parseError(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantErr  bool
+	}{
+		{"samples", `[{"name": "huan"}]`, false},
+		{"fenced samples", "```json\n[{\"name\": \"huan\"}]\n```", false},
+		{"no samples", `[]`, false},
+		{"prose", `the page lists huan and beren`, true},
+		{"broken", `[{"name": "huan"`, true},
+		{"empty", ``, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := parseError(tt.response); (err != nil) != tt.wantErr {
+				t.Errorf("parseError() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func repairServer(fixAfter int) *standin.Server {
+	// replies with prose until fixAfter repair requests have been made
+	return standin.NewServer(func(body map[string]interface{}) string {
+		var repairs int
+
+		for _, mess := range body["messages"].([]interface{}) {
+			content, _ := mess.(map[string]interface{})["content"].(string)
+
+			if strings.HasPrefix(content, "your response could not be parsed") {
+				repairs++
+			}
+		}
+
+		if repairs > 0 && repairs >= fixAfter {
+			return `[{"name": "huan"}]`
+		}
+
+		return "the page lists a dog named huan"
+	})
+}
+
+func Test_extractRecords_repair(t *testing.T) {
+	tests := []struct {
+		name        string
+		fixAfter    int
+		wantRecords int
+		wantStats   [3]int64
+	}{
+		{"repaired", 1, 1, [3]int64{1, 1, 0}},
+		{"unrepaired", maxRepairs + 1, 0, [3]int64{maxRepairs, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := repairServer(tt.fixAfter)
+			defer server.Close()
+
+			err, llm := scraper2.InitLanguageModel(
+				"openai",
+				map[string]interface{}{"apiKey": "test", "model": "gpt-4o", "baseUrl": server.URL},
+				nil, nil, nil, false, nil, nil, nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			builder := &messages.ConversationBuilder{}
+			builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: "collect names"})
+			_, convo := builder.Build()
+
+			stats := &sessionStats{}
+			err, records := extractRecords(
+				context.Background(), llm, &convo, "test", `{"name": ""}`, nil, nil, stats, func(string) {})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(records) != tt.wantRecords {
+				t.Errorf("expected %d records got %d", tt.wantRecords, len(records))
+			}
+
+			got := [3]int64{stats.repairs.Load(), stats.repaired.Load(), stats.unrepaired.Load()}
+			if got != tt.wantStats {
+				t.Errorf("expected repairs, repaired, unrepaired of %v got %v", tt.wantStats, got)
+			}
+		})
+	}
+}
+
+func Test_repairChoice_budget(t *testing.T) {
+	server := standin.NewServer(func(body map[string]interface{}) string {
+		return `[{"name": "huan"}]`
+	})
+	defer server.Close()
+
+	err, llm := scraper2.InitLanguageModel(
+		"openai",
+		map[string]interface{}{"apiKey": "test", "model": "gpt-4o", "baseUrl": server.URL},
+		nil, nil, nil, false, nil, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = llm.SetTruncation("dropOldest"); err != nil {
+		t.Fatal(err)
+	}
+
+	// truncation would remove the chunk prompt and the broken response, leaving only the repair request
+	builder := &messages.ConversationBuilder{}
+	builder.AddStandardMessage(&messages.StandardMessage{Role: "user", Content: strings.Repeat("huan ", 200000)})
+	_, convo := builder.Build()
+
+	broken := `[{"name": huan}]`
+	choice := messages.Choice{FinishReason: "stop", Message: messages.Message{Content: &broken}}
+
+	if err, _, _ = repairChoice(context.Background(), llm, convo, choice, `{"name": ""}`, func(string) {}); err == nil {
+		t.Error("expected a repair over the budget to fail")
+	}
+
+	if server.Requests() != 0 {
+		t.Errorf("expected no requests got %d", server.Requests())
+	}
+}
@@ -0,0 +1,201 @@
+package fetch
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+robotsRule
+
+an allow or disallow line of a robots.txt group
+*/
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+/*
+robotsRules
+
+the part of a robots.txt that applies to a single user agent
+*/
+type robotsRules struct {
+	rules       []robotsRule
+	crawlDelay  time.Duration
+	disallowAll bool // robots.txt could not be read because of a server error, nothing may be fetched
+}
+
+/*
+robotsAgent
+
+the product token robots.txt groups are matched against, the part of the user agent before the first slash or space
+*/
+func robotsAgent(userAgent string) string {
+	agent := strings.ToLower(strings.TrimSpace(userAgent))
+
+	if index := strings.IndexAny(agent, "/ "); index >= 0 {
+		agent = agent[:index]
+	}
+
+	return agent
+}
+
+/*
+parseRobots
+
+reads the rules of a robots.txt that apply to an agent. the most specific group naming the agent is used, falling
+back to the * group. consecutive user-agent lines share the rules that follow them
+*/
+func parseRobots(body io.Reader, agent string) *robotsRules {
+	type group struct {
+		agents []string
+		rules  robotsRules
+	}
+
+	var groups []*group
+	var current *group
+	inAgents := false
+
+	scanner := bufio.NewScanner(body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+
+		if !ok {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+
+			current.agents = append(current.agents, strings.ToLower(value))
+
+		case "allow", "disallow":
+			inAgents = false
+
+			// an empty disallow allows everything
+			if current == nil || value == "" {
+				continue
+			}
+
+			current.rules.rules = append(current.rules.rules, robotsRule{pattern: value, allow: key == "allow"})
+
+		case "crawl-delay":
+			inAgents = false
+
+			if current == nil {
+				continue
+			}
+
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.rules.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	var wildcard, named *robotsRules
+	var namedLength int
+
+	for _, g := range groups {
+		for _, name := range g.agents {
+			if name == "*" {
+				if wildcard == nil {
+					wildcard = &g.rules
+				}
+			} else if strings.Contains(agent, name) && len(name) > namedLength {
+				named, namedLength = &g.rules, len(name)
+			}
+		}
+	}
+
+	if named != nil {
+		return named
+	}
+
+	if wildcard != nil {
+		return wildcard
+	}
+
+	return &robotsRules{}
+}
+
+/*
+robotsMatch
+
+whether a robots.txt pattern matches a path, * matches any characters and a trailing $ anchors the end of the path
+*/
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+
+	rest := path[len(parts[0]):]
+
+	for index, part := range parts[1:] {
+		// the last part of an anchored pattern must sit at the end of the path
+		if anchored && index == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+
+		position := strings.Index(rest, part)
+
+		if position < 0 {
+			return false
+		}
+
+		rest = rest[position+len(part):]
+	}
+
+	return !anchored || rest == ""
+}
+
+/*
+allowed
+
+whether a path may be fetched, the longest matching rule wins and allow wins a tie
+*/
+func (r *robotsRules) allowed(path string) bool {
+	if r.disallowAll {
+		return false
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	allow, length := true, -1
+
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+
+		if len(rule.pattern) > length || (len(rule.pattern) == length && rule.allow) {
+			allow, length = rule.allow, len(rule.pattern)
+		}
+	}
+
+	return allow
+}
@@ -0,0 +1,82 @@
+package fetch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobots = `
+# every other bot
+User-agent: *
+Disallow: /
+
+User-agent: googlebot
+User-agent: huan
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Crawl-delay: 2.5
+
+User-agent: huan-images
+Disallow: /dogs
+`
+
+func Test_parseRobots(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent string
+		path  string
+		want  bool
+	}{
+		{"unknown agents use the wildcard group", "tevildo", "/dogs", false},
+		{"named group", "huan", "/dogs", true},
+		{"disallowed prefix", "huan", "/private/huan", false},
+		{"longer allow wins", "huan", "/private/public/huan", true},
+		{"anchored wildcard", "huan", "/dogs/huan.pdf", false},
+		{"anchor stops at the end", "huan", "/dogs/huan.pdf.html", true},
+		{"most specific agent", "huan-images", "/dogs", false},
+		{"most specific agent allows the rest", "huan-images", "/private", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(testRobots), tt.agent)
+
+			if got := rules.allowed(tt.path); got != tt.want {
+				t.Errorf("allowed(%s) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if delay := parseRobots(strings.NewReader(testRobots), "huan").crawlDelay; delay != 2500*time.Millisecond {
+		t.Errorf("expected a crawl delay of 2.5s got %v", delay)
+	}
+}
+
+func Test_robotsAgent(t *testing.T) {
+	if got := robotsAgent("Huan/1.0 (compatible; data collection bot)"); got != "huan" {
+		t.Errorf("unexpected agent %s", got)
+	}
+}
+
+func Test_robotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/dogs", "/dogs/huan", true},
+		{"/dogs$", "/dogs/huan", false},
+		{"/dogs$", "/dogs", true},
+		{"/*/huan", "/dogs/huan", true},
+		{"/*/huan", "/huan", false},
+		{"*.php", "/index.php?id=1", true},
+	}
+
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%s, %s) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
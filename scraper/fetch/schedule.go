@@ -0,0 +1,203 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+sampleStore
+
+the samples of a session, samples are only accepted until the limit is reached
+*/
+type sampleStore struct {
+	lock    sync.Mutex
+	samples []map[string]interface{}
+	limit   int
+	full    chan struct{} // closed once the limit is reached
+}
+
+/*
+newSampleStore
+
+creates a sample store holding the samples collected before the session was resumed
+*/
+func newSampleStore(limit int, resumed []map[string]interface{}) *sampleStore {
+	store := &sampleStore{
+		samples: make([]map[string]interface{}, 0, limit),
+		limit:   limit,
+		full:    make(chan struct{}),
+	}
+
+	store.add(resumed)
+	return store
+}
+
+/*
+add
+
+adds as many samples as fit within the limit and returns the ones that were accepted
+*/
+func (s *sampleStore) add(samples []map[string]interface{}) []map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.samples) >= s.limit {
+		return nil
+	}
+
+	samples = samples[:min(len(samples), s.limit-len(s.samples))]
+	s.samples = append(s.samples, samples...)
+
+	if len(s.samples) >= s.limit {
+		close(s.full)
+	}
+
+	return samples
+}
+
+/*
+isFull
+
+whether the limit was reached
+*/
+func (s *sampleStore) isFull() bool {
+	select {
+	case <-s.full:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+snapshot
+
+a copy of the samples collected so far
+*/
+func (s *sampleStore) snapshot() []map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	samples := make([]map[string]interface{}, len(s.samples))
+	copy(samples, s.samples)
+
+	return samples
+}
+
+/*
+schedule
+
+visits the seed targets and every target found while visiting them with a bounded pool of workers. it returns once
+every target was visited or ctx ends. closing stop drops the queued targets and the targets in progress have the
+grace period to finish. visit must return once its context is cancelled
+*/
+func schedule(
+	ctx context.Context,
+	stop <-chan struct{},
+	grace time.Duration,
+	workers uint8,
+	seeds []crawlTarget,
+	visit func(ctx context.Context, target crawlTarget) []crawlTarget,
+	logger func(message string)) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan crawlTarget)
+	results := make(chan []crawlTarget)
+	pool := sync.WaitGroup{}
+
+	for range max(workers, 1) {
+		pool.Add(1)
+
+		go func() {
+			defer pool.Done()
+
+			for target := range jobs {
+				found := visit(ctx, target)
+
+				select {
+				case results <- found:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	// only this loop touches the queue so the targets found by the workers need no lock
+	queue := append([]crawlTarget(nil), seeds...)
+	inProgress := 0
+	stopping, stopped := stop, false
+	var timeout <-chan time.Time
+
+	for len(queue)+inProgress > 0 && ctx.Err() == nil {
+		// a nil channel is never selected so nothing is handed out while the queue is empty
+		var next chan crawlTarget
+		var head crawlTarget
+
+		if len(queue) > 0 {
+			next, head = jobs, queue[0]
+		}
+
+		select {
+		case next <- head:
+			queue = queue[1:]
+			inProgress++
+
+		case found := <-results:
+			inProgress--
+
+			if !stopped {
+				queue = append(queue, found...)
+			}
+
+		case <-stopping:
+			logger(fmt.Sprintf(
+				"stopping the session, %d queued urls were dropped and the %d urls in progress have %v to finish",
+				len(queue),
+				inProgress,
+				grace))
+
+			queue, stopping, stopped = nil, nil, true
+			timeout = time.After(grace)
+
+		case <-timeout:
+			logger("the grace period is over, stopping the urls in progress")
+			cancel()
+
+		case <-ctx.Done():
+		}
+	}
+
+	close(jobs)
+	cancel()
+
+	// the browsers of the urls in progress are closed before the session ends so none are left behind
+	if !waitFor(&pool, teardownTimeout) {
+		logger("some urls did not stop in time, their results are not included")
+	}
+}
+
+/*
+waitFor
+
+waits for a wait group to finish, false is returned if it did not finish within the timeout
+*/
+func waitFor(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
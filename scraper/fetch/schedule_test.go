@@ -0,0 +1,154 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_sampleStore(t *testing.T) {
+	store := newSampleStore(1000, []map[string]interface{}{{"name": "huan"}})
+	accepted := atomic.Int64{}
+	wg := sync.WaitGroup{}
+
+	for worker := range 100 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for page := range 20 {
+				samples := []map[string]interface{}{{"worker": worker, "page": page}, {"worker": worker}}
+				accepted.Add(int64(len(store.add(samples))))
+				_ = store.isFull()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if samples := store.snapshot(); len(samples) != 1000 || accepted.Load() != 999 {
+		t.Errorf("expected exactly 1000 samples got %d, %d were accepted", len(samples), accepted.Load())
+	}
+
+	if !store.isFull() {
+		t.Error("the store is not full")
+	}
+
+	if resumed := newSampleStore(2, []map[string]interface{}{{}, {}, {}}); !resumed.isFull() {
+		t.Error("a store resumed past its limit is not full")
+	}
+}
+
+func Test_schedule(t *testing.T) {
+	var visited sync.Map
+	var active, peak, visits atomic.Int64
+
+	visit := func(ctx context.Context, target crawlTarget) []crawlTarget {
+		visits.Add(1)
+		now := active.Add(1)
+		defer active.Add(-1)
+
+		for old := peak.Load(); now > old && !peak.CompareAndSwap(old, now); old = peak.Load() {
+		}
+
+		if _, loaded := visited.LoadOrStore(target.url, struct{}{}); loaded {
+			t.Errorf("%s was visited twice", target.url)
+		}
+
+		time.Sleep(time.Millisecond)
+
+		if target.depth == 3 {
+			return nil
+		}
+
+		// every page links to three more pages
+		found := make([]crawlTarget, 3)
+		for index := range found {
+			found[index] = crawlTarget{url: fmt.Sprintf("%s/%d", target.url, index), depth: target.depth + 1}
+		}
+
+		return found
+	}
+
+	seeds := make([]crawlTarget, 10)
+	for index := range seeds {
+		seeds[index] = crawlTarget{url: fmt.Sprintf("https://huan.dev/%d", index)}
+	}
+
+	schedule(context.Background(), nil, time.Second, 4, seeds, visit, func(string) {})
+
+	// 10 seeds with 3 + 9 + 27 pages below each
+	if visits.Load() != 400 {
+		t.Errorf("expected 400 visits got %d", visits.Load())
+	}
+
+	if peak.Load() > 4 {
+		t.Errorf("%d pages were visited at once with 4 workers", peak.Load())
+	}
+}
+
+func Test_schedule_stop(t *testing.T) {
+	stop := make(chan struct{})
+	var visits atomic.Int64
+	var once sync.Once
+
+	visit := func(ctx context.Context, target crawlTarget) []crawlTarget {
+		visits.Add(1)
+		once.Do(func() { close(stop) })
+
+		// the urls in progress keep running until the grace period is over
+		<-ctx.Done()
+		return []crawlTarget{{url: target.url + "/next"}}
+	}
+
+	seeds := make([]crawlTarget, 100)
+	for index := range seeds {
+		seeds[index] = crawlTarget{url: fmt.Sprintf("https://huan.dev/%d", index)}
+	}
+
+	started := time.Now()
+	schedule(context.Background(), stop, 50*time.Millisecond, 2, seeds, visit, func(string) {})
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("the stopped schedule ran for %v", elapsed)
+	}
+
+	if visits.Load() > 2 {
+		t.Errorf("%d urls were visited after stopping", visits.Load())
+	}
+}
+
+func Test_schedule_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var visits atomic.Int64
+
+	visit := func(ctx context.Context, target crawlTarget) []crawlTarget {
+		if visits.Add(1) == 5 {
+			cancel()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Millisecond):
+		}
+
+		return []crawlTarget{{url: target.url + "/next"}}
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		schedule(ctx, nil, time.Minute, 3, []crawlTarget{{url: "https://huan.dev"}}, visit, func(string) {})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the schedule did not end when its context was cancelled")
+	}
+}
@@ -0,0 +1,249 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"github.com/chromedp/chromedp"
+	"huan/chrome"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"image"
+	"text/template"
+	"time"
+)
+
+/*
+screenOffsets
+
+the scroll positions of every screenshot of a page, consecutive screenshots share overlap of the viewport. the last
+screenshot is aligned with the bottom of the page, at most maxScreens offsets are returned
+*/
+func screenOffsets(viewport, scrollHeight int64, overlap float64, maxScreens int) []int64 {
+	if viewport <= 0 || maxScreens <= 0 {
+		return nil
+	}
+
+	step := max(int64(float64(viewport)*(1-overlap)), 1)
+	bottom := max(scrollHeight-viewport, 0)
+	offsets := []int64{0}
+
+	for offset := step; offset < bottom && len(offsets) < maxScreens; offset += step {
+		offsets = append(offsets, offset)
+	}
+
+	if offsets[len(offsets)-1] < bottom && len(offsets) < maxScreens {
+		offsets = append(offsets, bottom)
+	}
+
+	return offsets
+}
+
+/*
+captureScreens
+
+scrolls through the page taking a screenshot of the viewport at every offset, each screenshot is scaled to the
+configured width and compressed into a jpeg
+*/
+func captureScreens(ctx context.Context, screens *scraper2.Screenshots) (error, [][]byte) {
+	var viewport, scrollHeight int64
+
+	if err := chrome.PageMetrics(&viewport, &scrollHeight).Do(ctx); err != nil {
+		return err, nil
+	}
+
+	offsets := screenOffsets(viewport, scrollHeight, screens.Overlap, int(screens.MaxScreens))
+	captured := make([][]byte, 0, len(offsets))
+
+	for _, offset := range offsets {
+		var buffer []byte
+
+		if err := chrome.ScrollToPixel(0, uint32(offset)).Do(ctx); err != nil {
+			return err, nil
+		}
+
+		if err := chromedp.Sleep(time.Duration(screens.Settle) * time.Millisecond).Do(ctx); err != nil {
+			return err, nil
+		}
+
+		if err := chrome.TakeViewportScreenshot(100, &buffer).Do(ctx); err != nil {
+			return err, nil
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(buffer))
+
+		if err != nil {
+			return err, nil
+		}
+
+		err, screen := encodeTile(resizeToWidth(img, int(screens.MaxWidth)), int(screens.Quality))
+
+		if err != nil {
+			return err, nil
+		}
+
+		captured = append(captured, screen)
+	}
+
+	return nil, captured
+}
+
+/*
+screenConversations
+
+captures the page as overlapping screenshots and builds a conversation for every run of screenshots, each one ends
+with the collection prompt sent along with its screenshots. the conversations start with a copy of builder, it is
+left unchanged
+*/
+func screenConversations(
+	ctx context.Context,
+	prompt *template.Template,
+	systemPrompt *template.Template,
+	data scraper2.PromptData,
+	llm *scraper2.LanguageModel,
+	builder *messages.ConversationBuilder,
+	screens *scraper2.Screenshots) (error, []messages.Conversation) {
+
+	err, captured := captureScreens(ctx, screens)
+
+	if err != nil {
+		return err, nil
+	}
+
+	groups := groupScreens(captured, int(screens.PerRequest))
+	data.ChunkCount = len(groups)
+
+	err, content := scraper2.RenderPrompt(systemPrompt, data)
+
+	if err != nil {
+		return err, nil
+	}
+
+	builder = builder.Clone()
+	builder.AddStandardMessage(&messages.StandardMessage{
+		Role:    "system",
+		Content: content,
+	})
+
+	// every request is sent without html
+	strs := make([]*string, len(groups))
+	for index := range strs {
+		strs[index] = new(string)
+	}
+
+	return buildChunkConversations(ctx, prompt, data, llm, builder, strs, groups, &scraper2.Visual{Detail: screens.Detail})
+}
+
+/*
+groupScreens
+
+splits the screenshots of a page into runs of consecutive screenshots, every run is sent in its own request
+*/
+func groupScreens(screens [][]byte, perRequest int) [][][]byte {
+	var groups [][][]byte
+
+	for start := 0; start < len(screens); start += perRequest {
+		groups = append(groups, screens[start:min(start+perRequest, len(screens))])
+	}
+
+	return groups
+}
+
+/*
+isBlank
+
+whether a value holds nothing, a sample cut off by the edge of a screenshot often has blank fields
+*/
+func isBlank(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	}
+
+	return false
+}
+
+/*
+isDuplicate
+
+whether two records describe the same sample, they must not disagree on any field both filled in and must agree on at
+least half of the fields filled in between them
+*/
+func isDuplicate(a, b *record) bool {
+	var agree, fields int
+
+	for field, value := range a.values {
+		if !isBlank(value) {
+			fields++
+		}
+
+		other, ok := b.values[field]
+
+		if isBlank(value) || !ok || isBlank(other) {
+			continue
+		}
+
+		if valueKey(value) != valueKey(other) {
+			return false
+		}
+
+		agree++
+	}
+
+	for field, value := range b.values {
+		if other, ok := a.values[field]; !isBlank(value) && (!ok || isBlank(other)) {
+			fields++
+		}
+	}
+
+	return agree > 0 && agree*2 >= fields
+}
+
+/*
+mergeOverlapping
+
+merges the records of consecutive screenshot requests, a sample cut in two or repeated by the overlap between
+screenshots is only kept once. records are only compared with those of the previous request, so identical samples
+found in the same request are all kept
+*/
+func mergeOverlapping(groups [][]*record) []*record {
+	var merged []*record
+	var previous []*record
+
+	for _, group := range groups {
+		matched := make(map[*record]bool, len(previous))
+		current := make([]*record, 0, len(group))
+
+		for _, rec := range group {
+			var duplicate *record
+
+			for _, prev := range previous {
+				if !matched[prev] && isDuplicate(prev, rec) {
+					duplicate = prev
+					break
+				}
+			}
+
+			if duplicate == nil {
+				merged = append(merged, rec)
+				current = append(current, rec)
+				continue
+			}
+
+			// fill in the fields the previous screenshot could not see
+			for field, value := range rec.values {
+				if isBlank(duplicate.values[field]) {
+					duplicate.values[field] = value
+				}
+			}
+
+			matched[duplicate] = true
+			current = append(current, duplicate)
+		}
+
+		previous = current
+	}
+
+	return merged
+}
@@ -0,0 +1,107 @@
+package fetch
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_screenOffsets(t *testing.T) {
+	tests := []struct {
+		name         string
+		viewport     int64
+		scrollHeight int64
+		overlap      float64
+		maxScreens   int
+		want         []int64
+	}{
+		{"short page", 800, 600, 0.2, 10, []int64{0}},
+		{"exact fit", 1000, 1000, 0.2, 10, []int64{0}},
+		{"aligned to the bottom", 1000, 2500, 0.2, 10, []int64{0, 800, 1500}},
+		{"no overlap", 1000, 3000, 0, 10, []int64{0, 1000, 2000}},
+		{"capped", 1000, 10_000, 0.5, 3, []int64{0, 500, 1000}},
+		{"no viewport", 0, 1000, 0.2, 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := screenOffsets(tt.viewport, tt.scrollHeight, tt.overlap, tt.maxScreens)
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("screenOffsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_groupScreens(t *testing.T) {
+	screens := [][]byte{{0}, {1}, {2}, {3}, {4}}
+	groups := groupScreens(screens, 2)
+
+	if len(groups) != 3 || len(groups[0]) != 2 || len(groups[2]) != 1 {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+
+	if groups[2][0][0] != 4 {
+		t.Errorf("the last screenshot was not kept in order")
+	}
+}
+
+func Test_mergeOverlapping(t *testing.T) {
+	groups := [][]*record{
+		newRecords([]map[string]interface{}{
+			{"name": "huan", "price": 10.0, "rating": nil},
+			{"name": "beren", "price": 12.0},
+		}),
+		newRecords([]map[string]interface{}{
+			// the same sample seen again in the overlap, this time with its rating visible
+			{"name": "beren", "price": 12.0, "rating": 4.0},
+			{"name": "luthien", "price": 12.0},
+			// identical samples from the same screenshot are distinct
+			{"name": "luthien", "price": 12.0},
+		}),
+		newRecords([]map[string]interface{}{
+			// only consecutive screenshots overlap, huan is a new sample here
+			{"name": "huan", "price": 10.0},
+			{"name": "luthien", "price": 12.0},
+		}),
+	}
+
+	merged := mergeOverlapping(groups)
+
+	if len(merged) != 5 {
+		t.Fatalf("expected 5 samples got %d %v", len(merged), toSamples(merged))
+	}
+
+	if merged[1].values["rating"] != 4.0 {
+		t.Errorf("the rating seen in the overlap was not merged %v", merged[1].values)
+	}
+
+	if merged[0].values["rating"] != nil {
+		t.Errorf("an unrelated sample was merged %v", merged[0].values)
+	}
+}
+
+func Test_isDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		a    map[string]interface{}
+		b    map[string]interface{}
+		want bool
+	}{
+		{"equal", map[string]interface{}{"name": "huan"}, map[string]interface{}{"name": "huan"}, true},
+		{"conflict", map[string]interface{}{"name": "huan", "price": 1.0}, map[string]interface{}{"name": "huan", "price": 2.0}, false},
+		{"cut off", map[string]interface{}{"name": "huan", "price": ""}, map[string]interface{}{"name": "huan", "price": 2.0}, true},
+		{"too little in common", map[string]interface{}{"type": "dog"}, map[string]interface{}{"type": "dog", "name": "huan", "age": 3.0}, false},
+		{"nothing in common", map[string]interface{}{"name": nil}, map[string]interface{}{"name": nil}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := &record{values: tt.a}, &record{values: tt.b}
+
+			if got := isDuplicate(a, b); got != tt.want {
+				t.Errorf("isDuplicate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package fetch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	scraper2 "huan/scraper"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sitemapTimeout  = 30 * time.Second // how long fetching a single sitemap can take
+	maxSitemapDepth = 3                // how many sitemap indexes can be nested
+	maxSitemapSize  = 50 << 20         // the largest uncompressed sitemap allowed by the sitemap protocol
+)
+
+// a numeric or date range inside a url eg: {1..50}, {01..20..2} or {2024-01-01..2024-01-31..7}
+var rangePattern = regexp.MustCompile(
+	`\{([0-9]+|[0-9]{4}-[0-9]{2}-[0-9]{2})\.\.([0-9]+|[0-9]{4}-[0-9]{2}-[0-9]{2})(?:\.\.([0-9]+))?}`)
+
+/*
+rangeValues
+
+every value of a template range, numbers starting with a zero are padded to the width of the start and the step of
+a date range is in days. a range whose start is after its end counts down
+*/
+func rangeValues(start, end, rawStep string, emit func(value string) bool) error {
+	step := 1
+
+	if rawStep != "" {
+		step, _ = strconv.Atoi(rawStep)
+	}
+
+	if step <= 0 {
+		return fmt.Errorf("the range {%s..%s..%s} has a step of 0", start, end, rawStep)
+	}
+
+	const dateLayout = "2006-01-02"
+
+	if strings.Contains(start, "-") != strings.Contains(end, "-") {
+		return fmt.Errorf("the range {%s..%s} mixes a date and a number", start, end)
+	}
+
+	if strings.Contains(start, "-") {
+		from, err := time.Parse(dateLayout, start)
+
+		if err != nil {
+			return err
+		}
+
+		to, err := time.Parse(dateLayout, end)
+
+		if err != nil {
+			return err
+		}
+
+		descending := to.Before(from)
+
+		if descending {
+			step = -step
+		}
+
+		for day := from; descending && !day.Before(to) || !descending && !day.After(to); day = day.AddDate(0, 0, step) {
+			if !emit(day.Format(dateLayout)) {
+				return nil
+			}
+		}
+
+		return nil
+	}
+
+	from, err := strconv.Atoi(start)
+
+	if err != nil {
+		return err
+	}
+
+	to, err := strconv.Atoi(end)
+
+	if err != nil {
+		return err
+	}
+
+	width := 0
+
+	if len(start) > 1 && strings.HasPrefix(start, "0") {
+		width = len(start)
+	}
+
+	if to < from {
+		step = -step
+	}
+
+	for number := from; number >= min(from, to) && number <= max(from, to); number += step {
+		if !emit(fmt.Sprintf("%0*d", width, number)) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+/*
+expandTemplate
+
+emits every url a url template describes, several ranges expand to every combination of their values. expansion stops
+early once emit returns false. urls without ranges are emitted as they are
+*/
+func expandTemplate(raw string, emit func(url string) bool) error {
+	match := rangePattern.FindStringSubmatchIndex(raw)
+
+	if match == nil {
+		emit(raw)
+		return nil
+	}
+
+	prefix, suffix := raw[:match[0]], raw[match[1]:]
+	group := func(index int) string {
+		if match[2*index] < 0 {
+			return ""
+		}
+
+		return raw[match[2*index]:match[2*index+1]]
+	}
+
+	var expandErr error
+	stopped := false
+
+	err := rangeValues(group(1), group(2), group(3), func(value string) bool {
+		// the suffix can hold more ranges so it is expanded for every value
+		expandErr = expandTemplate(prefix+value+suffix, func(url string) bool {
+			if !emit(url) {
+				stopped = true
+			}
+
+			return !stopped
+		})
+
+		return expandErr == nil && !stopped
+	})
+
+	if err != nil {
+		return fmt.Errorf("cannot expand %s: %v", raw, err)
+	}
+
+	return expandErr
+}
+
+/*
+readUrlFile
+
+reads the urls of a text file with a url per line or of a csv file with a header row naming the url column. blank
+lines and lines starting with # are skipped
+*/
+func readUrlFile(path, column string) (error, []string) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err, nil
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var urls []string
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+
+		header, err := reader.Read()
+
+		if err != nil {
+			return fmt.Errorf("cannot read the header of %s: %v", path, err), nil
+		}
+
+		index := slices.IndexFunc(header, func(name string) bool {
+			return strings.EqualFold(strings.TrimSpace(name), column)
+		})
+
+		if index < 0 {
+			return fmt.Errorf("%s has no %s column", path, column), nil
+		}
+
+		for {
+			record, err := reader.Read()
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+				return fmt.Errorf("cannot read %s: %v", path, err), nil
+			}
+
+			if index < len(record) && strings.TrimSpace(record[index]) != "" {
+				urls = append(urls, strings.TrimSpace(record[index]))
+			}
+		}
+
+		return nil, urls
+	}
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	return scanner.Err(), urls
+}
+
+/*
+parseSitemap
+
+reads the page urls of a sitemap or the sitemap urls of a sitemap index, gzipped sitemaps are decompressed
+*/
+func parseSitemap(body io.Reader) (err error, pages []string, sitemaps []string) {
+	reader := bufio.NewReader(body)
+
+	// gzip is recognised by its magic number as servers often send .gz files without a content encoding
+	if magic, _ := reader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipped, err := gzip.NewReader(reader)
+
+		if err != nil {
+			return err, nil, nil
+		}
+
+		defer func() { _ = unzipped.Close() }()
+		reader = bufio.NewReader(unzipped)
+	}
+
+	var document struct {
+		Urls []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+
+	if err := xml.NewDecoder(io.LimitReader(reader, maxSitemapSize)).Decode(&document); err != nil {
+		return fmt.Errorf("the sitemap is not valid xml: %v", err), nil, nil
+	}
+
+	for _, page := range document.Urls {
+		if loc := strings.TrimSpace(page.Loc); loc != "" {
+			pages = append(pages, loc)
+		}
+	}
+
+	for _, sitemap := range document.Sitemaps {
+		if loc := strings.TrimSpace(sitemap.Loc); loc != "" {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+
+	return nil, pages, sitemaps
+}
+
+/*
+seedList
+
+the deduplicated urls a session starts with, urls are added until the limit is reached
+*/
+type seedList struct {
+	match   *regexp.Regexp
+	limit   int
+	seen    map[string]struct{}
+	urls    []string
+	skipped int // urls dropped because they were invalid or did not match
+}
+
+/*
+add
+
+adds a url unless it was seen before or does not match, false is returned once the list is full
+*/
+func (l *seedList) add(raw string) bool {
+	if len(l.urls) >= l.limit {
+		return false
+	}
+
+	err, key := canonicalUrl(raw, nil)
+
+	if err != nil || (l.match != nil && !l.match.MatchString(raw)) {
+		l.skipped++
+		return true
+	}
+
+	if _, ok := l.seen[key]; !ok {
+		l.seen[key] = struct{}{}
+		l.urls = append(l.urls, raw)
+	}
+
+	return len(l.urls) < l.limit
+}
+
+/*
+openSitemap
+
+opens a sitemap from a url or a local file
+*/
+func openSitemap(ctx context.Context, client *http.Client, location, userAgent string) (error, io.ReadCloser) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		file, err := os.Open(location)
+		return err, file
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+
+	if err != nil {
+		return err, nil
+	}
+
+	request.Header.Set("User-Agent", userAgent)
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		_ = response.Body.Close()
+		return fmt.Errorf("%s returned %d", location, response.StatusCode), nil
+	}
+
+	return nil, response.Body
+}
+
+/*
+seedUrls
+
+expands the templates in the session urls, the url files and the sitemaps into the urls the session starts with.
+duplicates are dropped and expansion stops once the url limit is reached. a sitemap that cannot be read is logged
+and skipped while a bad template or url file ends the session
+*/
+func seedUrls(ctx context.Context, settings *scraper2.Fetch, logger func(message string)) (error, []string) {
+	seeds := settings.Seeds
+
+	if seeds == nil {
+		seeds = &scraper2.Seeds{Column: "url", MaxUrls: 10_000}
+	}
+
+	list := &seedList{match: seeds.Match, limit: int(seeds.MaxUrls), seen: map[string]struct{}{}}
+	full := false
+
+	addAll := func(raw []string) error {
+		for _, template := range raw {
+			if full {
+				return nil
+			}
+
+			err := expandTemplate(template, func(url string) bool {
+				full = !list.add(url)
+				return !full
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := addAll(settings.Urls); err != nil {
+		return err, nil
+	}
+
+	for _, path := range seeds.Files {
+		err, urls := readUrlFile(path, seeds.Column)
+
+		if err != nil {
+			return err, nil
+		}
+
+		if err := addAll(urls); err != nil {
+			return err, nil
+		}
+	}
+
+	var userAgent string
+	if settings.Politeness != nil {
+		userAgent = settings.Politeness.UserAgent
+	}
+
+	client := &http.Client{Timeout: sitemapTimeout}
+	visited := map[string]struct{}{}
+
+	var readSitemap func(location string, depth int)
+	readSitemap = func(location string, depth int) {
+		if _, ok := visited[location]; ok || full || ctx.Err() != nil {
+			return
+		}
+
+		visited[location] = struct{}{}
+
+		if depth > maxSitemapDepth {
+			logger(fmt.Sprintf("skipping sitemap %s, sitemap indexes are nested too deeply", location))
+			return
+		}
+
+		err, body := openSitemap(ctx, client, location, userAgent)
+
+		if err != nil {
+			logger(fmt.Sprintf("skipping sitemap %s: %v", location, err))
+			return
+		}
+
+		err, pages, sitemaps := parseSitemap(body)
+		_ = body.Close()
+
+		if err != nil {
+			logger(fmt.Sprintf("skipping sitemap %s: %v", location, err))
+			return
+		}
+
+		for _, page := range pages {
+			if full = !list.add(page); full {
+				break
+			}
+		}
+
+		for _, sitemap := range sitemaps {
+			readSitemap(sitemap, depth+1)
+		}
+	}
+
+	for _, sitemap := range seeds.Sitemaps {
+		readSitemap(sitemap, 0)
+	}
+
+	if full {
+		logger(fmt.Sprintf("the seed urls were cut off at the limit of %d urls", seeds.MaxUrls))
+	}
+
+	if list.skipped > 0 {
+		logger(fmt.Sprintf("skipped %d seed urls that were invalid or did not match", list.skipped))
+	}
+
+	if len(list.urls) == 0 {
+		return errors.New("no urls were found to fetch"), nil
+	}
+
+	logger(fmt.Sprintf("starting with %d urls", len(list.urls)))
+
+	return nil, list.urls
+}
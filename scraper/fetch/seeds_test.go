@@ -0,0 +1,222 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	scraper2 "huan/scraper"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_expandTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+		wantErr  bool
+	}{
+		{"no range", "https://huan.dev/dogs", []string{"https://huan.dev/dogs"}, false},
+		{"numbers", "https://huan.dev/page/{1..3}", []string{
+			"https://huan.dev/page/1", "https://huan.dev/page/2", "https://huan.dev/page/3"}, false},
+		{"padded with a step", "https://huan.dev/{08..12..2}", []string{
+			"https://huan.dev/08", "https://huan.dev/10", "https://huan.dev/12"}, false},
+		{"counting down", "https://huan.dev/{3..1}", []string{
+			"https://huan.dev/3", "https://huan.dev/2", "https://huan.dev/1"}, false},
+		{"dates", "https://huan.dev/{2024-02-28..2024-03-01}", []string{
+			"https://huan.dev/2024-02-28", "https://huan.dev/2024-02-29", "https://huan.dev/2024-03-01"}, false},
+		{"several ranges", "https://huan.dev/{1..2}/{1..2}", []string{
+			"https://huan.dev/1/1", "https://huan.dev/1/2", "https://huan.dev/2/1", "https://huan.dev/2/2"}, false},
+		{"other braces", "https://huan.dev/{dogs}", []string{"https://huan.dev/{dogs}"}, false},
+		{"zero step", "https://huan.dev/{1..3..0}", nil, true},
+		{"mixed range", "https://huan.dev/{1..2024-01-01}", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+
+			err := expandTemplate(tt.template, func(url string) bool {
+				got = append(got, url)
+				return true
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_expandTemplate_stop(t *testing.T) {
+	count := 0
+
+	err := expandTemplate("https://huan.dev/{1..1000000}/{1..1000000}", func(string) bool {
+		count++
+		return count < 5
+	})
+
+	if err != nil || count != 5 {
+		t.Errorf("expansion did not stop when asked, %d urls %v", count, err)
+	}
+}
+
+func Test_readUrlFile(t *testing.T) {
+	dir := t.TempDir()
+
+	text := filepath.Join(dir, "urls.txt")
+	_ = os.WriteFile(text, []byte("# dogs\nhttps://huan.dev/huan\n\n  https://huan.dev/beren  \n"), 0644)
+
+	table := filepath.Join(dir, "urls.csv")
+	_ = os.WriteFile(table, []byte("name,URL\nhuan,https://huan.dev/huan\nluthien,\nberen,https://huan.dev/beren\n"), 0644)
+
+	want := []string{"https://huan.dev/huan", "https://huan.dev/beren"}
+
+	for _, path := range []string{text, table} {
+		err, urls := readUrlFile(path, "url")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(urls, want) {
+			t.Errorf("%s: expected %v got %v", filepath.Base(path), want, urls)
+		}
+	}
+
+	if err, _ := readUrlFile(table, "link"); err == nil {
+		t.Error("a missing csv column was not reported")
+	}
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = writer.Close()
+	return buffer.Bytes()
+}
+
+func Test_parseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://huan.dev/huan </loc><lastmod>2024-01-01</lastmod></url>
+  <url><loc>https://huan.dev/beren</loc></url>
+</urlset>`
+
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://huan.dev/dogs.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+	for name, body := range map[string][]byte{"plain": []byte(urlset), "gzip": gzipped(t, urlset)} {
+		err, pages, sitemaps := parseSitemap(bytes.NewReader(body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(pages, []string{"https://huan.dev/huan", "https://huan.dev/beren"}) || len(sitemaps) != 0 {
+			t.Errorf("%s: unexpected pages %v and sitemaps %v", name, pages, sitemaps)
+		}
+	}
+
+	err, pages, sitemaps := parseSitemap(strings.NewReader(index))
+
+	if err != nil || len(pages) != 0 || !slices.Equal(sitemaps, []string{"https://huan.dev/dogs.xml.gz"}) {
+		t.Errorf("unexpected index pages %v sitemaps %v %v", pages, sitemaps, err)
+	}
+
+	if err, _, _ := parseSitemap(strings.NewReader("<html><body>huan")); err == nil {
+		t.Error("an invalid sitemap was not reported")
+	}
+}
+
+func Test_seedUrls(t *testing.T) {
+	var server *httptest.Server
+	var agent string
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.UserAgent()
+
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/dogs.xml.gz</loc></sitemap>
+<sitemap><loc>%[1]s/missing.xml</loc></sitemap></sitemapindex>`, server.URL)
+		case "/dogs.xml.gz":
+			_, _ = w.Write(gzipped(t, `<urlset>
+<url><loc>https://huan.dev/page/2/</loc></url>
+<url><loc>https://huan.dev/dogs/huan</loc></url>
+<url><loc>https://huan.dev/cats/tevildo</loc></url>
+<url><loc>https://huan.dev/dogs/beren</loc></url>
+</urlset>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	settings := &scraper2.Fetch{
+		Urls:       []string{"https://huan.dev/page/{1..3}"},
+		Politeness: &scraper2.Politeness{UserAgent: "huan-test/1.0"},
+		Seeds: &scraper2.Seeds{
+			Sitemaps: []string{server.URL + "/sitemap.xml"},
+			Match:    regexp.MustCompile(`/(page|dogs)/`),
+			MaxUrls:  10,
+		},
+	}
+
+	var logs []string
+	err, urls := seedUrls(context.Background(), settings, func(message string) { logs = append(logs, message) })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"https://huan.dev/page/1",
+		"https://huan.dev/page/2",
+		"https://huan.dev/page/3",
+		"https://huan.dev/dogs/huan",
+		"https://huan.dev/dogs/beren",
+	}
+
+	if !slices.Equal(urls, want) {
+		t.Errorf("expected %v got %v", want, urls)
+	}
+
+	if agent != "huan-test/1.0" {
+		t.Errorf("the sitemap was requested as %s", agent)
+	}
+
+	if !slices.ContainsFunc(logs, func(message string) bool { return strings.Contains(message, "missing.xml") }) {
+		t.Errorf("the missing sitemap was not logged %v", logs)
+	}
+
+	settings.Seeds.MaxUrls = 2
+
+	if err, urls = seedUrls(context.Background(), settings, func(string) {}); err != nil || len(urls) != 2 {
+		t.Errorf("the url limit was not applied %v %v", urls, err)
+	}
+
+	settings.Urls = nil
+	settings.Seeds.Sitemaps = nil
+
+	if err, _ = seedUrls(context.Background(), settings, func(string) {}); err == nil {
+		t.Error("a session without urls was not reported")
+	}
+}
@@ -0,0 +1,65 @@
+package fetch
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+sessionStats
+
+counts what happened during a fetch session so it can be summarised once the session ends, a nil sessionStats
+counts nothing
+*/
+type sessionStats struct {
+	urls       atomic.Int64 // urls whose data was collected
+	repairs    atomic.Int64 // repair requests sent for responses that could not be parsed
+	repaired   atomic.Int64 // responses that could be parsed after being repaired
+	unrepaired atomic.Int64 // responses that still could not be parsed after every repair
+}
+
+/*
+addUrl
+
+counts a url whose data was collected
+*/
+func (s *sessionStats) addUrl() {
+	if s != nil {
+		s.urls.Add(1)
+	}
+}
+
+/*
+addRepairs
+
+counts the repair requests of a response and whether they fixed it
+*/
+func (s *sessionStats) addRepairs(attempts int, fixed bool) {
+	if s == nil {
+		return
+	}
+
+	s.repairs.Add(int64(attempts))
+
+	if fixed {
+		s.repaired.Add(1)
+	} else {
+		s.unrepaired.Add(1)
+	}
+}
+
+/*
+summary
+
+a single line describing the session
+*/
+func (s *sessionStats) summary(samples int) string {
+	return fmt.Sprintf(
+		"session summary: collected %d samples from %d urls, sent %d repair requests, %d responses repaired, "+
+			"%d could not be repaired",
+		samples,
+		s.urls.Load(),
+		s.repairs.Load(),
+		s.repaired.Load(),
+		s.unrepaired.Load())
+}
@@ -0,0 +1,206 @@
+package fetch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"huan/llm/messages"
+	"huan/llm/model"
+	scraper2 "huan/scraper"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+)
+
+const maxImageBytes = 20*model.MEGABYTE - model.MEGABYTE // stays clear of the 20MB limit on images
+
+/*
+resizeToWidth
+
+scales an image down to a width keeping its aspect ratio, every pixel is the average of the pixels it covers.
+images that are already narrow enough are returned as is
+*/
+func resizeToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+
+	if bounds.Dx() <= width || width <= 0 {
+		return img
+	}
+
+	height := max(bounds.Dy()*width/bounds.Dx(), 1)
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+
+		for x := range width {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+
+			offset := resized.PixOffset(x, y)
+			resized.Pix[offset] = uint8(r / count >> 8)
+			resized.Pix[offset+1] = uint8(g / count >> 8)
+			resized.Pix[offset+2] = uint8(b / count >> 8)
+			resized.Pix[offset+3] = uint8(a / count >> 8)
+		}
+	}
+
+	return resized
+}
+
+/*
+cropImage
+
+copies a region of an image
+*/
+func cropImage(img image.Image, rect image.Rectangle) image.Image {
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
+
+/*
+encodeTile
+
+compresses an image into a jpeg under the image size limit, the quality is lowered and then the image is shrunk
+until it fits
+*/
+func encodeTile(img image.Image, quality int) (error, []byte) {
+	for {
+		var buffer bytes.Buffer
+
+		if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality}); err != nil {
+			return err, nil
+		}
+
+		if buffer.Len() <= maxImageBytes {
+			return nil, buffer.Bytes()
+		}
+
+		if quality > 30 {
+			quality -= 10
+			continue
+		}
+
+		if img.Bounds().Dx() <= 64 {
+			return errors.New("screenshot tile could not be compressed under the image size limit"), nil
+		}
+
+		img = resizeToWidth(img, img.Bounds().Dx()/2)
+	}
+}
+
+/*
+tileTokens
+
+the most tokens the screenshot tiles of a chunk can cost, every tile is at most MaxWidth by TileHeight pixels
+*/
+func tileTokens(visual *scraper2.Visual) int {
+	if visual == nil {
+		return 0
+	}
+
+	detail := visual.Detail
+	return int(visual.MaxTiles) * model.EstimateSizedImageTokens(int(visual.MaxWidth), int(visual.TileHeight), &detail)
+}
+
+/*
+tileScreenshot
+
+scales a full page screenshot to the configured width and cuts it into tiles of the configured height, each html chunk
+receives the tiles covering the same share of the page. chunks are given at most MaxTiles tiles, the rest of their
+share of the page is not sent
+*/
+func tileScreenshot(
+	screenshot []byte,
+	chunkCount int,
+	visual *scraper2.Visual,
+	logger func(message string)) (error, [][][]byte) {
+	if chunkCount == 0 {
+		return nil, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(screenshot))
+
+	if err != nil {
+		return err, nil
+	}
+
+	img = resizeToWidth(img, int(visual.MaxWidth))
+	bounds := img.Bounds()
+	tileHeight := int(visual.TileHeight)
+	chunkTiles := make([][][]byte, chunkCount)
+
+	for chunk := range chunkCount {
+		// the vertical share of the page the chunk covers, relative to the top of the page
+		top := chunk * bounds.Dy() / chunkCount
+		bottom := (chunk + 1) * bounds.Dy() / chunkCount
+
+		first := top / tileHeight
+		covered := (bottom-1)/tileHeight - first + 1
+		last := first + min(covered, int(visual.MaxTiles)) - 1
+
+		if covered > int(visual.MaxTiles) {
+			logger(fmt.Sprintf(
+				"chunk %d of %d covers %d screenshot tiles, only the first %d are sent, raise maxTiles to see all of it",
+				chunk+1,
+				chunkCount,
+				covered,
+				visual.MaxTiles))
+		}
+
+		for index := first; index <= last; index++ {
+			y := bounds.Min.Y + index*tileHeight
+			rect := image.Rect(bounds.Min.X, y, bounds.Max.X, min(y+tileHeight, bounds.Max.Y))
+			err, tile := encodeTile(cropImage(img, rect), int(visual.Quality))
+
+			if err != nil {
+				return err, nil
+			}
+
+			chunkTiles[chunk] = append(chunkTiles[chunk], tile)
+		}
+	}
+
+	return nil, chunkTiles
+}
+
+/*
+addVisualContext
+
+adds a prompt along with the screenshot tiles of its chunk as a single multimodal message
+*/
+func addVisualContext(
+	builder *messages.ConversationBuilder,
+	prompt string,
+	tiles [][]byte,
+	detail string) {
+
+	mm := messages.MultiModalMessage{
+		Role: "user",
+	}
+
+	mm.AppendText(prompt)
+
+	var pDetail *string
+	if detail != "" {
+		pDetail = &detail
+	}
+
+	for _, tile := range tiles {
+		mm.AppendImageBytes(tile, pDetail, "jpeg")
+	}
+
+	builder.AddMultimodalMessage(&mm)
+}
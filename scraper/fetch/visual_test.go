@@ -0,0 +1,106 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"huan/llm/messages"
+	scraper2 "huan/scraper"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testScreenshot(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func Test_tileScreenshot(t *testing.T) {
+	visual := &scraper2.Visual{MaxWidth: 200, TileHeight: 200, MaxTiles: 4, Quality: 80}
+
+	err, tiles := tileScreenshot(testScreenshot(t, 400, 2000), 2, visual, func(string) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the page is scaled to 200x1000, each chunk covers half of it and they share the middle tile
+	if len(tiles) != 2 || len(tiles[0]) != 3 || len(tiles[1]) != 3 {
+		t.Fatalf("unexpected tiles per chunk %d", len(tiles))
+	}
+
+	if !bytes.Equal(tiles[0][2], tiles[1][0]) {
+		t.Error("the tile on the chunk boundary was not shared")
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(tiles[1][2]))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Width != 200 || config.Height != 200 {
+		t.Errorf("expected a 200x200 tile got %dx%d", config.Width, config.Height)
+	}
+
+	visual.MaxTiles = 1
+	var logs []string
+	_, tiles = tileScreenshot(testScreenshot(t, 400, 2000), 1, visual, func(message string) {
+		logs = append(logs, message)
+	})
+
+	if len(tiles[0]) != 1 {
+		t.Errorf("expected max tiles to be respected got %d tiles", len(tiles[0]))
+	}
+
+	if len(logs) != 1 || !strings.Contains(logs[0], "covers 5 screenshot tiles") {
+		t.Errorf("the dropped tiles were not logged %v", logs)
+	}
+}
+
+func Test_buildChunkConversations_visual(t *testing.T) {
+	llm := scraper2.GetTestLanguageModel(scraper2.TestModel{WorkTime: time.Duration(0)})
+	chunks := []string{"<p>123</p>", "<p>456</p>"}
+	visual := &scraper2.Visual{Detail: "low"}
+	tiles := [][][]byte{{[]byte("first")}, {[]byte("second"), []byte("third")}}
+
+	err, convos := buildChunkConversations(
+		context.Background(),
+		defaultCollectTemplate,
+		scraper2.PromptData{Task: "collect", Template: `{"content": ""}`},
+		&llm,
+		&messages.ConversationBuilder{},
+		[]*string{&chunks[0], &chunks[1]},
+		tiles,
+		visual)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, ok := convos[1][len(convos[1])-1].(*messages.MultiModalMessage)
+
+	if !ok {
+		t.Fatal("the chunk prompt was not sent as a multimodal message")
+	}
+
+	if len(last.Content) != 3 || *last.Content[1].ImageUrl.Detail != "low" {
+		t.Errorf("expected the prompt and both tiles of the chunk got %d parts", len(last.Content))
+	}
+}
@@ -10,12 +10,13 @@ import (
 	"huan/llm/model"
 	"log"
 	"math"
+	"net/http"
 	"strings"
 	"time"
 )
 
 type bot interface {
-	Chat(convo messages.Conversation, ctx context.Context) (error, *bool, *messages.ChatCompletion)
+	Chat(convo messages.Conversation, ctx context.Context, opts *model.Options) (error, *bool, *messages.ChatCompletion)
 	Validate(convo *messages.ConversationBuilder) error
 }
 
@@ -27,6 +28,10 @@ func loadChatgptFromYML(
 		ApiKey      string   `yaml:"apiKey"`
 		Model       string   `yaml:"model"`
 		Temperature *float32 `yaml:"temperature"`
+		Logprobs    *bool    `yaml:"logprobs"`
+		TopLogprobs *uint8   `yaml:"topLogprobs"`
+		BaseUrl     string   `yaml:"baseUrl"`
+		Embedding   string   `yaml:"embeddingModel"`
 	}{}
 
 	additionalSettings, err := yaml.Marshal(modelSettings)
@@ -47,21 +52,79 @@ func loadChatgptFromYML(
 		Model:       cGpt.Model,
 		Temperature: cGpt.Temperature,
 		MaxTokens:   &maxTok,
+		LogProbs:    cGpt.Logprobs,
+		TopLogprobs: cGpt.TopLogprobs,
+		BaseUrl:     cGpt.BaseUrl,
+	}
+
+	if cGpt.Embedding != "" {
+		if _, ok := model.GetEmbeddingEngineMap()[cGpt.Embedding]; !ok {
+			return fmt.Errorf("%s is not a valid embedding model", cGpt.Embedding), nil
+		}
+
+		c.EmbeddingModel = cGpt.Embedding
 	}
 
 	return nil, &c
 }
 
+func loadReplayFromYML(modelSettings map[string]interface{}) (error, *model.Replay) {
+	replay := &struct {
+		Cassette      string   `yaml:"cassette"`
+		Match         string   `yaml:"match"`
+		MinSimilarity *float64 `yaml:"minSimilarity"`
+	}{}
+
+	additionalSettings, err := yaml.Marshal(modelSettings)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if err = yaml.Unmarshal(additionalSettings, replay); err != nil {
+		return err, nil
+	}
+
+	if replay.Cassette == "" {
+		return errors.New("replay settings received empty cassette path"), nil
+	}
+
+	if replay.Match == "" {
+		replay.Match = "strict"
+	}
+
+	if replay.Match != "strict" && replay.Match != "fuzzy" {
+		return fmt.Errorf("replay match must be strict or fuzzy got %s", replay.Match), nil
+	}
+
+	if replay.MinSimilarity != nil && (*replay.MinSimilarity < 0 || *replay.MinSimilarity > 1) {
+		return fmt.Errorf("replay minSimilarity must be between 0 and 1 got %v", *replay.MinSimilarity), nil
+	}
+
+	err, llm := model.NewReplay(replay.Cassette, replay.Match == "fuzzy")
+
+	if err != nil {
+		return err, nil
+	}
+
+	if replay.MinSimilarity != nil {
+		llm.MinSimilarity = *replay.MinSimilarity
+	}
+
+	return nil, llm
+}
+
 func exponentialBackoff(
 	parentCtx context.Context,
 	model bot,
 	maxWaitTime uint16,
 	tryLimit uint8,
 	conversation messages.Conversation,
-	verbose bool) (error, *messages.AssistantMessage) {
+	opts *model.Options,
+	verbose bool) (error, *messages.ChatCompletion) {
 
 	type retStruct struct {
-		message    *messages.AssistantMessage
+		completion *messages.ChatCompletion
 		error      error
 		isWaitTime bool
 	}
@@ -74,16 +137,11 @@ func exponentialBackoff(
 
 	req := func(mod bot, ctx context.Context, c chan<- *retStruct) {
 
-		err, bo, comp := mod.Chat(conversation, ctx)
-
-		var mess *messages.AssistantMessage
-		if comp != nil {
-			mess = &comp.ToAssistant()[0]
-		}
+		err, bo, comp := mod.Chat(conversation, ctx, opts)
 
 		r := &retStruct{
-			message: mess,
-			error:   err,
+			completion: comp,
+			error:      err,
 			isWaitTime: func() bool {
 				if bo == nil {
 					return false
@@ -118,7 +176,7 @@ func exponentialBackoff(
 				snooze(int(i), int(tryLimit))
 			} else if val.error == nil {
 				logger("response received")
-				return nil, val.message
+				return nil, val.completion
 			}
 		case <-ctx.Done():
 			cancelFunc()
@@ -143,15 +201,391 @@ LanguageModel
 a wrapper struct around a llm with easy chat requests and defaults
 */
 type LanguageModel struct {
-	tryLimit uint8
-	duration uint16
-	verbose  bool
-	workers  uint8
-	bot      bot
+	tryLimit     uint8
+	duration     uint16
+	verbose      bool
+	workers      uint8
+	mode         string
+	pollInterval time.Duration
+	cache        *ResponseCache
+	recorder     *model.Recorder
+	transcript   *messages.Transcript
+	truncator    model.Truncator
+	bot          bot
 }
 
 func (l *LanguageModel) Chat(ctx context.Context, convo *messages.Conversation) (error, *messages.AssistantMessage) {
-	return exponentialBackoff(ctx, l.bot, l.duration, l.tryLimit, *convo, l.verbose)
+	err, completion := l.Complete(ctx, convo, nil)
+
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, &completion.ToAssistant()[0]
+}
+
+/*
+Complete
+
+makes a chat request and returns the full chat completion, including the finish reason and log probabilities
+of every choice. opts can be nil, otherwise it overrides the sampling settings of this request
+*/
+func (l *LanguageModel) Complete(
+	ctx context.Context,
+	convo *messages.Conversation,
+	opts *model.Options) (error, *messages.ChatCompletion) {
+
+	var key string
+
+	if k, ok := l.bot.(keyer); ok && l.cache != nil {
+		var err error
+		if err, key = k.CacheKey(*convo, opts); err != nil {
+			return err, nil
+		}
+
+		if completion, ok := l.cache.Get(key); ok {
+			l.log("response served from cache")
+			l.record(*convo, opts, completion)
+			return nil, completion
+		}
+	}
+
+	err, completion := exponentialBackoff(ctx, l.bot, l.duration, l.tryLimit, *convo, opts, l.verbose)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if len(completion.Choices) == 0 {
+		return errors.New("chat completion returned no choices"), nil
+	}
+
+	if key != "" && isCacheable(completion) {
+		if err = l.cache.Put(key, completion); err != nil {
+			l.log(fmt.Sprintf("could not cache response: %v", err))
+		}
+	}
+
+	l.record(*convo, opts, completion)
+	return nil, completion
+}
+
+/*
+record
+
+writes a request and response pair to the cassette when recording
+*/
+func (l *LanguageModel) record(convo messages.Conversation, opts *model.Options, completion *messages.ChatCompletion) {
+	if l.recorder == nil {
+		return
+	}
+
+	if err := l.recorder.Record(convo, opts, completion); err != nil {
+		l.log(fmt.Sprintf("could not record response: %v", err))
+	}
+}
+
+/*
+Record
+
+writes every chat request and response to a cassette file, it can be served back by the replay llm type
+*/
+func (l *LanguageModel) Record(path string) error {
+	err, recorder := model.NewRecorder(path)
+
+	if err != nil {
+		return err
+	}
+
+	l.recorder = recorder
+	return nil
+}
+
+/*
+Transcript
+
+writes every extraction conversation along with the assistant's response to a jsonl transcript
+*/
+func (l *LanguageModel) Transcript(path string) error {
+	err, transcript := messages.NewTranscript(path)
+
+	if err != nil {
+		return err
+	}
+
+	l.transcript = transcript
+	return nil
+}
+
+/*
+Transcribe
+
+appends a conversation to the transcript once for every choice it received, nothing is written when there is no
+transcript
+*/
+func (l *LanguageModel) Transcribe(label string, convo messages.Conversation, choices []messages.Choice) {
+	if l.transcript == nil {
+		return
+	}
+
+	for _, choice := range choices {
+		answered := append(messages.Conversation{}, convo...)
+		answered = append(answered, &messages.AssistantMessage{
+			Role:    "assistant",
+			Content: choice.Message.Content,
+		})
+
+		if err := l.transcript.Append(label, answered); err != nil {
+			l.log(fmt.Sprintf("could not write to the transcript: %v", err))
+		}
+	}
+}
+
+/*
+Close
+
+releases the resources held by the language model such as an open cassette or transcript
+*/
+func (l *LanguageModel) Close() error {
+	var errs []error
+
+	if l.recorder != nil {
+		errs = append(errs, l.recorder.Close())
+	}
+
+	if l.transcript != nil {
+		errs = append(errs, l.transcript.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+SetCache
+
+places a response cache in front of every chat request, a nil cache disables caching
+*/
+func (l *LanguageModel) SetCache(cache *ResponseCache) {
+	l.cache = cache
+}
+
+func (l *LanguageModel) log(message string) {
+	if l.verbose {
+		log.Println(message)
+	}
+}
+
+/*
+logprobber
+
+a llm that can return the log probabilities of the tokens it generates
+*/
+type logprobber interface {
+	EnableLogprobs()
+}
+
+/*
+EnableLogprobs
+
+requests token log probabilities on every chat request, errors if the underlying llm cannot provide them
+*/
+func (l *LanguageModel) EnableLogprobs() error {
+	lp, ok := l.bot.(logprobber)
+
+	if !ok {
+		return errors.New("the language model does not support log probabilities")
+	}
+
+	lp.EnableLogprobs()
+	return nil
+}
+
+/*
+Embed
+
+converts every text into a vector in the same order, cached vectors are reused and the rest are embedded together
+*/
+func (l *LanguageModel) Embed(ctx context.Context, texts []string) (error, [][]float32) {
+	embedder, ok := l.bot.(model.Embedder)
+
+	if !ok {
+		return errors.New("the language model does not support embeddings"), nil
+	}
+
+	err, engine := embedder.EmbeddingEngine()
+
+	if err != nil {
+		return err, nil
+	}
+
+	vectors := make([][]float32, len(texts))
+	var missing []int
+
+	for index, text := range texts {
+		if l.cache != nil {
+			if vector, ok := l.cache.GetEmbedding(embeddingKey(engine, text)); ok {
+				vectors[index] = vector
+				continue
+			}
+		}
+
+		missing = append(missing, index)
+	}
+
+	if len(missing) == 0 {
+		l.log(fmt.Sprintf("%d embeddings served from cache", len(texts)))
+		return nil, vectors
+	}
+
+	uncached := make([]string, len(missing))
+	for index, textIndex := range missing {
+		uncached[index] = texts[textIndex]
+	}
+
+	err, embedded := embedder.Embed(ctx, uncached)
+
+	if err != nil {
+		return err, nil
+	}
+
+	for index, textIndex := range missing {
+		vectors[textIndex] = embedded[index]
+
+		if l.cache != nil {
+			if err = l.cache.PutEmbedding(embeddingKey(engine, texts[textIndex]), embedded[index]); err != nil {
+				l.log(fmt.Sprintf("could not cache embedding: %v", err))
+			}
+		}
+	}
+
+	l.log(fmt.Sprintf("embedded %d texts, %d served from cache", len(texts), len(texts)-len(missing)))
+	return nil, vectors
+}
+
+/*
+GetEmbeddingEngine
+
+the capabilities of the embedding model such as the dimensions of its vectors
+*/
+func (l *LanguageModel) GetEmbeddingEngine() (error, model.EmbeddingEngine) {
+	embedder, ok := l.bot.(model.Embedder)
+
+	if !ok {
+		return errors.New("the language model does not support embeddings"), model.EmbeddingEngine{}
+	}
+
+	return embedder.EmbeddingEngine()
+}
+
+/*
+httpUser
+
+a llm that sends its requests over http
+*/
+type httpUser interface {
+	SetHttpClient(client *http.Client)
+}
+
+/*
+SetHttpClient
+
+sends every request through the provided client, errors if the underlying llm does not use http
+*/
+func (l *LanguageModel) SetHttpClient(client *http.Client) error {
+	hu, ok := l.bot.(httpUser)
+
+	if !ok {
+		return errors.New("the language model does not make http requests")
+	}
+
+	hu.SetHttpClient(client)
+	return nil
+}
+
+/*
+budgeter
+
+a llm that knows how many tokens a conversation sent to it can use
+*/
+type budgeter interface {
+	TokenBudget() int
+}
+
+/*
+SetTruncation
+
+shrinks conversations that do not fit in the context window with a strategy eg: dropOldest, dropImages, summarise.
+summarise asks the language model itself for the summary
+*/
+func (l *LanguageModel) SetTruncation(strategy string) error {
+	summariser := func(ctx context.Context, convo messages.Conversation) (error, string) {
+		err, completion := l.Complete(ctx, &convo, nil)
+
+		if err != nil {
+			return err, ""
+		}
+
+		content := completion.Choices[0].Message.Content
+
+		if content == nil {
+			return errors.New("the summary was empty"), ""
+		}
+
+		return nil, *content
+	}
+
+	err, truncator := model.GetTruncator(strategy, summariser)
+
+	if err != nil {
+		return err
+	}
+
+	l.truncator = truncator
+	return nil
+}
+
+/*
+Fit
+
+applies the truncation strategy when the conversation is estimated to exceed the context window, nothing is done
+when there is no strategy or the llm does not report its budget
+*/
+func (l *LanguageModel) Fit(ctx context.Context, convo *messages.ConversationBuilder) error {
+	b, ok := l.bot.(budgeter)
+
+	if l.truncator == nil || !ok {
+		return nil
+	}
+
+	budget := b.TokenBudget()
+	tokens := model.EstimateTokens(convo)
+
+	if tokens <= budget {
+		return nil
+	}
+
+	l.log(fmt.Sprintf("conversation needs an estimated %d tokens but only %d fit, truncating", tokens, budget))
+	return l.truncator.Truncate(ctx, convo, budget)
+}
+
+/*
+CheckBudget
+
+fails when the conversation is estimated to exceed the context window, unlike Fit nothing is removed. used for
+follow up turns that are meaningless without the messages before them
+*/
+func (l *LanguageModel) CheckBudget(convo *messages.ConversationBuilder) error {
+	b, ok := l.bot.(budgeter)
+
+	if !ok {
+		return nil
+	}
+
+	if budget, tokens := b.TokenBudget(), model.EstimateTokens(convo); tokens > budget {
+		return fmt.Errorf("the conversation needs an estimated %d tokens but only %d are available", tokens, budget)
+	}
+
+	return nil
 }
 
 func (l *LanguageModel) Validate(convo *messages.ConversationBuilder) error {
@@ -159,10 +593,48 @@ func (l *LanguageModel) Validate(convo *messages.ConversationBuilder) error {
 	return err
 }
 
-func (l *LanguageModel) GetWorkers(convo *messages.ConversationBuilder) uint8 {
+/*
+GetWorkers
+
+how many requests to the llm can happen at once, the chunks of a page are completed with this many workers
+*/
+func (l *LanguageModel) GetWorkers() uint8 {
 	return l.workers
 }
 
+/*
+IsBatch
+
+checks if chat requests should be submitted as a batch job instead of being made one by one
+*/
+func (l *LanguageModel) IsBatch() bool {
+	return l.mode == "batch"
+}
+
+/*
+GetPollInterval
+
+how long to wait between checks on a batch job
+*/
+func (l *LanguageModel) GetPollInterval() time.Duration {
+	return l.pollInterval
+}
+
+/*
+GetBatcher
+
+the underlying llm as a Batcher, errors if it cannot run batch jobs
+*/
+func (l *LanguageModel) GetBatcher() (error, model.Batcher) {
+	b, ok := l.bot.(model.Batcher)
+
+	if !ok {
+		return errors.New("the language model does not support batch jobs"), nil
+	}
+
+	return nil, b
+}
+
 func InitLanguageModel(
 	modelType string,
 	settings map[string]interface{},
@@ -170,7 +642,9 @@ func InitLanguageModel(
 	maxTokens *uint16,
 	duration *uint16,
 	verbose bool,
-	workers *uint8) (error, *LanguageModel) {
+	workers *uint8,
+	mode *string,
+	pollInterval *uint16) (error, *LanguageModel) {
 
 	var tokenLimit uint16
 
@@ -202,6 +676,26 @@ func InitLanguageModel(
 		lang.workers = *workers
 	}
 
+	if mode == nil {
+		lang.mode = "sync"
+	} else {
+		lang.mode = strings.ToLower(*mode)
+	}
+
+	if lang.mode != "sync" && lang.mode != "batch" {
+		return fmt.Errorf("llm mode must be sync or batch got %s", lang.mode), nil
+	}
+
+	if pollInterval == nil {
+		lang.pollInterval = 60 * time.Second
+	} else {
+		lang.pollInterval = time.Duration(*pollInterval) * time.Second
+	}
+
+	if lang.pollInterval == 0 {
+		return errors.New("llm pollInterval cannot be 0"), nil
+	}
+
 	var b bot
 
 	switch strings.ToLower(modelType) {
@@ -214,11 +708,27 @@ func InitLanguageModel(
 
 		b = mod
 
+	case "replay":
+		err, mod := loadReplayFromYML(settings)
+
+		if err != nil {
+			return err, nil
+		}
+
+		b = mod
+
 	default:
 		return fmt.Errorf("there is no llm type %s", modelType), nil
 	}
 
 	lang.bot = b
+
+	if lang.IsBatch() {
+		if err, _ := lang.GetBatcher(); err != nil {
+			return err, nil
+		}
+	}
+
 	return nil, lang
 }
 
@@ -229,7 +739,10 @@ type TestModel struct {
 	Quantity uint16
 }
 
-func (t TestModel) Chat(convo messages.Conversation, ctx context.Context) (error, *bool, *messages.ChatCompletion) {
+func (t TestModel) Chat(
+	convo messages.Conversation,
+	ctx context.Context,
+	opts *model.Options) (error, *bool, *messages.ChatCompletion) {
 	state := false
 
 	var dataString []interface{}
@@ -248,16 +761,26 @@ func (t TestModel) Chat(convo messages.Conversation, ctx context.Context) (error
 
 	time.Sleep(t.WorkTime * time.Second)
 
-	return nil, &state, &messages.ChatCompletion{
-		Choices: []messages.Choice{
-			{
-				Index: 0,
-				Message: messages.Message{
-					Role:    "assistant",
-					Content: &res,
-				},
+	n := 1
+
+	if opts != nil && opts.N != nil {
+		n = *opts.N
+	}
+
+	choices := make([]messages.Choice, n)
+
+	for index := range choices {
+		choices[index] = messages.Choice{
+			Index: int32(index),
+			Message: messages.Message{
+				Role:    "assistant",
+				Content: &res,
 			},
-		},
+		}
+	}
+
+	return nil, &state, &messages.ChatCompletion{
+		Choices: choices,
 	}
 }
 
@@ -271,6 +794,29 @@ func GetTestLanguageModel(t TestModel) LanguageModel {
 		duration: 10,
 		verbose:  false,
 		workers:  2,
+		mode:     "sync",
 		bot:      t,
 	}
 }
+
+/*
+GetBatchLanguageModel
+
+wraps a Batcher, such as a ChatGpt pointed at a stand-in server, in a language model that runs in batch mode
+*/
+func GetBatchLanguageModel(b model.Batcher, pollInterval time.Duration) (error, LanguageModel) {
+	bt, ok := b.(bot)
+
+	if !ok {
+		return errors.New("batcher is not a chat model"), LanguageModel{}
+	}
+
+	return nil, LanguageModel{
+		tryLimit:     3,
+		duration:     10,
+		workers:      2,
+		mode:         "batch",
+		pollInterval: pollInterval,
+		bot:          bt,
+	}
+}
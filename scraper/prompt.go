@@ -0,0 +1,145 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+/*
+PromptData
+
+the variables available to prompt templates
+*/
+type PromptData struct {
+	Html       string // the html of the chunk being collected, empty in the system prompt
+	Task       string // the data collection task
+	Template   string // the example template as indented json
+	Url        string // the url of the page being collected
+	ChunkIndex int    // the position of the chunk in the page starting at 0
+	ChunkCount int    // how many chunks the page was split into
+}
+
+/*
+RenderPrompt
+
+fills a prompt template with the data of a chunk
+*/
+func RenderPrompt(tmpl *template.Template, data PromptData) (error, string) {
+	var builder strings.Builder
+
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return err, ""
+	}
+
+	return nil, builder.String()
+}
+
+const filePrefix = "file:" // marks a setting read from the file it names rather than given inline
+
+/*
+readSetting
+
+the text of a setting, a value starting with file: is read from the file it names and anything else is used as is.
+a file that cannot be read is an error so a wrong path is not mistaken for the text itself
+*/
+func readSetting(value string) (error, string) {
+	path, ok := strings.CutPrefix(value, filePrefix)
+
+	if !ok {
+		return nil, value
+	}
+
+	data, err := os.ReadFile(strings.TrimSpace(path))
+
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, string(data)
+}
+
+/*
+loadPromptTemplate
+
+parses a prompt template, value is read from a file when it starts with file: otherwise it is used as the template.
+the template is rendered once so references to unknown variables are caught before the session starts
+*/
+func loadPromptTemplate(name, value string) (error, *template.Template) {
+	err, text := readSetting(value)
+
+	if err != nil {
+		return fmt.Errorf("the Fetch setting %s: %v", name, err), nil
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("the Fetch setting %s: is blank", name), nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+
+	if err != nil {
+		return fmt.Errorf("the Fetch setting %s: %v", name, err), nil
+	}
+
+	if err = tmpl.Execute(io.Discard, PromptData{}); err != nil {
+		return fmt.Errorf("the Fetch setting %s: %v", name, err), nil
+	}
+
+	return nil, tmpl
+}
+
+/*
+Example
+
+a html snippet paired with the data that should be collected from it, shown to the llm before every chunk
+*/
+type Example struct {
+	Html   string
+	Output string // the expected data as indented json
+}
+
+/*
+buildExample
+
+reads the html of an example and converts its output into a json array of dictionaries, an output string is parsed
+as json and a single dictionary is wrapped in an array. html starting with file: is read from the file it names
+*/
+func buildExample(index int, html string, output interface{}) (error, Example) {
+	err, html := readSetting(html)
+
+	if err != nil {
+		return fmt.Errorf("the Fetch setting examples: example %d html: %v", index, err), Example{}
+	}
+
+	if strings.TrimSpace(html) == "" {
+		return fmt.Errorf("the Fetch setting examples: example %d has no html", index), Example{}
+	}
+
+	if str, ok := output.(string); ok {
+		if err = json.Unmarshal([]byte(str), &output); err != nil {
+			return fmt.Errorf("the Fetch setting examples: example %d output is not valid json: %v", index, err), Example{}
+		}
+	}
+
+	switch output.(type) {
+	case []interface{}:
+	case map[string]interface{}:
+		output = []interface{}{output}
+	default:
+		return fmt.Errorf(
+			"the Fetch setting examples: example %d output must be a dictionary or an array of them",
+			index), Example{}
+	}
+
+	data, err := json.MarshalIndent(output, "", " ")
+
+	if err != nil {
+		return err, Example{}
+	}
+
+	return nil, Example{Html: html, Output: string(data)}
+}
@@ -0,0 +1,109 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_loadPromptTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.tmpl")
+
+	if err := os.WriteFile(path, []byte("chunk {{.ChunkIndex}} of {{.ChunkCount}} from {{.Url}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data := PromptData{Url: "https://huan.dev", ChunkIndex: 1, ChunkCount: 3, Task: "collect"}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		err   string
+	}{
+		{"inline", "{{.Task}} the following\n{{.Html}}", "collect the following\n", ""},
+		{"file", "file:" + path, "chunk 1 of 3 from https://huan.dev", ""},
+		{"missing file", "file:" + path + ".missing", "", "no such file"},
+		{"path without prefix", path, path, ""},
+		{"unknown variable", "{{.Page}}", "", "can't evaluate field Page"},
+		{"invalid syntax", "{{.Task", "", "unclosed action"},
+		{"blank", "  ", "", "is blank"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, tmpl := loadPromptTemplate("prompt", tt.value)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected an error containing %q got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err, rendered := RenderPrompt(tmpl, data)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rendered != tt.want {
+				t.Errorf("expected %q got %q", tt.want, rendered)
+			}
+		})
+	}
+}
+
+func Test_buildExample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.html")
+
+	if err := os.WriteFile(path, []byte("<li>huan</li>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		html   string
+		output interface{}
+		want   string
+		err    string
+	}{
+		{"array", "<li>huan</li>", []interface{}{map[string]interface{}{"name": "huan"}}, `"name": "huan"`, ""},
+		{"single dictionary", "file:" + path, map[string]interface{}{"name": "huan"}, `"name": "huan"`, ""},
+		{"missing file", "file:" + path + ".missing", []interface{}{}, "", "example 0 html"},
+		{"json string", "<li>huan</li>", `[{"name": "huan"}]`, `"name": "huan"`, ""},
+		{"invalid json", "<li>huan</li>", `[{"name": }]`, "", "not valid json"},
+		{"scalar output", "<li>huan</li>", 12, "", "must be a dictionary"},
+		{"no html", "", []interface{}{}, "", "has no html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, example := buildExample(0, tt.html, tt.output)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected an error containing %q got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if example.Html != "<li>huan</li>" {
+				t.Errorf("unexpected html %q", example.Html)
+			}
+
+			if !strings.HasPrefix(example.Output, "[") || !strings.Contains(example.Output, tt.want) {
+				t.Errorf("unexpected output %s", example.Output)
+			}
+		})
+	}
+}
@@ -4,8 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"huan/llm/model"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 )
 
 /*
@@ -26,23 +32,200 @@ type Session struct {
 		MaxTokens *uint16                `yaml:"maxTokens"`       // the max tokens the chatbot should return
 		Duration  *uint16                `yaml:"requestDuration"` // Max wait time for a chat completion to request
 		Workers   *uint8                 `yaml:"workers"`         // the amount of llm requests that can happen concurrently
+		Mode      *string                `yaml:"mode"`            // how chat requests are executed eg: sync, batch
+		Poll      *uint16                `yaml:"pollInterval"`    // seconds to wait between checks on a batch job
+		Cache     *struct {
+			Dir     *string `yaml:"dir"`     // where responses are cached, defaults to the user cache directory
+			Ttl     *uint32 `yaml:"ttl"`     // seconds a cached response stays valid, 0 never expires
+			MaxSize *uint32 `yaml:"maxSize"` // the max size of the cache in megabytes, 0 is unlimited
+		} `yaml:"cache"` // reuse responses to identical chat requests
+		Record     *string `yaml:"record"`     // a cassette file every chat request and response is written to
+		Transcript *string `yaml:"transcript"` // a jsonl file every extraction conversation and its response is written to
+		Truncation *string `yaml:"truncation"` // how conversations that exceed the context window shrink eg: dropOldest, dropImages, summarise
+		Transport  *struct {
+			Proxy        *string           `yaml:"proxy"`        // the url of a proxy requests are sent through
+			CaBundle     *string           `yaml:"caBundle"`     // a pem file of extra certificate authorities to trust
+			ClientCert   *string           `yaml:"clientCert"`   // a pem certificate for mutual tls
+			ClientKey    *string           `yaml:"clientKey"`    // the pem key of the client certificate
+			Timeout      *uint16           `yaml:"timeout"`      // seconds a single http request can take, 0 is unlimited
+			IdleTimeout  *uint16           `yaml:"idleTimeout"`  // seconds an unused connection is kept open
+			MaxIdleConns *uint16           `yaml:"maxIdleConns"` // how many unused connections are kept open
+			Headers      map[string]string `yaml:"headers"`      // extra headers sent with every request
+		} `yaml:"transport"` // http settings shared by every request to the llm
 	} `yaml:"llmConfig"`
 
 	Fetch *struct {
 		MaxRuntime      *uint32                `yaml:"maxRuntime"`      // max time a data collection session can run in seconds
+		GracePeriod     *uint16                `yaml:"gracePeriod"`     // seconds the urls in progress have to finish after a shutdown signal
 		Headless        bool                   `yaml:"headless"`        // whether the scraping session should be visible
 		MaxSamples      *uint16                `yaml:"maxSamples"`      // the max amount of samples to collect
-		Urls            []string               `yaml:"urls"`            // the url to collect data from
+		Urls            []string               `yaml:"urls"`            // the url to collect data from, {1..50} and {2024-01-01..2024-01-31} ranges are expanded
 		Task            string                 `yaml:"task"`            // the data collection task that needs to be done (extra context)
 		SavePath        *string                `yaml:"savePath"`        // where the data will be saved
 		ExampleTemplate map[string]interface{} `yaml:"exampleTemplate"` // an example of how the data should be collected
 		Workers         *uint8                 `yaml:"workers"`         // the amount of urls that can be scraped concurrently
+		Prompt          *string                `yaml:"prompt"`          // the collection prompt template, file:<path> reads it from a file
+		SystemPrompt    *string                `yaml:"systemPrompt"`    // the system prompt template, file:<path> reads it from a file
+		Confidence      *struct {
+			Threshold *float64 `yaml:"threshold"` // values with a confidence below this are considered uncertain
+			Action    *string  `yaml:"action"`    // what to do with uncertain values eg: flag, drop
+		} `yaml:"confidence"` // score every collected value using the llm's token log probabilities
+		Consistency *struct {
+			Samples      *uint8    `yaml:"samples"`      // how many completions to request per chunk
+			Strategy     *string   `yaml:"strategy"`     // how completions are requested eg: n, temperature
+			Temperatures []float32 `yaml:"temperatures"` // the temperature of each completion when using the temperature strategy
+			MinAgreement *float64  `yaml:"minAgreement"` // the share of completions that must agree on a value to keep it
+		} `yaml:"consistency"` // sample several completions per chunk and keep values by majority vote
+		Visual *struct {
+			MaxWidth   *uint16 `yaml:"maxWidth"`   // screenshots are scaled down to this width in pixels
+			TileHeight *uint16 `yaml:"tileHeight"` // the height in pixels of every screenshot tile after scaling
+			MaxTiles   *uint8  `yaml:"maxTiles"`   // the most tiles sent with a single chunk
+			Quality    *uint8  `yaml:"quality"`    // the jpeg quality of the tiles
+			Detail     *string `yaml:"detail"`     // the detail the llm views the tiles with eg: low, high, auto
+		} `yaml:"visual"` // send screenshot tiles of the page along with every html chunk
+		Screenshots *struct {
+			Overlap    *float64 `yaml:"overlap"`    // the share of the viewport consecutive screenshots have in common
+			MaxScreens *uint8   `yaml:"maxScreens"` // the most screenshots taken of a single page
+			PerRequest *uint8   `yaml:"perRequest"` // how many consecutive screenshots are sent in a single request
+			Settle     *uint16  `yaml:"settle"`     // milliseconds to wait after scrolling before a screenshot is taken
+			MaxWidth   *uint16  `yaml:"maxWidth"`   // screenshots are scaled down to this width in pixels
+			Quality    *uint8   `yaml:"quality"`    // the jpeg quality of the screenshots
+			Detail     *string  `yaml:"detail"`     // the detail the llm views the screenshots with eg: low, high, auto
+		} `yaml:"screenshots"` // collect data from viewport screenshots only, for pages drawn with canvas, svg or images
+		Crawl *struct {
+			Allow      []string `yaml:"allow"`      // regular expressions a link must match one of to be followed
+			Deny       []string `yaml:"deny"`       // regular expressions of links that are never followed
+			SameDomain *bool    `yaml:"sameDomain"` // only follow links to the domain of the page they were found on
+			MaxDepth   *uint8   `yaml:"maxDepth"`   // how many links away from the seed urls a page can be
+			MaxPages   *uint16  `yaml:"maxPages"`   // the most pages visited including the seed urls
+			PickLinks  *bool    `yaml:"pickLinks"`  // let the llm choose which of the followable links suit the task
+		} `yaml:"crawl"` // follow the links found on every page
+		Pagination []struct {
+			Match    *string `yaml:"match"`    // a regular expression of the urls the strategy applies to, every url when blank
+			Strategy string  `yaml:"strategy"` // how the next page is reached eg: next, pages, loadMore, scroll
+			Selector *string `yaml:"selector"` // the css selector of the next or load more button
+			Param    *string `yaml:"param"`    // the query parameter holding the page number
+			From     *int    `yaml:"from"`     // the first page number
+			To       *int    `yaml:"to"`       // the last page number
+			MaxPages *uint16 `yaml:"maxPages"` // the most pages visited per url
+			Wait     *uint16 `yaml:"wait"`     // milliseconds to wait for new content after moving to the next page
+		} `yaml:"pagination"` // move through the pages of a listing, the first strategy matching a url is used
+		Detail *struct {
+			LinkField *string  `yaml:"linkField"` // the template field holding the link to the detail page of an item
+			Fields    []string `yaml:"fields"`    // the template fields only found on detail pages, every field when empty
+			Wait      *uint16  `yaml:"wait"`      // milliseconds to wait for a detail page to load
+		} `yaml:"detail"` // visit the detail page of every item found on a list page and merge in the remaining fields
+		Politeness *struct {
+			UserAgent       *string `yaml:"userAgent"`       // the user agent the browser and every http request identify as
+			ObeyRobots      *bool   `yaml:"obeyRobots"`      // whether robots.txt is obeyed, disabling it is logged
+			HostConcurrency *uint8  `yaml:"hostConcurrency"` // the most pages of a single host open at once
+			MinInterval     *uint32 `yaml:"minInterval"`     // milliseconds between requests to a host, a longer crawl-delay wins
+		} `yaml:"politeness"` // how considerate the session is towards the sites it visits
+		Seeds *struct {
+			Sitemaps []string `yaml:"sitemaps"` // urls or files of sitemaps and sitemap indexes, gzipped or not
+			Files    []string `yaml:"files"`    // text files with a url per line or csv files with a url column
+			Column   *string  `yaml:"column"`   // the column of csv files holding the urls
+			Match    *string  `yaml:"match"`    // a regular expression the expanded urls must match
+			MaxUrls  *uint32  `yaml:"maxUrls"`  // the most urls the session starts with after expansion
+		} `yaml:"seeds"` // more sources of urls expanded when the session starts
+		Chunking *struct {
+			Tokens  *uint32  `yaml:"tokens"`  // the tokens of html and examples sent with every chunk
+			Overlap *float64 `yaml:"overlap"` // the share of a chunk repeated at the start of the next one, below 0.5
+		} `yaml:"chunking"` // how the html of a page is split into chunks
+		Examples []struct {
+			Html   string      `yaml:"html"`   // a html snippet, file:<path> reads it from a file
+			Output interface{} `yaml:"output"` // the data that should be collected from the snippet
+		} `yaml:"examples"` // few shot examples shown to the llm before every chunk
 	} `yaml:"fetch"`
 }
 
+/*
+BuildCache
+
+creates the llm response cache, nil is returned when caching was not configured or has been disabled. refresh
+ignores existing entries while still caching new responses
+*/
+func (s *Session) BuildCache(noCache, refresh bool) (error, *ResponseCache) {
+	if s.LlmConfig.Cache == nil || noCache {
+		return nil, nil
+	}
+
+	var dir string
+
+	if s.LlmConfig.Cache.Dir != nil {
+		dir = *s.LlmConfig.Cache.Dir
+	} else {
+		userCache, err := os.UserCacheDir()
+
+		if err != nil {
+			return err, nil
+		}
+
+		dir = filepath.Join(userCache, "huan", "responses")
+	}
+
+	var ttl time.Duration
+	if s.LlmConfig.Cache.Ttl != nil {
+		ttl = time.Duration(*s.LlmConfig.Cache.Ttl) * time.Second
+	}
+
+	var maxSize int64
+	if s.LlmConfig.Cache.MaxSize != nil {
+		maxSize = int64(*s.LlmConfig.Cache.MaxSize) * 1024 * 1024
+	}
+
+	return NewResponseCache(dir, ttl, maxSize, refresh)
+}
+
+/*
+BuildTransport
+
+creates the http client every llm request is made with, nil is returned when no transport was configured
+*/
+func (s *Session) BuildTransport() (error, *http.Client) {
+	config := s.LlmConfig.Transport
+
+	if config == nil {
+		return nil, nil
+	}
+
+	transport := model.Transport{Headers: config.Headers}
+
+	if config.Proxy != nil {
+		transport.ProxyUrl = *config.Proxy
+	}
+
+	if config.CaBundle != nil {
+		transport.CaBundle = *config.CaBundle
+	}
+
+	if config.ClientCert != nil {
+		transport.ClientCert = *config.ClientCert
+	}
+
+	if config.ClientKey != nil {
+		transport.ClientKey = *config.ClientKey
+	}
+
+	if config.Timeout != nil {
+		transport.Timeout = time.Duration(*config.Timeout) * time.Second
+	}
+
+	if config.IdleTimeout != nil {
+		transport.IdleConnTimeout = time.Duration(*config.IdleTimeout) * time.Second
+	}
+
+	if config.MaxIdleConns != nil {
+		transport.MaxIdleConns = int(*config.MaxIdleConns)
+	}
+
+	return model.NewHttpClient(&transport)
+}
+
 type Settings struct {
 	Verbose     bool
 	SessionName string
+	Resume      bool // continue the session from its saved state instead of starting over
 }
 
 /*
@@ -65,8 +248,609 @@ func (s *Session) BuildSettings() (error, *Settings) {
 	}
 }
 
+/*
+Confidence
+
+how per field confidence scores should be applied to collected samples
+*/
+type Confidence struct {
+	Threshold float64
+	Action    string
+}
+
+/*
+Consistency
+
+how many completions are sampled per chunk and how they are voted on
+*/
+type Consistency struct {
+	Samples      uint8
+	Strategy     string
+	Temperatures []float32
+	MinAgreement float64
+}
+
+/*
+Visual
+
+how screenshots are cut into tiles and sent alongside the html chunks
+*/
+type Visual struct {
+	MaxWidth   uint16
+	TileHeight uint16
+	MaxTiles   uint8
+	Quality    uint8
+	Detail     string
+}
+
+/*
+buildVisual
+
+creates the visual context settings with predefined defaults, nil is returned when it was not requested
+*/
+func (s *Session) buildVisual() (error, *Visual) {
+	config := s.Fetch.Visual
+
+	if config == nil {
+		return nil, nil
+	}
+
+	visual := &Visual{
+		MaxWidth:   1024,
+		TileHeight: 1024,
+		MaxTiles:   4,
+		Quality:    80,
+		Detail:     "auto",
+	}
+
+	if config.MaxWidth != nil {
+		visual.MaxWidth = *config.MaxWidth
+	}
+
+	if config.TileHeight != nil {
+		visual.TileHeight = *config.TileHeight
+	}
+
+	if config.MaxTiles != nil {
+		visual.MaxTiles = *config.MaxTiles
+	}
+
+	if config.Quality != nil {
+		visual.Quality = *config.Quality
+	}
+
+	if config.Detail != nil {
+		visual.Detail = *config.Detail
+	}
+
+	if visual.MaxWidth == 0 || visual.TileHeight == 0 || visual.MaxTiles == 0 {
+		return errors.New("the Fetch setting visual: maxWidth, tileHeight and maxTiles cannot be 0"), nil
+	}
+
+	if visual.Quality == 0 || visual.Quality > 100 {
+		return fmt.Errorf("the Fetch setting visual: quality must be between 1 and 100 got %d", visual.Quality), nil
+	}
+
+	if visual.Detail != "low" && visual.Detail != "high" && visual.Detail != "auto" {
+		return fmt.Errorf("the Fetch setting visual: detail must be low, high or auto got %s", visual.Detail), nil
+	}
+
+	return nil, visual
+}
+
+/*
+Screenshots
+
+how a page is scrolled and captured when data is collected from screenshots instead of html
+*/
+type Screenshots struct {
+	Overlap    float64
+	MaxScreens uint8
+	PerRequest uint8
+	Settle     uint16
+	MaxWidth   uint16
+	Quality    uint8
+	Detail     string
+}
+
+/*
+buildScreenshots
+
+creates the screenshot only settings with predefined defaults, nil is returned when it was not requested
+*/
+func (s *Session) buildScreenshots() (error, *Screenshots) {
+	config := s.Fetch.Screenshots
+
+	if config == nil {
+		return nil, nil
+	}
+
+	screens := &Screenshots{
+		Overlap:    0.2,
+		MaxScreens: 10,
+		PerRequest: 1,
+		Settle:     500,
+		MaxWidth:   1024,
+		Quality:    80,
+		Detail:     "high",
+	}
+
+	if config.Overlap != nil {
+		screens.Overlap = *config.Overlap
+	}
+
+	if config.MaxScreens != nil {
+		screens.MaxScreens = *config.MaxScreens
+	}
+
+	if config.PerRequest != nil {
+		screens.PerRequest = *config.PerRequest
+	}
+
+	if config.Settle != nil {
+		screens.Settle = *config.Settle
+	}
+
+	if config.MaxWidth != nil {
+		screens.MaxWidth = *config.MaxWidth
+	}
+
+	if config.Quality != nil {
+		screens.Quality = *config.Quality
+	}
+
+	if config.Detail != nil {
+		screens.Detail = *config.Detail
+	}
+
+	if screens.Overlap < 0 || screens.Overlap > 0.9 {
+		return fmt.Errorf("the Fetch setting screenshots: overlap must be between 0 and 0.9 got %v", screens.Overlap), nil
+	}
+
+	if screens.MaxScreens == 0 || screens.PerRequest == 0 || screens.MaxWidth == 0 {
+		return errors.New("the Fetch setting screenshots: maxScreens, perRequest and maxWidth cannot be 0"), nil
+	}
+
+	if screens.Quality == 0 || screens.Quality > 100 {
+		return fmt.Errorf("the Fetch setting screenshots: quality must be between 1 and 100 got %d", screens.Quality), nil
+	}
+
+	if screens.Detail != "low" && screens.Detail != "high" && screens.Detail != "auto" {
+		return fmt.Errorf("the Fetch setting screenshots: detail must be low, high or auto got %s", screens.Detail), nil
+	}
+
+	if s.Fetch.Visual != nil {
+		return errors.New("the Fetch setting screenshots: cannot be used with visual, screenshots already sends no html"), nil
+	}
+
+	if len(s.Fetch.Examples) > 0 {
+		return errors.New("the Fetch setting screenshots: cannot be used with examples, examples pair html with its data"), nil
+	}
+
+	return nil, screens
+}
+
+/*
+Crawl
+
+the rules deciding which links found on a page are followed
+*/
+type Crawl struct {
+	Allow      []*regexp.Regexp
+	Deny       []*regexp.Regexp
+	SameDomain bool
+	MaxDepth   uint8
+	MaxPages   uint16
+	PickLinks  bool
+}
+
+/*
+compilePatterns
+
+compiles the regular expressions of a crawl setting
+*/
+func compilePatterns(setting string, patterns []string) (error, []*regexp.Regexp) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+
+	for index, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return fmt.Errorf("the Fetch setting crawl: %s pattern %s is invalid %v", setting, pattern, err), nil
+		}
+
+		compiled[index] = re
+	}
+
+	return nil, compiled
+}
+
+/*
+buildCrawl
+
+creates the crawl settings with predefined defaults, nil is returned when crawling was not requested
+*/
+func (s *Session) buildCrawl() (error, *Crawl) {
+	config := s.Fetch.Crawl
+
+	if config == nil {
+		return nil, nil
+	}
+
+	crawl := &Crawl{
+		SameDomain: true,
+		MaxDepth:   1,
+		MaxPages:   50,
+	}
+
+	if config.SameDomain != nil {
+		crawl.SameDomain = *config.SameDomain
+	}
+
+	if config.MaxDepth != nil {
+		crawl.MaxDepth = *config.MaxDepth
+	}
+
+	if config.MaxPages != nil {
+		crawl.MaxPages = *config.MaxPages
+	}
+
+	if config.PickLinks != nil {
+		crawl.PickLinks = *config.PickLinks
+	}
+
+	if crawl.MaxPages == 0 {
+		return errors.New("the Fetch setting crawl: maxPages cannot be 0"), nil
+	}
+
+	var err error
+
+	if err, crawl.Allow = compilePatterns("allow", config.Allow); err != nil {
+		return err, nil
+	}
+
+	if err, crawl.Deny = compilePatterns("deny", config.Deny); err != nil {
+		return err, nil
+	}
+
+	return nil, crawl
+}
+
+/*
+Pagination
+
+how the pages of a listing are traversed, traversal ends once MaxPages pages were visited or no new content appears
+*/
+type Pagination struct {
+	Match    *regexp.Regexp // nil matches every url
+	Strategy string
+	Selector string
+	Param    string
+	From     int
+	To       int
+	MaxPages uint16
+	Wait     uint16
+}
+
+/*
+buildPagination
+
+creates the pagination strategies with predefined defaults
+*/
+func (s *Session) buildPagination() (error, []*Pagination) {
+	strategies := make([]*Pagination, len(s.Fetch.Pagination))
+
+	for index, config := range s.Fetch.Pagination {
+		pagination := &Pagination{
+			Strategy: config.Strategy,
+			From:     1,
+			MaxPages: 10,
+			Wait:     2000,
+		}
+
+		if config.Match != nil && *config.Match != "" {
+			re, err := regexp.Compile(*config.Match)
+
+			if err != nil {
+				return fmt.Errorf("the Fetch setting pagination %d: match is invalid %v", index, err), nil
+			}
+
+			pagination.Match = re
+		}
+
+		if config.Selector != nil {
+			pagination.Selector = *config.Selector
+		}
+
+		if config.Param != nil {
+			pagination.Param = *config.Param
+		}
+
+		if config.From != nil {
+			pagination.From = *config.From
+		}
+
+		if config.MaxPages != nil {
+			pagination.MaxPages = *config.MaxPages
+		}
+
+		if config.Wait != nil {
+			pagination.Wait = *config.Wait
+		}
+
+		pagination.To = pagination.From + int(pagination.MaxPages) - 1
+
+		if config.To != nil {
+			pagination.To = *config.To
+		}
+
+		if pagination.MaxPages == 0 {
+			return fmt.Errorf("the Fetch setting pagination %d: maxPages cannot be 0", index), nil
+		}
+
+		switch pagination.Strategy {
+		case "next", "loadMore":
+			if pagination.Selector == "" {
+				return fmt.Errorf("the Fetch setting pagination %d: the %s strategy needs a selector", index, pagination.Strategy), nil
+			}
+		case "pages":
+			if pagination.Param == "" {
+				return fmt.Errorf("the Fetch setting pagination %d: the pages strategy needs a param", index), nil
+			}
+
+			if pagination.To < pagination.From {
+				return fmt.Errorf("the Fetch setting pagination %d: to cannot be less than from", index), nil
+			}
+		case "scroll":
+		default:
+			return fmt.Errorf(
+				"the Fetch setting pagination %d: strategy must be next, pages, loadMore or scroll got %s",
+				index,
+				pagination.Strategy), nil
+		}
+
+		if s.Fetch.Screenshots != nil && (pagination.Strategy == "loadMore" || pagination.Strategy == "scroll") {
+			return fmt.Errorf(
+				"the Fetch setting pagination %d: the %s strategy cannot be used with screenshots, new content "+
+					"cannot be told apart in a screenshot", index, pagination.Strategy), nil
+		}
+
+		strategies[index] = pagination
+	}
+
+	return nil, strategies
+}
+
+/*
+Detail
+
+how the detail pages of the items found on list pages are visited
+*/
+type Detail struct {
+	LinkField string
+	Fields    []string
+	Wait      uint16
+}
+
+/*
+buildDetail
+
+creates the detail page settings with predefined defaults, nil is returned when it was not requested
+*/
+func (s *Session) buildDetail() (error, *Detail) {
+	config := s.Fetch.Detail
+
+	if config == nil {
+		return nil, nil
+	}
+
+	detail := &Detail{
+		LinkField: "link",
+		Fields:    config.Fields,
+		Wait:      2000,
+	}
+
+	if config.LinkField != nil {
+		detail.LinkField = *config.LinkField
+	}
+
+	if config.Wait != nil {
+		detail.Wait = *config.Wait
+	}
+
+	if detail.LinkField == "" {
+		return errors.New("the Fetch setting detail: linkField cannot be blank"), nil
+	}
+
+	for _, field := range detail.Fields {
+		if _, ok := s.Fetch.ExampleTemplate[field]; !ok {
+			return fmt.Errorf("the Fetch setting detail: field %s is not in the exampleTemplate", field), nil
+		}
+
+		if field == detail.LinkField {
+			return fmt.Errorf("the Fetch setting detail: the link field %s cannot be a detail field", field), nil
+		}
+	}
+
+	return nil, detail
+}
+
+/*
+Politeness
+
+limits keeping the session considerate towards the sites it visits
+*/
+type Politeness struct {
+	UserAgent       string
+	ObeyRobots      bool
+	HostConcurrency uint8
+	MinInterval     time.Duration
+}
+
+/*
+buildPoliteness
+
+creates the politeness settings, the defaults apply when the setting is missing so every session obeys robots.txt
+unless told otherwise
+*/
+func (s *Session) buildPoliteness() (error, *Politeness) {
+	polite := &Politeness{
+		UserAgent:       "huan/1.0 (compatible; data collection bot)",
+		ObeyRobots:      true,
+		HostConcurrency: 2,
+		MinInterval:     time.Second,
+	}
+
+	config := s.Fetch.Politeness
+
+	if config == nil {
+		return nil, polite
+	}
+
+	if config.UserAgent != nil {
+		polite.UserAgent = strings.TrimSpace(*config.UserAgent)
+	}
+
+	if config.ObeyRobots != nil {
+		polite.ObeyRobots = *config.ObeyRobots
+	}
+
+	if config.HostConcurrency != nil {
+		polite.HostConcurrency = *config.HostConcurrency
+	}
+
+	if config.MinInterval != nil {
+		polite.MinInterval = time.Duration(*config.MinInterval) * time.Millisecond
+	}
+
+	if polite.UserAgent == "" {
+		return errors.New("the Fetch setting politeness: userAgent cannot be blank"), nil
+	}
+
+	if polite.HostConcurrency == 0 {
+		return errors.New("the Fetch setting politeness: hostConcurrency cannot be 0"), nil
+	}
+
+	return nil, polite
+}
+
+/*
+Seeds
+
+the sources the starting urls of a session are expanded from
+*/
+type Seeds struct {
+	Sitemaps []string
+	Files    []string
+	Column   string
+	Match    *regexp.Regexp // nil keeps every url
+	MaxUrls  uint32
+}
+
+/*
+buildSeeds
+
+creates the url sources of a session, the defaults apply when the setting is missing so templates in urls are always
+expanded within the url limit
+*/
+func (s *Session) buildSeeds() (error, *Seeds) {
+	seeds := &Seeds{
+		Column:  "url",
+		MaxUrls: 10_000,
+	}
+
+	config := s.Fetch.Seeds
+
+	if config == nil {
+		return nil, seeds
+	}
+
+	seeds.Sitemaps = config.Sitemaps
+	seeds.Files = config.Files
+
+	if config.Column != nil {
+		seeds.Column = strings.TrimSpace(*config.Column)
+	}
+
+	if config.MaxUrls != nil {
+		seeds.MaxUrls = *config.MaxUrls
+	}
+
+	if config.Match != nil && *config.Match != "" {
+		re, err := regexp.Compile(*config.Match)
+
+		if err != nil {
+			return fmt.Errorf("the Fetch setting seeds: match pattern %s is invalid %v", *config.Match, err), nil
+		}
+
+		seeds.Match = re
+	}
+
+	if seeds.Column == "" {
+		return errors.New("the Fetch setting seeds: column cannot be blank"), nil
+	}
+
+	if seeds.MaxUrls == 0 {
+		return errors.New("the Fetch setting seeds: maxUrls cannot be 0"), nil
+	}
+
+	for _, file := range seeds.Files {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("the Fetch setting seeds: cannot read url file %v", err), nil
+		}
+	}
+
+	return nil, seeds
+}
+
+/*
+Chunking
+
+how the html of a page is split into chunks, elements are only split when they do not fit in a chunk on their own
+*/
+type Chunking struct {
+	Tokens  uint32
+	Overlap float64
+}
+
+/*
+buildChunking
+
+creates the chunking settings, the defaults apply when the setting is missing
+*/
+func (s *Session) buildChunking() (error, *Chunking) {
+	chunking := &Chunking{
+		Tokens: 40_000,
+	}
+
+	config := s.Fetch.Chunking
+
+	if config == nil {
+		return nil, chunking
+	}
+
+	if config.Tokens != nil {
+		chunking.Tokens = *config.Tokens
+	}
+
+	if config.Overlap != nil {
+		chunking.Overlap = *config.Overlap
+	}
+
+	if chunking.Tokens < 1000 {
+		return fmt.Errorf("the Fetch setting chunking: tokens must be at least 1000 got %d", chunking.Tokens), nil
+	}
+
+	if chunking.Overlap < 0 || chunking.Overlap >= 0.5 {
+		return fmt.Errorf("the Fetch setting chunking: overlap must be at least 0 and below 0.5 got %f", chunking.Overlap), nil
+	}
+
+	return nil, chunking
+}
+
 type Fetch struct {
 	MaxRuntime      uint32
+	GracePeriod     time.Duration
 	Headless        bool
 	MaxSamples      uint16
 	Urls            []string
@@ -74,6 +858,120 @@ type Fetch struct {
 	SavePath        string
 	ExampleTemplate map[string]interface{}
 	Workers         uint8
+	Confidence      *Confidence
+	Consistency     *Consistency
+	Prompt          *template.Template // the collection prompt, nil uses the default
+	SystemPrompt    *template.Template // the system prompt, nil uses the default
+	Examples        []Example
+	Visual          *Visual
+	Screenshots     *Screenshots // collect from viewport screenshots instead of html, nil when disabled
+	Crawl           *Crawl       // follow links found on the pages, nil when disabled
+	Pagination      []*Pagination
+	Detail          *Detail // visit the detail page of every item, nil when disabled
+	Politeness      *Politeness
+	Seeds           *Seeds
+	Chunking        *Chunking
+}
+
+/*
+buildConfidence
+
+creates the confidence settings with predefined defaults, nil is returned when confidence scoring was not requested
+*/
+func (s *Session) buildConfidence() (error, *Confidence) {
+	if s.Fetch.Confidence == nil {
+		return nil, nil
+	}
+
+	conf := &Confidence{
+		Threshold: 0.5,
+		Action:    "flag",
+	}
+
+	if s.Fetch.Confidence.Threshold != nil {
+		conf.Threshold = *s.Fetch.Confidence.Threshold
+	}
+
+	if conf.Threshold <= 0 || conf.Threshold > 1 {
+		return fmt.Errorf("the Fetch setting confidence: threshold must be between 0 and 1 got %f", conf.Threshold), nil
+	}
+
+	if s.Fetch.Confidence.Action != nil {
+		conf.Action = *s.Fetch.Confidence.Action
+	}
+
+	if conf.Action != "flag" && conf.Action != "drop" {
+		return fmt.Errorf("the Fetch setting confidence: action must be flag or drop got %s", conf.Action), nil
+	}
+
+	return nil, conf
+}
+
+/*
+buildConsistency
+
+creates the self consistency settings with predefined defaults, nil is returned when it was not requested
+*/
+func (s *Session) buildConsistency() (error, *Consistency) {
+	if s.Fetch.Consistency == nil {
+		return nil, nil
+	}
+
+	cons := &Consistency{
+		Samples:      3,
+		Strategy:     "n",
+		MinAgreement: 0.5,
+	}
+
+	if s.Fetch.Consistency.Samples != nil {
+		cons.Samples = *s.Fetch.Consistency.Samples
+	}
+
+	if cons.Samples < 2 {
+		return errors.New("the Fetch setting consistency: samples must be at least 2"), nil
+	}
+
+	if s.Fetch.Consistency.Strategy != nil {
+		cons.Strategy = *s.Fetch.Consistency.Strategy
+	}
+
+	if s.Fetch.Consistency.MinAgreement != nil {
+		cons.MinAgreement = *s.Fetch.Consistency.MinAgreement
+	}
+
+	if cons.MinAgreement <= 0 || cons.MinAgreement > 1 {
+		return fmt.Errorf(
+			"the Fetch setting consistency: minAgreement must be between 0 and 1 got %f",
+			cons.MinAgreement), nil
+	}
+
+	switch cons.Strategy {
+	case "n":
+		if len(s.Fetch.Consistency.Temperatures) != 0 {
+			return errors.New("the Fetch setting consistency: temperatures require the temperature strategy"), nil
+		}
+	case "temperature":
+		cons.Temperatures = s.Fetch.Consistency.Temperatures
+
+		if len(cons.Temperatures) == 0 {
+			// spread the samples evenly between a low and a high temperature
+			cons.Temperatures = make([]float32, cons.Samples)
+			for index := range cons.Temperatures {
+				cons.Temperatures[index] = 0.2 + 0.8*float32(index)/float32(cons.Samples-1)
+			}
+		}
+
+		if len(cons.Temperatures) != int(cons.Samples) {
+			return fmt.Errorf(
+				"the Fetch setting consistency: expected %d temperatures got %d",
+				cons.Samples,
+				len(cons.Temperatures)), nil
+		}
+	default:
+		return fmt.Errorf("the Fetch setting consistency: strategy must be n or temperature got %s", cons.Strategy), nil
+	}
+
+	return nil, cons
 }
 
 func (s *Session) BuildFetchSettings() (error, *Fetch) {
@@ -87,6 +985,12 @@ func (s *Session) BuildFetchSettings() (error, *Fetch) {
 		s.Fetch.MaxRuntime = &runTime //run for 16 minutes
 	}
 
+	gracePeriod := 30 * time.Second
+
+	if s.Fetch.GracePeriod != nil {
+		gracePeriod = time.Duration(*s.Fetch.GracePeriod) * time.Second
+	}
+
 	if s.Fetch.MaxSamples != nil && *s.Fetch.MaxSamples == 0 {
 		return errors.New("the Fetch setting: maxSamples cannot be 0"), nil
 	}
@@ -102,7 +1006,7 @@ func (s *Session) BuildFetchSettings() (error, *Fetch) {
 				return fmt.Errorf("the Fetch setting: url is invalid %s", url), nil
 			}
 		}
-	} else {
+	} else if s.Fetch.Seeds == nil || len(s.Fetch.Seeds.Sitemaps)+len(s.Fetch.Seeds.Files) == 0 {
 		return errors.New("the Fetch setting: urls is empty"), nil
 	}
 
@@ -139,8 +1043,91 @@ func (s *Session) BuildFetchSettings() (error, *Fetch) {
 		}
 	}
 
+	err, confidence := s.buildConfidence()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, consistency := s.buildConsistency()
+
+	if err != nil {
+		return err, nil
+	}
+
+	var prompt, systemPrompt *template.Template
+
+	if s.Fetch.Prompt != nil {
+		if err, prompt = loadPromptTemplate("prompt", *s.Fetch.Prompt); err != nil {
+			return err, nil
+		}
+	}
+
+	if s.Fetch.SystemPrompt != nil {
+		if err, systemPrompt = loadPromptTemplate("systemPrompt", *s.Fetch.SystemPrompt); err != nil {
+			return err, nil
+		}
+	}
+
+	err, visual := s.buildVisual()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, screenshots := s.buildScreenshots()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, crawl := s.buildCrawl()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, pagination := s.buildPagination()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, detail := s.buildDetail()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, politeness := s.buildPoliteness()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, seeds := s.buildSeeds()
+
+	if err != nil {
+		return err, nil
+	}
+
+	err, chunking := s.buildChunking()
+
+	if err != nil {
+		return err, nil
+	}
+
+	examples := make([]Example, len(s.Fetch.Examples))
+
+	for index, example := range s.Fetch.Examples {
+		if err, examples[index] = buildExample(index, example.Html, example.Output); err != nil {
+			return err, nil
+		}
+	}
+
 	return nil, &Fetch{
 		MaxRuntime:      *s.Fetch.MaxRuntime,
+		GracePeriod:     gracePeriod,
 		Headless:        s.Fetch.Headless,
 		MaxSamples:      *s.Fetch.MaxSamples,
 		Urls:            s.Fetch.Urls,
@@ -148,5 +1135,18 @@ func (s *Session) BuildFetchSettings() (error, *Fetch) {
 		SavePath:        *s.Fetch.SavePath,
 		ExampleTemplate: s.Fetch.ExampleTemplate,
 		Workers:         *s.Fetch.Workers,
+		Confidence:      confidence,
+		Consistency:     consistency,
+		Prompt:          prompt,
+		SystemPrompt:    systemPrompt,
+		Examples:        examples,
+		Visual:          visual,
+		Screenshots:     screenshots,
+		Crawl:           crawl,
+		Pagination:      pagination,
+		Detail:          detail,
+		Politeness:      politeness,
+		Seeds:           seeds,
+		Chunking:        chunking,
 	}
 }